@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/togha"
+)
+
+// Config is a set of operations exposed by admin endpoints.
+type Config struct {
+	// Token is required as "Authorization: Bearer <Token>". endpoints are not mounted if empty.
+	Token string
+	// DeadLetters enables /deadletters endpoints. optional.
+	DeadLetters *togha.DeadLetterStore
+	// Dispatcher is used to replay dead letters.
+	Dispatcher togha.EventDispatcher
+	// Verifier enables /receivers/verify endpoint. optional.
+	Verifier togha.ReceiverVerifier
+}
+
+// Mount mounts admin endpoints under prefix. e.g. /admin
+//   - GET    {prefix}/deadletters              list dead letters
+//   - DELETE {prefix}/deadletters              purge all dead letters
+//   - GET    {prefix}/deadletters/{id}         show a dead letter
+//   - DELETE {prefix}/deadletters/{id}         purge a dead letter
+//   - POST   {prefix}/deadletters/{id}/replay  replay a dead letter. ?to=owner/name sends it to another receiver
+//   - GET    {prefix}/receivers/verify          check receivers can get events
+func Mount(ctx context.Context, mux *http.ServeMux, prefix string, cfg *Config) error {
+	if cfg == nil || cfg.Token == "" {
+		return errors.New("admin token is required")
+	}
+
+	h := &handler{cfg: cfg, prefix: prefix}
+	mux.Handle(prefix+"/", h.authorize(http.HandlerFunc(h.route)))
+
+	return nil
+}
+
+type handler struct {
+	cfg    *Config
+	prefix string
+}
+
+func (h *handler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.Token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) route(w http.ResponseWriter, r *http.Request) {
+	ss := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, h.prefix), "/"), "/")
+
+	switch {
+	case ss[0] == "deadletters" && h.cfg.DeadLetters != nil:
+		h.deadLetters(w, r, ss[1:])
+	case len(ss) == 2 && ss[0] == "receivers" && ss[1] == "verify" && r.Method == http.MethodGet && h.cfg.Verifier != nil:
+		writeJSON(r.Context(), w, http.StatusOK, h.cfg.Verifier.VerifyReceivers(r.Context()))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (h *handler) deadLetters(w http.ResponseWriter, r *http.Request, ss []string) {
+	ctx := r.Context()
+	store := h.cfg.DeadLetters
+
+	switch {
+	case len(ss) == 0 && r.Method == http.MethodGet:
+		dls, err := store.List()
+		if err != nil {
+			writeError(ctx, w, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusOK, dls)
+
+	case len(ss) == 0 && r.Method == http.MethodDelete:
+		n, err := store.Purge()
+		if err != nil {
+			writeError(ctx, w, err)
+			return
+		}
+		log.Infof(ctx, "dead letters purged: %d", n)
+		writeJSON(ctx, w, http.StatusOK, map[string]int{"purged": n})
+
+	case len(ss) == 1 && r.Method == http.MethodGet:
+		dl, err := store.Get(ss[0])
+		if err != nil {
+			writeError(ctx, w, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusOK, dl)
+
+	case len(ss) == 1 && r.Method == http.MethodDelete:
+		if err := store.Remove(ss[0]); err != nil {
+			writeError(ctx, w, err)
+			return
+		}
+		log.Infof(ctx, "dead letter purged: %s", ss[0])
+		writeJSON(ctx, w, http.StatusOK, map[string]int{"purged": 1})
+
+	case len(ss) == 2 && ss[1] == "replay" && r.Method == http.MethodPost:
+		if h.cfg.Dispatcher == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res, err := store.Replay(ctx, ss[0], h.cfg.Dispatcher, r.URL.Query()["to"])
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(ctx, w, err)
+			return
+		}
+		log.Infof(ctx, "dead letter replayed: %s", ss[0])
+		togha.WriteDispatchResult(ctx, w, res, err)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+func writeError(ctx context.Context, w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, os.ErrNotExist) {
+		status = http.StatusNotFound
+	} else {
+		log.Warnf(ctx, "%s", err)
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(err.Error()))
+}
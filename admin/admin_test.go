@@ -0,0 +1,104 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vvakame/se2gha/togha"
+)
+
+type testRequest struct{}
+
+func (req *testRequest) EventType() (string, error) {
+	return "test-event", nil
+}
+
+func (req *testRequest) Payload() (json.RawMessage, error) {
+	return json.RawMessage(`{}`), nil
+}
+
+type testVerifier struct{}
+
+func (v *testVerifier) VerifyReceivers(ctx context.Context) []*togha.ReceiverVerification {
+	return []*togha.ReceiverVerification{
+		{Receiver: "vvakame/missing", Problems: []string{"repository is not found, or the credential can not see it"}},
+	}
+}
+
+func TestMount_verifyReceivers(t *testing.T) {
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	err := Mount(ctx, mux, "/admin", &Config{Token: "secret", Verifier: &testVerifier{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/receivers/verify", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+	}
+
+	var vs []*togha.ReceiverVerification
+	if err := json.Unmarshal(w.Body.Bytes(), &vs); err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 || vs[0].Receiver != "vvakame/missing" || len(vs[0].Problems) != 1 {
+		t.Errorf("unexpected verifications: %s", w.Body.String())
+	}
+}
+
+func TestMount_deadLetters(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := togha.NewDeadLetterStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl, err := store.Add(&testRequest{}, nil, errors.New("github is down"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	err = Mount(ctx, mux, "/admin", &Config{Token: "secret", DeadLetters: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"no token", http.MethodGet, "/admin/deadletters", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/admin/deadletters", "wrong", http.StatusUnauthorized},
+		{"list", http.MethodGet, "/admin/deadletters", "secret", http.StatusOK},
+		{"show", http.MethodGet, "/admin/deadletters/" + dl.ID, "secret", http.StatusOK},
+		{"replay without dispatcher", http.MethodPost, "/admin/deadletters/" + dl.ID + "/replay", "secret", http.StatusNotFound},
+		{"purge", http.MethodDelete, "/admin/deadletters/" + dl.ID, "secret", http.StatusOK},
+		{"show purged", http.MethodGet, "/admin/deadletters/" + dl.ID, "secret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("unexpected status: %d, %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
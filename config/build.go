@@ -0,0 +1,309 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/togha"
+)
+
+func (cfg *Config) mode() string {
+	if cfg.Dispatch == nil || cfg.Dispatch.Mode == "" {
+		return ModeGitHub
+	}
+
+	return cfg.Dispatch.Mode
+}
+
+// DryRun reports whether dispatch.mode is dry_run.
+func (cfg *Config) DryRun() bool {
+	return cfg.mode() == ModeDryRun
+}
+
+// DryRunDebugEndpoint reports whether recent dry-run records should be served.
+func (cfg *Config) DryRunDebugEndpoint() bool {
+	return cfg.DryRun() && cfg.Dispatch.DryRun != nil && cfg.Dispatch.DryRun.DebugEndpoint
+}
+
+// Port returns server.port. it is empty if omitted.
+func (cfg *Config) Port() string {
+	if cfg.Server == nil {
+		return ""
+	}
+
+	return cfg.Server.Port
+}
+
+// OutboxDir returns dispatch.outbox_dir. it is empty if omitted.
+func (cfg *Config) OutboxDir() string {
+	if cfg.Dispatch == nil {
+		return ""
+	}
+
+	return cfg.Dispatch.OutboxDir
+}
+
+// AdminToken returns server.admin_token. it is empty if omitted.
+func (cfg *Config) AdminToken() string {
+	if cfg.Server == nil {
+		return ""
+	}
+
+	return cfg.Server.AdminToken
+}
+
+// DeadLetterDir returns dispatch.dead_letter_dir. it is empty if omitted.
+func (cfg *Config) DeadLetterDir() string {
+	if cfg.Dispatch == nil {
+		return ""
+	}
+
+	return cfg.Dispatch.DeadLetterDir
+}
+
+// VerifyMode returns dispatch.verify_receivers. invalid value is reported by validation.
+func (cfg *Config) VerifyMode() togha.VerifyMode {
+	var s string
+	if cfg.Dispatch != nil {
+		s = cfg.Dispatch.VerifyReceivers
+	}
+	mode, err := togha.ParseVerifyMode(s)
+	if err != nil {
+		return togha.VerifyWarn
+	}
+
+	return mode
+}
+
+// ReceiverRepos converts receivers. invalid receivers are skipped, they are reported by validation.
+// base_url and upload_url are inherited from the credential.
+func (cfg *Config) ReceiverRepos() []*togha.ReceiverRepo {
+	repos := make([]*togha.ReceiverRepo, 0, len(cfg.Receivers))
+	for _, rc := range cfg.Receivers {
+		if rc == nil {
+			continue
+		}
+		repo, err := cfg.receiverRepo(rc)
+		if err != nil {
+			continue
+		}
+		repos = append(repos, repo)
+	}
+
+	return repos
+}
+
+func (cfg *Config) RoutingRules() []*togha.RoutingRule {
+	rules := make([]*togha.RoutingRule, 0, len(cfg.Routing))
+	for _, rc := range cfg.Routing {
+		if rc == nil {
+			rc = &RoutingRuleConfig{}
+		}
+		rules = append(rules, &togha.RoutingRule{
+			Name:            rc.Name,
+			Source:          rc.Source,
+			EventType:       rc.EventType,
+			EventTypeRegexp: rc.EventTypeRegexp,
+			Attributes:      rc.Attributes,
+			Receivers:       rc.Receivers,
+		})
+	}
+
+	return rules
+}
+
+func (cfg *Config) NormalizeConfig() *togha.NormalizeConfig {
+	if cfg.Dispatch == nil {
+		return &togha.NormalizeConfig{}
+	}
+
+	return &togha.NormalizeConfig{
+		EventTypePrefix: cfg.Dispatch.EventTypePrefix,
+		PayloadOverflow: togha.PayloadOverflowPolicy(cfg.Dispatch.PayloadOverflow),
+	}
+}
+
+// EventDispatcherConfig builds a config for togha.NewEventDispatcher. no environment variables are consulted.
+func (cfg *Config) EventDispatcherConfig(ctx context.Context) (*togha.EventDispatcherConfig, error) {
+	providers := make(map[string]togha.GitHubClientProvider)
+	for _, name := range sortedKeys(cfg.Credentials) {
+		cc := cfg.Credentials[name]
+		if cc.Token != "" {
+			provider, err := togha.NewEnterpriseTokenGitHubClientProvider(ctx, cc.Token, cc.BaseURL, cc.UploadURL)
+			if err != nil {
+				return nil, fmt.Errorf("credentials.%s: %w", name, err)
+			}
+			providers[name] = provider
+			continue
+		}
+
+		privateKey := []byte(cc.PrivateKey)
+		if cc.PrivateKeyFile != "" {
+			b, err := os.ReadFile(cc.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("credentials.%s: %w", name, err)
+			}
+			privateKey = b
+		}
+		provider, err := togha.NewGitHubAppClientProvider(&togha.GitHubAppConfig{
+			AppID:      cc.AppID,
+			PrivateKey: privateKey,
+			BaseURL:    cc.BaseURL,
+			UploadURL:  cc.UploadURL,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("credentials.%s: %w", name, err)
+		}
+		providers[name] = provider
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("credentials are required")
+	}
+	defaultName, _ := cfg.defaultCredentialName()
+
+	limiter, err := togha.NewLimiter(cfg.LimitConfig())
+	if err != nil {
+		return nil, fmt.Errorf("dispatch: %w", err)
+	}
+
+	retry := togha.DefaultRetryConfig
+	if cfg.Dispatch != nil {
+		if v := cfg.Dispatch.Deadline; v != 0 {
+			retry.Deadline = time.Duration(v)
+		}
+	}
+
+	dspCfg := &togha.EventDispatcherConfig{
+		GitHubClients: &togha.MultiGitHubClientProvider{
+			Providers: providers,
+			Default:   defaultName,
+		},
+		ReceiverRepos: cfg.ReceiverRepos(),
+		RoutingRules:  cfg.RoutingRules(),
+		Retry:         &retry,
+		Normalize:     cfg.NormalizeConfig(),
+		Limiter:       limiter,
+	}
+	if dedupTTL := cfg.dedupTTL(); dedupTTL > 0 {
+		dspCfg.Dedup = togha.NewMemoryDedupStore()
+		dspCfg.DedupTTL = dedupTTL
+	} else {
+		dspCfg.DisableDedup = true
+	}
+	dspCfg.Callback, err = cfg.CallbackConfig()
+	if err != nil {
+		return nil, fmt.Errorf("dispatch.callback: %w", err)
+	}
+	if dspCfg.Callback == nil {
+		dspCfg.DisableCallback = true
+	}
+
+	return dspCfg, nil
+}
+
+// dedupTTL is dispatch.dedup_ttl, or togha.DefaultDedupTTL if omitted. 0 disables deduplication.
+func (cfg *Config) dedupTTL() time.Duration {
+	if cfg.Dispatch != nil && cfg.Dispatch.DedupTTL != nil {
+		return time.Duration(*cfg.Dispatch.DedupTTL)
+	}
+
+	return togha.DefaultDedupTTL
+}
+
+// CallbackConfig builds a config of callback tokens. it is nil if dispatch.callback is omitted.
+func (cfg *Config) CallbackConfig() (*togha.CallbackConfig, error) {
+	if cfg.Dispatch == nil || cfg.Dispatch.Callback == nil {
+		return nil, nil
+	}
+	cc := cfg.Dispatch.Callback
+	signer, err := togha.NewCallbackSigner(cc.Secret, time.Duration(cc.TTL))
+	if err != nil {
+		return nil, err
+	}
+	if cc.URL == "" {
+		return nil, errors.New("url is required")
+	}
+
+	return &togha.CallbackConfig{
+		Signer: signer,
+		URL:    cc.URL,
+	}, nil
+}
+
+// LimitConfig builds a config for togha.NewLimiter.
+func (cfg *Config) LimitConfig() *togha.LimitConfig {
+	limitCfg := togha.DefaultLimitConfig
+	if cfg.Dispatch == nil {
+		return &limitCfg
+	}
+	if v := cfg.Dispatch.MaxConcurrency; v != nil {
+		limitCfg.MaxConcurrency = *v
+	}
+	limitCfg.RatePerSecond = cfg.Dispatch.RateLimit
+	limitCfg.Burst = cfg.Dispatch.RateBurst
+
+	return &limitCfg
+}
+
+// DryRunConfig builds a config for togha.NewDryRunEventDispatcher.
+// deduplication and callbacks follow dispatch, so records match what is sent without dry-run.
+func (cfg *Config) DryRunConfig() (*togha.DryRunConfig, error) {
+	dryRunCfg := &togha.DryRunConfig{
+		ReceiverRepos: cfg.ReceiverRepos(),
+		RoutingRules:  cfg.RoutingRules(),
+		Normalize:     cfg.NormalizeConfig(),
+	}
+	if cfg.Dispatch != nil && cfg.Dispatch.DryRun != nil {
+		dryRunCfg.RecordFile = cfg.Dispatch.DryRun.File
+	}
+	if dedupTTL := cfg.dedupTTL(); dedupTTL > 0 {
+		dryRunCfg.Dedup = togha.NewMemoryDedupStore()
+		dryRunCfg.DedupTTL = dedupTTL
+	} else {
+		dryRunCfg.DisableDedup = true
+	}
+	var err error
+	dryRunCfg.Callback, err = cfg.CallbackConfig()
+	if err != nil {
+		return nil, fmt.Errorf("dispatch.callback: %w", err)
+	}
+	if dryRunCfg.Callback == nil {
+		dryRunCfg.DisableCallback = true
+	}
+
+	return dryRunCfg, nil
+}
+
+// SourceConfig builds a config for source.MountAll.
+// if sources are omitted, all registered sources are enabled.
+func (cfg *Config) SourceConfig() *source.Config {
+	srcCfg := &source.Config{
+		Paths:    make(map[string]string),
+		Settings: make(map[string]source.Settings),
+	}
+	if cfg.Sources == nil {
+		return srcCfg
+	}
+
+	srcCfg.Enabled = []string{}
+	for _, name := range sortedKeys(cfg.Sources) {
+		sc := cfg.Sources[name]
+		if sc == nil {
+			sc = &SourceConfig{}
+		}
+		if sc.Enabled != nil && !*sc.Enabled {
+			continue
+		}
+		srcCfg.Enabled = append(srcCfg.Enabled, name)
+		if sc.Path != "" {
+			srcCfg.Paths[name] = sc.Path
+		}
+		srcCfg.Settings[name] = sc.Settings
+	}
+
+	return srcCfg
+}
@@ -0,0 +1,276 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a declarative configuration of se2gha.
+// it is written in YAML, JSON is also accepted as a subset of YAML.
+type Config struct {
+	Server      *ServerConfig                `yaml:"server"`
+	Sources     map[string]*SourceConfig     `yaml:"sources"`
+	Credentials map[string]*CredentialConfig `yaml:"credentials"`
+	Receivers   []*ReceiverConfig            `yaml:"receivers"`
+	Routing     []*RoutingRuleConfig         `yaml:"routing"`
+	Dispatch    *DispatchConfig              `yaml:"dispatch"`
+
+	path string
+	root *yaml.Node
+}
+
+type ServerConfig struct {
+	Port string `yaml:"port"`
+	// AdminToken enables /admin endpoints. they require "Authorization: Bearer <AdminToken>".
+	AdminToken string `yaml:"admin_token"`
+}
+
+type SourceConfig struct {
+	// Enabled is true if omitted.
+	Enabled  *bool             `yaml:"enabled"`
+	Path     string            `yaml:"path"`
+	Settings map[string]string `yaml:"settings"`
+}
+
+// CredentialConfig is either a token or a GitHub App.
+type CredentialConfig struct {
+	Token string `yaml:"token"`
+
+	AppID          int64  `yaml:"app_id"`
+	PrivateKey     string `yaml:"private_key"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+
+	// BaseURL is an API endpoint of GitHub Enterprise Server. e.g. https://github.example.com/api/v3/
+	// github.com is used if omitted.
+	BaseURL   string `yaml:"base_url"`
+	UploadURL string `yaml:"upload_url"`
+}
+
+type ReceiverConfig struct {
+	// Repo is "owner/name".
+	Repo       string `yaml:"repo"`
+	Credential string `yaml:"credential"`
+	// Target is repository_dispatch or workflow_dispatch. default is repository_dispatch.
+	Target   string   `yaml:"target"`
+	Workflow string   `yaml:"workflow"`
+	Ref      string   `yaml:"ref"`
+	Inputs   []string `yaml:"inputs"`
+	// BaseURL and UploadURL are inherited from the credential if omitted.
+	BaseURL   string `yaml:"base_url"`
+	UploadURL string `yaml:"upload_url"`
+}
+
+type RoutingRuleConfig struct {
+	Name            string            `yaml:"name"`
+	Source          string            `yaml:"source"`
+	EventType       string            `yaml:"event_type"`
+	EventTypeRegexp string            `yaml:"event_type_regexp"`
+	Attributes      map[string]string `yaml:"attributes"`
+	Receivers       []string          `yaml:"receivers"`
+}
+
+type DispatchConfig struct {
+	// Mode is github or dry_run. default is github.
+	Mode            string        `yaml:"mode"`
+	EventTypePrefix string        `yaml:"event_type_prefix"`
+	PayloadOverflow string        `yaml:"payload_overflow"`
+	Deadline        Duration      `yaml:"deadline"`
+	DedupTTL        *Duration     `yaml:"dedup_ttl"`
+	OutboxDir       string        `yaml:"outbox_dir"`
+	DeadLetterDir   string        `yaml:"dead_letter_dir"`
+	DryRun          *DryRunConfig `yaml:"dry_run"`
+	// MaxConcurrency is the upper bound of simultaneous GitHub API calls. 0 means unlimited. default is 10.
+	MaxConcurrency *int `yaml:"max_concurrency"`
+	// RateLimit is GitHub API calls per second shared by all receivers. 0 means unlimited.
+	RateLimit float64 `yaml:"rate_limit"`
+	RateBurst int     `yaml:"rate_burst"`
+	// VerifyReceivers is off, warn or strict. default is warn.
+	VerifyReceivers string `yaml:"verify_receivers"`
+	// Callback lets workflows report results back to the source.
+	Callback *CallbackConfig `yaml:"callback"`
+}
+
+type CallbackConfig struct {
+	// Secret signs callback tokens. 32 bytes or more.
+	Secret string `yaml:"secret"`
+	// URL is the callback endpoint which workflows call. e.g. https://se2gha.example.com/callback
+	URL string   `yaml:"url"`
+	TTL Duration `yaml:"ttl"`
+}
+
+type DryRunConfig struct {
+	File          string `yaml:"file"`
+	DebugEndpoint bool   `yaml:"debug_endpoint"`
+}
+
+const (
+	ModeGitHub = "github"
+	ModeDryRun = "dry_run"
+)
+
+// Duration accepts time.ParseDuration format. e.g. 30s
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", value.Line, s)
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+// Error is a problem at a position of the config file.
+type Error struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (err *Error) Error() string {
+	if err.Line == 0 {
+		return fmt.Sprintf("%s: %s", err.Path, err.Msg)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", err.Path, err.Line, err.Msg)
+}
+
+// Errors is all problems found in the config file.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	ss := make([]string, 0, len(errs))
+	for _, err := range errs {
+		ss = append(ss, err.Error())
+	}
+
+	return strings.Join(ss, "\n")
+}
+
+// Load reads, interpolates and validates the config file.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(path, b)
+}
+
+// Parse is Load for in-memory content. path is used in error messages.
+func Parse(path string, b []byte) (*Config, error) {
+	root := &yaml.Node{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	err := dec.Decode(root)
+	if errors.Is(err, io.EOF) {
+		return nil, &Error{Path: path, Msg: "config is empty"}
+	} else if err != nil {
+		return nil, &Error{Path: path, Msg: err.Error()}
+	}
+
+	var errs Errors
+	add := func(line int, format string, a ...interface{}) {
+		errs = append(errs, &Error{Path: path, Line: line, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	checkKnownFields(root, reflect.TypeOf(Config{}), "", add)
+	interpolateNode(root, add)
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	cfg := &Config{
+		path: path,
+		root: root,
+	}
+	err = root.Decode(cfg)
+	if err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				errs = append(errs, &Error{Path: path, Msg: msg})
+			}
+			return nil, errs
+		}
+		return nil, &Error{Path: path, Msg: err.Error()}
+	}
+
+	cfg.validate(add)
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return cfg, nil
+}
+
+// Path returns the file path of the config.
+func (cfg *Config) Path() string {
+	return cfg.path
+}
+
+// line returns line number of the node at path. e.g. "receivers", 0, "repo"
+// it returns the nearest ancestor line if the node does not exist.
+func (cfg *Config) line(path ...interface{}) int {
+	node := cfg.root
+	if node == nil {
+		return 0
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) != 0 {
+		node = node.Content[0]
+	}
+
+	line := node.Line
+	for _, p := range path {
+		var next *yaml.Node
+		switch p := p.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return line
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == p {
+					next = node.Content[i+1]
+					line = node.Content[i].Line
+					break
+				}
+			}
+		case int:
+			if node.Kind != yaml.SequenceNode || p >= len(node.Content) {
+				return line
+			}
+			next = node.Content[p]
+			line = next.Line
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+	}
+
+	return line
+}
+
+func sortedKeys(m interface{}) []string {
+	rv := reflect.ValueOf(m)
+	keys := make([]string, 0, rv.Len())
+	for _, key := range rv.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/vvakame/se2gha/kintone_event"
+	_ "github.com/vvakame/se2gha/slack_event"
+)
+
+const testConfig = `
+server:
+  port: "8080"
+sources:
+  slack:
+    settings:
+      access_token: ${TEST_SLACK_ACCESS_TOKEN}
+      signing_secret: ${TEST_SLACK_SIGNING_SECRET:-secret}
+  kintone:
+    enabled: false
+credentials:
+  default:
+    token: ${file:%s}
+receivers:
+  - repo: vvakame/se2gha
+  - repo: vvakame/workflows
+    target: workflow_dispatch
+    workflow: issue-from-slack.yml
+    ref: master
+    inputs: [event, user]
+routing:
+  - name: issue
+    source: slack
+    event_type: slack-event-reaction_added-*
+    receivers: ["vvakame/workflows:issue-from-slack.yml@master"]
+dispatch:
+  event_type_prefix: se2gha-
+  deadline: 10s
+  dedup_ttl: 1h
+`
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	err := os.WriteFile(tokenFile, []byte("ghp_xxx\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SLACK_ACCESS_TOKEN", "xoxb-xxx")
+
+	cfg, err := Parse("se2gha.yaml", []byte(strings.Replace(testConfig, "%s", tokenFile, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := cfg.Credentials["default"].Token; v != "ghp_xxx" {
+		t.Errorf("unexpected token: %q", v)
+	}
+	if v := cfg.Sources["slack"].Settings["access_token"]; v != "xoxb-xxx" {
+		t.Errorf("unexpected access_token: %q", v)
+	}
+	if v := cfg.Sources["slack"].Settings["signing_secret"]; v != "secret" {
+		t.Errorf("unexpected signing_secret: %q", v)
+	}
+
+	srcCfg := cfg.SourceConfig()
+	if len(srcCfg.Enabled) != 1 || srcCfg.Enabled[0] != "slack" {
+		t.Errorf("unexpected enabled sources: %v", srcCfg.Enabled)
+	}
+
+	dspCfg, err := cfg.EventDispatcherConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dspCfg.ReceiverRepos) != 2 {
+		t.Fatalf("unexpected receivers: %v", dspCfg.ReceiverRepos)
+	}
+	if v := dspCfg.ReceiverRepos[1].String(); v != "vvakame/workflows:issue-from-slack.yml@master" {
+		t.Errorf("unexpected receiver: %s", v)
+	}
+	if v := dspCfg.Retry.Deadline; v != 10*time.Second {
+		t.Errorf("unexpected deadline: %s", v)
+	}
+	if v := dspCfg.DedupTTL; v != time.Hour {
+		t.Errorf("unexpected dedup ttl: %s", v)
+	}
+	if v := dspCfg.Normalize.EventTypePrefix; v != "se2gha-" {
+		t.Errorf("unexpected prefix: %s", v)
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name:   "empty",
+			config: ``,
+			want:   []string{"cfg.yaml: config is empty"},
+		},
+		{
+			name: "unknown field",
+			config: `
+credentials:
+  default:
+    token: foo
+receivers:
+  - repo: vvakame/se2gha
+    credentials: default
+`,
+			want: []string{`cfg.yaml:7: unknown field "credentials" in receivers[0]`},
+		},
+		{
+			name: "unset variable",
+			config: `
+credentials:
+  default:
+    token: ${TEST_UNSET_VARIABLE}
+receivers:
+  - repo: vvakame/se2gha
+`,
+			want: []string{"cfg.yaml:4: ", "TEST_UNSET_VARIABLE"},
+		},
+		{
+			name: "invalid receiver",
+			config: `
+credentials:
+  default:
+    token: foo
+receivers:
+  - repo: vvakame
+  - repo: vvakame/se2gha
+    credential: other
+`,
+			want: []string{
+				`cfg.yaml:6: receivers[0]: repo must be owner/name: "vvakame"`,
+				`cfg.yaml:8: receivers[1]: unknown credential "other"`,
+			},
+		},
+		{
+			name: "invalid verify mode",
+			config: `
+credentials:
+  default:
+    token: foo
+receivers:
+  - repo: vvakame/se2gha
+dispatch:
+  verify_receivers: yes
+`,
+			want: []string{"cfg.yaml:8: dispatch.verify_receivers: verify mode must be off, warn or strict: yes"},
+		},
+		{
+			name: "short callback secret",
+			config: `
+credentials:
+  default:
+    token: foo
+receivers:
+  - repo: vvakame/se2gha
+dispatch:
+  callback:
+    secret: short
+    url: https://se2gha.example.com/callback
+`,
+			want: []string{"cfg.yaml:8: dispatch.callback: callback secret must be 32 bytes or more"},
+		},
+		{
+			name: "credential for another host",
+			config: `
+credentials:
+  default:
+    token: foo
+  ghe:
+    token: bar
+    base_url: https://github.example.com/api/v3/
+receivers:
+  - repo: vvakame/se2gha
+    credential: ghe
+    base_url: https://other.example.com/api/v3/
+`,
+			want: []string{"cfg.yaml:11: receivers[0]: credential ghe is for github.example.com, not for other.example.com"},
+		},
+		{
+			name: "unknown routing receiver",
+			config: `
+dispatch:
+  mode: dry_run
+receivers:
+  - repo: vvakame/se2gha
+routing:
+  - receivers: [vvakame/other]
+`,
+			want: []string{"cfg.yaml:7: ", "vvakame/other"},
+		},
+		{
+			name: "unknown source",
+			config: `
+sources:
+  github:
+    path: /github
+dispatch:
+  mode: dry_run
+receivers:
+  - repo: vvakame/se2gha
+`,
+			want: []string{`cfg.yaml:3: unknown source "github"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("cfg.yaml", []byte(tt.config))
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_ReceiverRepos_enterprise(t *testing.T) {
+	cfg, err := Parse("cfg.yaml", []byte(`
+credentials:
+  default:
+    token: foo
+  ghe:
+    token: bar
+    base_url: https://github.example.com/api/v3/
+receivers:
+  - repo: vvakame/se2gha
+  - repo: vvakame/se2gha
+    credential: ghe
+routing:
+  - receivers: [github.example.com/vvakame/se2gha]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := cfg.ReceiverRepos()
+	if len(repos) != 2 {
+		t.Fatalf("unexpected receivers: %v", repos)
+	}
+	if v := repos[0].String(); v != "vvakame/se2gha" {
+		t.Errorf("unexpected receiver: %s", v)
+	}
+	if v := repos[1].String(); v != "github.example.com/vvakame/se2gha" {
+		t.Errorf("unexpected receiver: %s", v)
+	}
+	if v := repos[1].BaseURL; v != "https://github.example.com/api/v3/" {
+		t.Errorf("unexpected base url: %s", v)
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolateNode expands references in scalar values.
+//   - ${NAME} is replaced with the environment variable. it is an error if NAME is not set
+//   - ${NAME:-default} falls back to default if NAME is not set or empty
+//   - ${file:/path/to/secret} is replaced with the file content without trailing newline
+//   - $$ is a literal $
+//
+// it works on the node tree, so multi-line values like PEM never break the YAML structure.
+func interpolateNode(node *yaml.Node, add func(line int, format string, a ...interface{})) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			interpolateNode(child, add)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			interpolateNode(node.Content[i+1], add)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return
+		}
+		v, err := interpolate(node.Value)
+		if err != nil {
+			add(node.Line, "%s", err.Error())
+			return
+		}
+		if v != node.Value && node.Style == 0 {
+			// let plain scalar be resolved again. e.g. app_id: ${GHA_APP_ID} becomes !!int
+			node.Tag = ""
+		}
+		node.Value = v
+	}
+}
+
+func interpolate(s string) (string, error) {
+	var buf strings.Builder
+	for {
+		idx := strings.Index(s, "$")
+		if idx == -1 {
+			buf.WriteString(s)
+			break
+		}
+		buf.WriteString(s[:idx])
+		s = s[idx:]
+
+		switch {
+		case strings.HasPrefix(s, "$$"):
+			buf.WriteString("$")
+			s = s[2:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.Index(s, "}")
+			if end == -1 {
+				return "", fmt.Errorf("unterminated reference: %s", s)
+			}
+			v, err := resolveReference(s[2:end])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(v)
+			s = s[end+1:]
+		default:
+			buf.WriteString("$")
+			s = s[1:]
+		}
+	}
+
+	return buf.String(), nil
+}
+
+func resolveReference(ref string) (string, error) {
+	if path := strings.TrimPrefix(ref, "file:"); path != ref {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("secret file reference: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty reference: ${%s}", ref)
+	}
+	v, ok := os.LookupEnv(name)
+	if hasDefault && v == "" {
+		return def, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkKnownFields reports unknown keys and structural mismatches with line numbers.
+// yaml.Decoder.KnownFields can not be used because line numbers of semantic errors need the node tree.
+func checkKnownFields(node *yaml.Node, t reflect.Type, path string, add func(line int, format string, a ...interface{})) {
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			checkKnownFields(child, t, path, add)
+		}
+		return
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			add(node.Line, "%s must be a mapping", displayPath(path))
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				add(key.Line, "unknown field %q in %s", key.Value, displayPath(path))
+				continue
+			}
+			checkKnownFields(value, field.Type, joinPath(path, key.Value), add)
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			add(node.Line, "%s must be a mapping", displayPath(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			checkKnownFields(value, t.Elem(), joinPath(path, key.Value), add)
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			add(node.Line, "%s must be a sequence", displayPath(path))
+			return
+		}
+		for idx, child := range node.Content {
+			checkKnownFields(child, t.Elem(), path+"["+strconv.Itoa(idx)+"]", add)
+		}
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			add(node.Line, "%s must be a scalar", displayPath(path))
+		}
+	}
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "root"
+	}
+
+	return path
+}
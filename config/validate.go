@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/togha"
+)
+
+func (cfg *Config) validate(add func(line int, format string, a ...interface{})) {
+	if cfg.Server != nil && cfg.Server.Port != "" {
+		if _, err := strconv.ParseUint(cfg.Server.Port, 10, 16); err != nil {
+			add(cfg.line("server", "port"), "server.port must be a port number: %s", cfg.Server.Port)
+		}
+	}
+
+	for _, name := range sortedKeys(cfg.Sources) {
+		sc := cfg.Sources[name]
+		s, ok := source.Lookup(name)
+		if !ok {
+			add(cfg.line("sources", name), "unknown source %q", name)
+			continue
+		}
+		if sc == nil {
+			continue
+		}
+		if sc.Path != "" && !strings.HasPrefix(sc.Path, "/") {
+			add(cfg.line("sources", name, "path"), "sources.%s.path must start with /", name)
+		}
+		known := make(map[string]bool)
+		if c, ok := s.(source.Configurable); ok {
+			for _, key := range c.SettingKeys() {
+				known[key] = true
+			}
+		}
+		for _, key := range sortedKeys(sc.Settings) {
+			if !known[key] {
+				add(cfg.line("sources", name, "settings", key), "unknown setting %q for source %s", key, name)
+			}
+		}
+	}
+
+	mode := cfg.mode()
+	switch mode {
+	case ModeGitHub, ModeDryRun:
+	default:
+		add(cfg.line("dispatch", "mode"), "dispatch.mode must be %s or %s: %s", ModeGitHub, ModeDryRun, mode)
+	}
+
+	for _, name := range sortedKeys(cfg.Credentials) {
+		cc := cfg.Credentials[name]
+		line := cfg.line("credentials", name)
+		switch {
+		case cc == nil:
+			add(line, "credentials.%s is empty", name)
+		case cc.Token != "" && (cc.AppID != 0 || cc.PrivateKey != "" || cc.PrivateKeyFile != ""):
+			add(line, "credentials.%s must be either token or GitHub App", name)
+		case cc.Token != "":
+		case cc.AppID == 0:
+			add(line, "credentials.%s requires token or app_id", name)
+		case cc.PrivateKey == "" && cc.PrivateKeyFile == "":
+			add(line, "credentials.%s requires private_key or private_key_file", name)
+		case cc.PrivateKey != "" && cc.PrivateKeyFile != "":
+			add(line, "credentials.%s private_key and private_key_file are exclusive", name)
+		}
+		if cc != nil {
+			if err := validateBaseURL(cc.BaseURL, cc.UploadURL); err != nil {
+				add(line, "credentials.%s: %s", name, err.Error())
+			}
+		}
+	}
+	if mode == ModeGitHub && len(cfg.Credentials) == 0 {
+		add(cfg.line(), "credentials are required when dispatch.mode is %s", ModeGitHub)
+	}
+
+	if len(cfg.Receivers) == 0 {
+		add(cfg.line("receivers"), "receivers require over 1 item")
+	}
+	seen := make(map[string]bool)
+	for idx, rc := range cfg.Receivers {
+		if rc == nil {
+			add(cfg.line("receivers", idx), "receivers[%d] is empty", idx)
+			continue
+		}
+		repo, err := cfg.receiverRepo(rc)
+		if err != nil {
+			add(cfg.line("receivers", idx), "receivers[%d]: %s", idx, err.Error())
+			continue
+		}
+		if seen[repo.String()] {
+			add(cfg.line("receivers", idx), "receivers[%d]: duplicated receiver %s", idx, repo.String())
+		}
+		seen[repo.String()] = true
+
+		if mode == ModeGitHub && len(cfg.Credentials) != 0 {
+			name, err := cfg.credentialName(rc)
+			if err != nil {
+				add(cfg.line("receivers", idx, "credential"), "receivers[%d]: %s", idx, err.Error())
+			} else if cc := cfg.Credentials[name]; cc != nil && hostOf(cc.BaseURL) != hostOf(repo.BaseURL) {
+				add(cfg.line("receivers", idx, "base_url"), "receivers[%d]: credential %s is for %s, not for %s", idx, name, hostName(cc.BaseURL), hostName(repo.BaseURL))
+			}
+		}
+	}
+
+	repos := cfg.ReceiverRepos()
+	for idx, rule := range cfg.RoutingRules() {
+		if _, err := togha.NewRouter([]*togha.RoutingRule{rule}, repos); err != nil {
+			add(cfg.line("routing", idx), "%s", err.Error())
+		}
+	}
+
+	if err := cfg.NormalizeConfig().Validate(); err != nil {
+		add(cfg.line("dispatch"), "dispatch: %s", err.Error())
+	}
+	if _, err := togha.NewLimiter(cfg.LimitConfig()); err != nil {
+		add(cfg.line("dispatch"), "dispatch: %s", err.Error())
+	}
+	if cfg.Dispatch != nil {
+		if _, err := togha.ParseVerifyMode(cfg.Dispatch.VerifyReceivers); err != nil {
+			add(cfg.line("dispatch", "verify_receivers"), "dispatch.verify_receivers: %s", err.Error())
+		}
+		if _, err := cfg.CallbackConfig(); err != nil {
+			add(cfg.line("dispatch", "callback"), "dispatch.callback: %s", err.Error())
+		}
+	}
+}
+
+// receiverRepo converts the receiver. BaseURL and UploadURL are inherited from the credential if omitted.
+func (cfg *Config) receiverRepo(rc *ReceiverConfig) (*togha.ReceiverRepo, error) {
+	repo, err := rc.receiverRepo()
+	if err != nil {
+		return nil, err
+	}
+	if repo.BaseURL != "" {
+		return repo, nil
+	}
+
+	name, err := cfg.credentialName(rc)
+	if err != nil {
+		// reported by validation. dry_run mode does not need credentials.
+		return repo, nil
+	}
+	if cc := cfg.Credentials[name]; cc != nil {
+		repo.BaseURL = cc.BaseURL
+		repo.UploadURL = cc.UploadURL
+	}
+
+	return repo, nil
+}
+
+func (rc *ReceiverConfig) receiverRepo() (*togha.ReceiverRepo, error) {
+	if err := validateBaseURL(rc.BaseURL, rc.UploadURL); err != nil {
+		return nil, err
+	}
+
+	ss := strings.SplitN(rc.Repo, "/", 2)
+	if len(ss) != 2 || ss[0] == "" || ss[1] == "" || strings.Contains(ss[1], "/") {
+		return nil, fmt.Errorf("repo must be owner/name: %q", rc.Repo)
+	}
+
+	repo := &togha.ReceiverRepo{
+		Owner:      ss[0],
+		Name:       ss[1],
+		Target:     togha.TargetKind(rc.Target),
+		Workflow:   rc.Workflow,
+		Ref:        rc.Ref,
+		Inputs:     rc.Inputs,
+		Credential: rc.Credential,
+		BaseURL:    rc.BaseURL,
+		UploadURL:  rc.UploadURL,
+	}
+	switch repo.Target {
+	case "", togha.TargetRepositoryDispatch:
+		if rc.Workflow != "" || rc.Ref != "" || len(rc.Inputs) != 0 {
+			return nil, fmt.Errorf("workflow, ref and inputs are only for %s", togha.TargetWorkflowDispatch)
+		}
+	case togha.TargetWorkflowDispatch:
+		if rc.Workflow == "" || rc.Ref == "" {
+			return nil, fmt.Errorf("%s requires workflow and ref", togha.TargetWorkflowDispatch)
+		}
+	default:
+		return nil, fmt.Errorf("unknown target %q", rc.Target)
+	}
+
+	return repo, nil
+}
+
+// credentialName resolves credential of the receiver.
+// if omitted, "default" credential or the only credential is used.
+func (cfg *Config) credentialName(rc *ReceiverConfig) (string, error) {
+	if rc.Credential != "" {
+		if _, ok := cfg.Credentials[rc.Credential]; !ok {
+			return "", fmt.Errorf("unknown credential %q", rc.Credential)
+		}
+		return rc.Credential, nil
+	}
+
+	return cfg.defaultCredentialName()
+}
+
+func (cfg *Config) defaultCredentialName() (string, error) {
+	if _, ok := cfg.Credentials["default"]; ok {
+		return "default", nil
+	}
+	if len(cfg.Credentials) == 1 {
+		return sortedKeys(cfg.Credentials)[0], nil
+	}
+
+	return "", fmt.Errorf("credential is required when there are multiple credentials without \"default\"")
+}
+
+func validateBaseURL(baseURL, uploadURL string) error {
+	if baseURL == "" {
+		if uploadURL != "" {
+			return fmt.Errorf("upload_url requires base_url")
+		}
+		return nil
+	}
+	for _, v := range []string{baseURL, uploadURL} {
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("base_url and upload_url must be absolute http(s) URL: %q", v)
+		}
+	}
+
+	return nil
+}
+
+// hostOf returns the host of baseURL. it is empty for github.com.
+func hostOf(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return baseURL
+	}
+
+	return u.Host
+}
+
+func hostName(baseURL string) string {
+	if baseURL == "" {
+		return "github.com"
+	}
+
+	return hostOf(baseURL)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vvakame/se2gha/config"
+	"github.com/vvakame/se2gha/togha"
+)
+
+const deadLetterUsage = `usage:
+  se2gha deadletter [-config file] list
+  se2gha deadletter [-config file] show <id>
+  se2gha deadletter [-config file] replay [-to owner/name,...] <id>
+  se2gha deadletter [-config file] purge (-all | <id>...)`
+
+// newDeadLetterStore opens dispatch.dead_letter_dir or DEAD_LETTER_DIR. it returns nil if not configured.
+func newDeadLetterStore(cfg *config.Config) (*togha.DeadLetterStore, error) {
+	dir := os.Getenv("DEAD_LETTER_DIR")
+	if cfg != nil {
+		dir = cfg.DeadLetterDir()
+	}
+	if dir == "" {
+		return nil, nil
+	}
+
+	return togha.NewDeadLetterStore(dir)
+}
+
+// deadLetter inspects, replays and purges dead letters.
+func deadLetter(w io.Writer, configPath string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(w, deadLetterUsage)
+		return 2
+	}
+
+	var cfg *config.Config
+	if configPath != "" {
+		var err error
+		cfg, err = config.Load(configPath)
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+	}
+	store, err := newDeadLetterStore(cfg)
+	if err != nil {
+		fmt.Fprintln(w, err.Error())
+		return 1
+	}
+	if store == nil {
+		fmt.Fprintln(w, "dispatch.dead_letter_dir or DEAD_LETTER_DIR is required")
+		return 2
+	}
+
+	ctx := context.Background()
+	verb := args[0]
+	fs := flag.NewFlagSet(verb, flag.ContinueOnError)
+	fs.SetOutput(w)
+	to := fs.String("to", "", "comma separated receivers to replay instead of failed ones")
+	all := fs.Bool("all", false, "purge all dead letters")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	ids := fs.Args()
+
+	switch {
+	case verb == "list":
+		dls, err := store.List()
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		for _, dl := range dls {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", dl.ID, dl.FailedAt.Format("2006-01-02T15:04:05Z07:00"), dl.Request.Type, strings.Join(dl.Receivers, ","), dl.Error)
+		}
+		return 0
+
+	case verb == "show" && len(ids) == 1:
+		dl, err := store.Get(ids[0])
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		b, err := json.MarshalIndent(dl, "", "  ")
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		fmt.Fprintln(w, string(b))
+		return 0
+
+	case verb == "replay" && len(ids) == 1:
+		dsp, err := newDispatcher(ctx, cfg)
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		var targets []string
+		if *to != "" {
+			targets = strings.Split(*to, ",")
+		}
+		res, err := store.Replay(ctx, ids[0], dsp, targets)
+		if res != nil {
+			for _, rr := range res.Receivers {
+				status := "ok"
+				if !rr.Succeeded() {
+					status = rr.Error
+				}
+				fmt.Fprintf(w, "%s\t%s\n", rr.Repo, status)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		return 0
+
+	case verb == "purge" && *all && len(ids) == 0:
+		n, err := store.Purge()
+		fmt.Fprintf(w, "%d dead letters purged\n", n)
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		return 0
+
+	case verb == "purge" && !*all && len(ids) != 0:
+		for _, id := range ids {
+			if err := store.Remove(id); err != nil {
+				fmt.Fprintln(w, err.Error())
+				return 1
+			}
+		}
+		fmt.Fprintf(w, "%d dead letters purged\n", len(ids))
+		return 0
+
+	default:
+		fmt.Fprintln(w, deadLetterUsage)
+		return 2
+	}
+}
+
+// newDispatcher builds EventDispatcher from cfg, or from environment variables if cfg is nil.
+// dry_run mode is an error, because it does not talk to GitHub. replaying into dry-run would remove dead letters.
+func newDispatcher(ctx context.Context, cfg *config.Config) (togha.EventDispatcher, error) {
+	if cfg == nil {
+		if dryRun, _ := strconv.ParseBool(os.Getenv("GHA_DRY_RUN")); dryRun {
+			return nil, errors.New("not available in dry-run mode")
+		}
+		return togha.NewEventDispatcher(ctx, nil)
+	}
+	if cfg.DryRun() {
+		return nil, errors.New("not available in dry-run mode")
+	}
+
+	dspCfg, err := cfg.EventDispatcherConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return togha.NewEventDispatcher(ctx, dspCfg)
+}
@@ -0,0 +1,155 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL keeps upstream API calls by readiness probes low.
+const DefaultCacheTTL = 5 * time.Minute
+
+// Check is a result of one dependency.
+type Check struct {
+	// Name identifies the dependency. e.g. "github:vvakame/se2gha"
+	Name      string    `json:"name"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Checker reports readiness of dependencies.
+type Checker interface {
+	CheckHealth(ctx context.Context) []*Check
+}
+
+// CheckerFunc adapts a function to Checker.
+type CheckerFunc func(ctx context.Context) []*Check
+
+func (f CheckerFunc) CheckHealth(ctx context.Context) []*Check {
+	return f(ctx)
+}
+
+// Report is a response body of readiness.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []*Check `json:"checks"`
+}
+
+// Run runs all checkers concurrently. checks are sorted by name.
+func Run(ctx context.Context, checkers ...Checker) *Report {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	report := &Report{Status: "ok", Checks: []*Check{}}
+	for _, checker := range checkers {
+		checker := checker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks := checker.CheckHealth(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks = append(report.Checks, checks...)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Name < report.Checks[j].Name
+	})
+	for _, check := range report.Checks {
+		if !check.OK {
+			report.Status = "fail"
+		}
+	}
+
+	return report
+}
+
+// LivenessHandler responds 200 while the process can serve requests.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, &Report{Status: "ok", Checks: []*Check{}})
+	})
+}
+
+// ReadinessHandler responds 200 if all checks are ok, 503 otherwise.
+func ReadinessHandler(checkers ...Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, Run(r.Context(), checkers...))
+	})
+}
+
+func writeReport(w http.ResponseWriter, report *Report) {
+	b, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_, _ = w.Write(b)
+}
+
+// Cache memoizes check results for TTL per name.
+// failures are kept for TTL too, so a broken token does not burn the API rate limit.
+type Cache struct {
+	// TTL is DefaultCacheTTL if zero.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*Check
+}
+
+// Do returns a cached result of name, or calls f.
+func (c *Cache) Do(ctx context.Context, name string, f func(ctx context.Context) error) *Check {
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	if check, ok := c.entries[name]; ok && now.Sub(check.CheckedAt) < ttl {
+		c.mu.Unlock()
+		copied := *check
+		return &copied
+	}
+	c.mu.Unlock()
+
+	err := f(ctx)
+	check := &Check{
+		Name:      name,
+		OK:        err == nil,
+		LatencyMS: time.Since(now).Milliseconds(),
+		CheckedAt: now,
+	}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	if ctx.Err() != nil {
+		// the probe is canceled. do not remember a failure which is not of the dependency.
+		return check
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*Check)
+	}
+	c.entries[name] = check
+	copied := *check
+
+	return &copied
+}
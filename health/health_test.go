@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_Do(t *testing.T) {
+	ctx := context.Background()
+
+	cache := &Cache{TTL: time.Hour}
+	var calls int
+	f := func(ctx context.Context) error {
+		calls++
+		return errors.New("unauthorized")
+	}
+
+	for i := 0; i < 3; i++ {
+		check := cache.Do(ctx, "github:vvakame/se2gha", f)
+		if check.OK {
+			t.Error("unexpected ok")
+		}
+		if v := check.Error; v != "unauthorized" {
+			t.Errorf("unexpected error: %s", v)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("unexpected calls: %d", calls)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	cache = &Cache{TTL: time.Hour}
+	cache.Do(canceled, "slack:auth.test", func(ctx context.Context) error { return ctx.Err() })
+	check := cache.Do(ctx, "slack:auth.test", func(ctx context.Context) error { return nil })
+	if !check.OK {
+		t.Errorf("canceled result should not be cached: %s", check.Error)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	ok := CheckerFunc(func(ctx context.Context) []*Check {
+		return []*Check{{Name: "slack:auth.test", OK: true}}
+	})
+	ng := CheckerFunc(func(ctx context.Context) []*Check {
+		return []*Check{{Name: "github:vvakame/se2gha", Error: "404 Not Found"}}
+	})
+
+	tests := []struct {
+		name     string
+		checkers []Checker
+		want     int
+		status   string
+		checks   int
+	}{
+		{"no checkers", nil, http.StatusOK, "ok", 0},
+		{"ok", []Checker{ok}, http.StatusOK, "ok", 1},
+		{"fail", []Checker{ok, ng}, http.StatusServiceUnavailable, "fail", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ReadinessHandler(tt.checkers...).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.want {
+				t.Errorf("unexpected status code: %d", w.Code)
+			}
+
+			var report Report
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.status {
+				t.Errorf("unexpected status: %s", report.Status)
+			}
+			if len(report.Checks) != tt.checks {
+				t.Errorf("unexpected checks: %d", len(report.Checks))
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package kintone_event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/togha"
+)
+
+var _ togha.CallbackTarget = (*DispatchGitHubEventRequest)(nil)
+var _ source.CallbackResponderProvider = (*kintoneSource)(nil)
+
+// CallbackRef is the app and the record. events without a record can not be reported back.
+func (req *DispatchGitHubEventRequest) CallbackRef() map[string]string {
+	if req.Event.App == nil || req.Event.App.ID == "" {
+		return nil
+	}
+	recordID := req.Event.recordID()
+	if recordID == "" {
+		return nil
+	}
+
+	return map[string]string{
+		"app":    req.Event.App.ID,
+		"record": recordID,
+	}
+}
+
+// recordID reads $id field of the record.
+func (ev *KintoneEvent) recordID() string {
+	if ev.RecordID != "" {
+		return ev.RecordID
+	}
+
+	var record struct {
+		ID *struct {
+			Value string `json:"value"`
+		} `json:"$id"`
+	}
+	if err := json.Unmarshal(ev.Record, &record); err != nil || record.ID == nil {
+		return ""
+	}
+
+	return record.ID.Value
+}
+
+// CallbackResponder comments with base_url and api_token setting, or KINTONE_BASE_URL and KINTONE_API_TOKEN.
+// it is nil if they are empty. the host of the event is never used, because kintone webhooks are not signed.
+func (s *kintoneSource) CallbackResponder(settings source.Settings) (togha.CallbackResponder, error) {
+	baseURL := settings.Get("base_url", "KINTONE_BASE_URL")
+	apiToken := settings.Get("api_token", "KINTONE_API_TOKEN")
+	if baseURL == "" || apiToken == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base_url: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("base_url must be https://${subdomain}.cybozu.com: %s", baseURL)
+	}
+
+	return &callbackResponder{
+		baseURL:  strings.TrimSuffix(u.String(), "/"),
+		apiToken: apiToken,
+		hc:       &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type callbackResponder struct {
+	baseURL string
+	// apiToken may be comma separated tokens of apps.
+	apiToken string
+	hc       *http.Client
+}
+
+// Respond comments on the record. reply is accepted as comment.
+func (r *callbackResponder) Respond(ctx context.Context, claims *togha.CallbackClaims, req *togha.CallbackRequest) (*togha.CallbackResult, error) {
+	switch req.Action {
+	case "comment", "reply":
+	default:
+		return nil, fmt.Errorf("%w: %s", togha.ErrUnsupportedCallback, req.Action)
+	}
+	if req.Text == "" {
+		return nil, fmt.Errorf("%w: text is required", togha.ErrInvalidCallbackRequest)
+	}
+	app, record := claims.Ref["app"], claims.Ref["record"]
+	if app == "" || record == "" {
+		return nil, fmt.Errorf("%w: token has no record", togha.ErrInvalidCallbackRequest)
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"app":    app,
+		"record": record,
+		"comment": map[string]string{
+			"text": req.Text,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/k/v1/record/comment.json", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("X-Cybozu-API-Token", r.apiToken)
+
+	resp, err := r.hc.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kintone returns %d: %s", resp.StatusCode, string(body))
+	}
+
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, errors.New("unexpected kintone response: " + string(body))
+	}
+
+	return &togha.CallbackResult{ID: res.ID}, nil
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 
 	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/source"
 	"github.com/vvakame/se2gha/togha"
 )
 
@@ -33,14 +35,43 @@ func (req *DispatchGitHubEventRequest) Payload() (json.RawMessage, error) {
 	return b, nil
 }
 
+func (req *DispatchGitHubEventRequest) Source() string {
+	return "kintone"
+}
+
+func (req *DispatchGitHubEventRequest) SourceBody() []byte {
+	return req.EventRaw
+}
+
+func (req *DispatchGitHubEventRequest) IdempotencyKey() string {
+	if req.Event.ID == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("kintone:%s", req.Event.ID)
+}
+
+func (req *DispatchGitHubEventRequest) SourceAttributes() map[string]string {
+	attrs := map[string]string{
+		"event": req.Event.Type,
+	}
+	if req.Event.App != nil {
+		attrs["app"] = req.Event.App.ID
+	}
+
+	return attrs
+}
+
 // https://jp.cybozu.help/k/ja/user/app_settings/set_webhook/webhook_notification.html
 type KintoneEvent struct {
-	ID          string          `json:"id"`
-	Type        string          `json:"type"`
-	App         *KintoneApp     `json:"app"`
-	Record      json.RawMessage `json:"record"`
-	RecordTitle string          `json:"recordTitle"`
-	URL         string          `json:"url"`
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	App    *KintoneApp     `json:"app"`
+	Record json.RawMessage `json:"record"`
+	// RecordID is set for DELETE_RECORD event instead of Record.
+	RecordID    string `json:"recordId,omitempty"`
+	RecordTitle string `json:"recordTitle"`
+	URL         string `json:"url"`
 }
 
 type KintoneApp struct {
@@ -48,11 +79,38 @@ type KintoneApp struct {
 	Name string `json:"name"`
 }
 
+func init() {
+	source.Register(&kintoneSource{})
+}
+
+type kintoneSource struct{}
+
+func (s *kintoneSource) Name() string {
+	return "kintone"
+}
+
+func (s *kintoneSource) DefaultPath() string {
+	return "/kintone"
+}
+
+func (s *kintoneSource) SettingKeys() []string {
+	return []string{"base_url", "api_token"}
+}
+
+func (s *kintoneSource) Mount(ctx context.Context, mux *http.ServeMux, prefix string, dsp togha.EventDispatcher, settings source.Settings) error {
+	return mount(ctx, mux, prefix, dsp)
+}
+
+// HandleEvent mounts handlers on /kintone .
 func HandleEvent(ctx context.Context, mux *http.ServeMux, dsp togha.EventDispatcher) error {
+	return mount(ctx, mux, "/kintone", dsp)
+}
+
+func mount(ctx context.Context, mux *http.ServeMux, prefix string, dsp togha.EventDispatcher) error {
 	h := &eventHandler{
 		dsp: dsp,
 	}
-	mux.HandleFunc("/kintone/events/action", h.eventHandler)
+	mux.HandleFunc(prefix+"/events/action", h.eventHandler)
 
 	return nil
 }
@@ -64,7 +122,7 @@ func (h *eventHandler) eventHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte(err.Error()))
-		log.Warnf(ctx, err.Error())
+		log.Warnf(ctx, "%s", err)
 		return
 	}
 	defer r.Body.Close()
@@ -78,17 +136,11 @@ func (h *eventHandler) eventHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf(ctx, "event type: %s", req.Type)
 	log.Debugf(ctx, "event payload: %s", string(b))
+	metrics.ObserveSourceEvent("kintone", fmt.Sprintf("kintone-event-%s", req.Type))
 
-	err = h.dsp.Dispatch(ctx, &DispatchGitHubEventRequest{
+	res, err := h.dsp.Dispatch(ctx, &DispatchGitHubEventRequest{
 		EventRaw: b,
 		Event:    req,
 	})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
-		log.Warnf(ctx, err.Error())
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
+	togha.WriteDispatchResult(ctx, w, res, err)
 }
@@ -4,57 +4,388 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/vvakame/sdlog/buildlog"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func Debugf(ctx context.Context, format string, a ...interface{}) {
-	ctx, err := buildlog.WithConfigurator(ctx, buildlog.DefaultConfigurator)
-	if err != nil {
-		panic(err)
+// Level is a minimum severity to output.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("log level must be debug, info, warn or error: %s", s)
 	}
+}
 
-	logEntry := buildlog.NewLogEntry(ctx, buildlog.WithSourceLocationSkip(4))
-	logEntry.Severity = buildlog.SeverityDebug
-	logEntry.Message = fmt.Sprintf(format, a...)
+func (level Level) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
 
-	b, err := json.Marshal(logEntry)
-	if err != nil {
-		panic(err)
+func (level Level) severity() buildlog.Severity {
+	switch level {
+	case LevelDebug:
+		return buildlog.SeverityDebug
+	case LevelInfo:
+		return buildlog.SeverityInfo
+	case LevelWarn:
+		return buildlog.SeverityWarning
+	default:
+		return buildlog.SeverityError
 	}
-	fmt.Println(string(b))
 }
 
-func Infof(ctx context.Context, format string, a ...interface{}) {
-	ctx, err := buildlog.WithConfigurator(ctx, buildlog.DefaultConfigurator)
-	if err != nil {
-		panic(err)
+// Format is an output format.
+type Format string
+
+const (
+	// FormatJSON is a structured log of Cloud Logging. it is the default.
+	FormatJSON Format = "json"
+	// FormatText is a human readable line for local runs.
+	FormatText Format = "text"
+)
+
+// ParseFormat parses json or text.
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(strings.ToLower(strings.TrimSpace(s))); format {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatText:
+		return format, nil
+	default:
+		return FormatJSON, fmt.Errorf("log format must be json or text: %s", s)
+	}
+}
+
+// callerSkip points the caller of Debugf etc. from buildlog.NewLogEntry.
+const callerSkip = 5
+
+var configurator buildlog.Configurator = &otelConfigurator{}
+
+// otelConfigurator correlates log entries with OpenTelemetry spans.
+type otelConfigurator struct {
+	buildlog.GCPDefaultConfigurator
+}
+
+func (cfg *otelConfigurator) TraceInfo(ctx context.Context) (string, string) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", ""
 	}
 
-	logEntry := buildlog.NewLogEntry(ctx, buildlog.WithSourceLocationSkip(4))
-	logEntry.Severity = buildlog.SeverityInfo
-	logEntry.Message = fmt.Sprintf(format, a...)
+	return sc.TraceID().String(), sc.SpanID().String()
+}
+
+// Logger writes log entries at or above its level.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format Format
+}
 
-	b, err := json.Marshal(logEntry)
+// New returns Logger which writes to out.
+func New(out io.Writer, level Level, format Format) *Logger {
+	return &Logger{
+		out:    out,
+		level:  level,
+		format: format,
+	}
+}
+
+// NewFromEnv returns Logger which writes to stdout with LOG_LEVEL and LOG_FORMAT.
+func NewFromEnv() (*Logger, error) {
+	level, err := ParseLevel(os.Getenv("LOG_LEVEL"))
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("LOG_LEVEL: %w", err)
 	}
-	fmt.Println(string(b))
+	format, err := ParseFormat(os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		return nil, fmt.Errorf("LOG_FORMAT: %w", err)
+	}
+
+	return New(os.Stdout, level, format), nil
+}
+
+// std is used by package level functions. main replaces it by the one from NewFromEnv.
+var std = New(os.Stdout, LevelInfo, FormatJSON)
+
+func init() {
+	// entries are correlated with spans without configurator in each context.
+	buildlog.DefaultConfigurator = configurator
+}
+
+// Default returns the Logger used by package level functions.
+func Default() *Logger {
+	return std
+}
+
+// SetDefault replaces the Logger used by package level functions. call it before logging starts.
+func SetDefault(l *Logger) {
+	std = l
+}
+
+// Enabled reports whether entries at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+type fieldsKey struct{}
+
+// With returns ctx which adds key/value pairs to every entry logged with it.
+func With(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	merged := make([]interface{}, 0, len(fields)+len(keysAndValues))
+	merged = append(merged, fields...)
+	merged = append(merged, keysAndValues...)
+
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+func Debugf(ctx context.Context, format string, a ...interface{}) {
+	std.log(ctx, LevelDebug, true, format, a, nil)
+}
+
+func Infof(ctx context.Context, format string, a ...interface{}) {
+	std.log(ctx, LevelInfo, true, format, a, nil)
 }
 
 func Warnf(ctx context.Context, format string, a ...interface{}) {
-	ctx, err := buildlog.WithConfigurator(ctx, buildlog.DefaultConfigurator)
-	if err != nil {
-		panic(err)
+	std.log(ctx, LevelWarn, true, format, a, nil)
+}
+
+func Errorf(ctx context.Context, format string, a ...interface{}) {
+	std.log(ctx, LevelError, true, format, a, nil)
+}
+
+// Debug writes msg with key/value pairs. e.g. log.Debug(ctx, "event received", "event_type", eventType)
+func Debug(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	std.log(ctx, LevelDebug, false, msg, nil, keysAndValues)
+}
+
+func Info(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	std.log(ctx, LevelInfo, false, msg, nil, keysAndValues)
+}
+
+func Warn(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	std.log(ctx, LevelWarn, false, msg, nil, keysAndValues)
+}
+
+func Error(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	std.log(ctx, LevelError, false, msg, nil, keysAndValues)
+}
+
+func (l *Logger) Debugf(ctx context.Context, format string, a ...interface{}) {
+	l.log(ctx, LevelDebug, true, format, a, nil)
+}
+
+func (l *Logger) Infof(ctx context.Context, format string, a ...interface{}) {
+	l.log(ctx, LevelInfo, true, format, a, nil)
+}
+
+func (l *Logger) Warnf(ctx context.Context, format string, a ...interface{}) {
+	l.log(ctx, LevelWarn, true, format, a, nil)
+}
+
+func (l *Logger) Errorf(ctx context.Context, format string, a ...interface{}) {
+	l.log(ctx, LevelError, true, format, a, nil)
+}
+
+func (l *Logger) Debug(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.log(ctx, LevelDebug, false, msg, nil, keysAndValues)
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.log(ctx, LevelInfo, false, msg, nil, keysAndValues)
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.log(ctx, LevelWarn, false, msg, nil, keysAndValues)
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.log(ctx, LevelError, false, msg, nil, keysAndValues)
+}
+
+// field is a key/value pair in an entry.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// fields merges pairs in ctx and keysAndValues. a key without value is reported as "!BADKEY".
+func fields(ctx context.Context, keysAndValues []interface{}) []field {
+	ctxFields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	kvs := make([]interface{}, 0, len(ctxFields)+len(keysAndValues))
+	kvs = append(kvs, ctxFields...)
+	kvs = append(kvs, keysAndValues...)
+
+	var fs []field
+	for i := 0; i < len(kvs); i += 2 {
+		key, ok := kvs[i].(string)
+		if !ok || i+1 == len(kvs) {
+			fs = append(fs, field{key: "!BADKEY", value: kvs[i]})
+			i--
+			continue
+		}
+		fs = append(fs, field{key: key, value: kvs[i+1]})
+	}
+
+	return fs
+}
+
+// log is called directly by Debugf etc. to keep callerSkip.
+// format is formatted with a if sprintf is true, it is the message as is otherwise.
+func (l *Logger) log(ctx context.Context, level Level, sprintf bool, format string, a []interface{}, keysAndValues []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	entry := buildlog.NewLogEntry(ctx, buildlog.WithSourceLocationSkip(callerSkip))
+	entry.Severity = level.severity()
+	entry.Message = format
+	if sprintf {
+		entry.Message = fmt.Sprintf(format, a...)
+	}
+	fs := fields(ctx, keysAndValues)
+
+	var b []byte
+	if l.format == FormatText {
+		traceID, _ := configurator.TraceInfo(ctx)
+		b = textEntry(level, entry, traceID, fs)
+	} else {
+		b = jsonEntry(entry, fs)
 	}
 
-	logEntry := buildlog.NewLogEntry(ctx, buildlog.WithSourceLocationSkip(4))
-	logEntry.Severity = buildlog.SeverityWarning
-	logEntry.Message = fmt.Sprintf(format, a...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(b)
+}
+
+// jsonEntry returns a line of Cloud Logging structured log. fields go to jsonPayload.
+// values which can not be marshaled are written as strings instead of failing.
+func jsonEntry(entry *buildlog.LogEntry, fs []field) []byte {
+	b, err := json.Marshal(entry)
+	if err != nil || len(fs) == 0 {
+		if err != nil {
+			b, _ = json.Marshal(map[string]string{"severity": "ERROR", "message": entry.Message, "log_error": err.Error()})
+		}
+		return append(b, '\n')
+	}
+
+	obj := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return append(b, '\n')
+	}
+	for _, f := range fs {
+		if _, ok := obj[f.key]; ok {
+			// special fields of Cloud Logging are never overwritten.
+			continue
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprintf("%+v", f.value))
+		}
+		obj[f.key] = v
+	}
 
-	b, err := json.Marshal(logEntry)
+	b, err = json.Marshal(obj)
 	if err != nil {
-		panic(err)
+		return []byte(fmt.Sprintf("{\"severity\":\"ERROR\",\"message\":%q}\n", entry.Message))
 	}
-	fmt.Println(string(b))
+
+	return append(b, '\n')
+}
+
+// textEntry returns a line such as `15:04:05.000 INFO  message key=value trace_id=... (file.go:12)`.
+func textEntry(level Level, entry *buildlog.LogEntry, traceID string, fs []field) []byte {
+	var buf strings.Builder
+	buf.WriteString(time.Time(entry.Time).Format("15:04:05.000"))
+	buf.WriteByte(' ')
+	buf.WriteString(fmt.Sprintf("%-5s", level.String()))
+	buf.WriteByte(' ')
+	buf.WriteString(entry.Message)
+
+	if traceID != "" {
+		fs = append(fs, field{key: "trace_id", value: traceID})
+	}
+	for _, f := range fs {
+		buf.WriteByte(' ')
+		buf.WriteString(f.key)
+		buf.WriteByte('=')
+		buf.WriteString(textValue(f.value))
+	}
+	if loc := entry.SourceLocation; loc != nil && loc.File != "" {
+		buf.WriteString(fmt.Sprintf(" (%s:%d)", filepath.Base(loc.File), loc.Line))
+	}
+	buf.WriteByte('\n')
+
+	return []byte(buf.String())
+}
+
+func textValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		ss := make([]string, 0, len(keys))
+		for _, key := range keys {
+			ss = append(ss, key+":"+v[key])
+		}
+		s = "{" + strings.Join(ss, ",") + "}"
+	default:
+		s = fmt.Sprintf("%+v", v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+
+	return s
 }
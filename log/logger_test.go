@@ -0,0 +1,159 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	ctx := With(context.Background(), "request_id", "r1")
+
+	tests := []struct {
+		name   string
+		level  Level
+		format Format
+		log    func(l *Logger)
+		want   []string
+	}{
+		{
+			name:   "filtered",
+			level:  LevelInfo,
+			format: FormatJSON,
+			log:    func(l *Logger) { l.Debugf(ctx, "payload: %s", "secret") },
+			want:   nil,
+		},
+		{
+			name:   "json",
+			level:  LevelInfo,
+			format: FormatJSON,
+			log:    func(l *Logger) { l.Error(ctx, "dispatch failed", "receiver", "vvakame/se2gha", "attempts", 3) },
+			want: []string{
+				`"severity":"ERROR"`,
+				`"message":"dispatch failed"`,
+				`"request_id":"r1"`,
+				`"receiver":"vvakame/se2gha"`,
+				`"attempts":3`,
+				`logger_test.go`,
+			},
+		},
+		{
+			name:   "json special field is kept",
+			level:  LevelDebug,
+			format: FormatJSON,
+			log:    func(l *Logger) { l.Info(ctx, "hello", "message", "overwritten", "odd") },
+			want:   []string{`"message":"hello"`, `"!BADKEY":"odd"`},
+		},
+		{
+			name:   "text",
+			level:  LevelWarn,
+			format: FormatText,
+			log:    func(l *Logger) { l.Warnf(ctx, "retry %d", 2) },
+			want:   []string{"WARN  retry 2 request_id=r1 (logger_test.go:"},
+		},
+		{
+			name:   "text escaped percent",
+			level:  LevelWarn,
+			format: FormatText,
+			log:    func(l *Logger) { l.Warnf(ctx, "100%%") },
+			want:   []string{"WARN  100% request_id=r1"},
+		},
+		{
+			name:   "text percent as is",
+			level:  LevelWarn,
+			format: FormatText,
+			log:    func(l *Logger) { l.Warn(ctx, "100%%") },
+			want:   []string{"WARN  100%% request_id=r1"},
+		},
+		{
+			name:   "text quote",
+			level:  LevelWarn,
+			format: FormatText,
+			log:    func(l *Logger) { l.Error(ctx, "failed", "error", "not found") },
+			want:   []string{`error="not found"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(New(&buf, tt.level, tt.format))
+
+			got := buf.String()
+			if tt.want == nil {
+				if got != "" {
+					t.Errorf("unexpected output: %s", got)
+				}
+				return
+			}
+			if tt.format == FormatJSON && !json.Valid([]byte(got)) {
+				t.Errorf("invalid JSON: %s", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("%s is not found in %s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Level
+		wantErr bool
+	}{
+		{"", LevelInfo, false},
+		{"debug", LevelDebug, false},
+		{"WARNING", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", LevelInfo, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseLevel(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   string
+		format  string
+		wantErr string
+	}{
+		{"default", "", "", ""},
+		{"text", "debug", "text", ""},
+		{"invalid level", "verbose", "", "LOG_LEVEL"},
+		{"invalid format", "", "xml", "LOG_FORMAT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LOG_LEVEL", tt.level)
+			t.Setenv("LOG_FORMAT", tt.format)
+
+			l, err := NewFromEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if l != nil {
+					t.Error("invalid environment must not return Logger")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
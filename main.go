@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -10,50 +12,130 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/vvakame/se2gha/kintone_event"
-	"github.com/vvakame/se2gha/slack_event"
+	"github.com/vvakame/se2gha/config"
+	se2ghalog "github.com/vvakame/se2gha/log"
 	"github.com/vvakame/se2gha/togha"
-	"go.opencensus.io/exporter/stackdriver/propagation"
-	"go.opencensus.io/plugin/ochttp"
+	"github.com/vvakame/se2gha/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
 	_ "github.com/slack-go/slack"
+	_ "github.com/vvakame/se2gha/kintone_event"
+	_ "github.com/vvakame/se2gha/slack_event"
 )
 
 func main() {
-	ctx := context.Background()
+	cmd := "serve"
+	args := os.Args[1:]
+	if len(args) != 0 && args[0] != "" && args[0][0] != '-' {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("SE2GHA_CONFIG"), "path to config file. environment variables are used if omitted")
+	watch := fs.Duration("watch", 0, "reload the config file when it is modified. checked at the interval. e.g. 10s")
+	_ = fs.Parse(args)
+	if v := os.Getenv("SE2GHA_CONFIG_WATCH"); v != "" && *watch == 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid SE2GHA_CONFIG_WATCH: %s", err)
+		}
+		*watch = d
+	}
 
-	dsp, err := togha.NewEventDispatcher(ctx, nil)
+	logger, err := se2ghalog.NewFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
+	se2ghalog.SetDefault(logger)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte(`<a href="https://github.com/vvakame/se2gha">se2gha</a>`))
-	})
+	switch cmd {
+	case "serve":
+		serve(*configPath, *watch)
+	case "validate":
+		os.Exit(validate(os.Stdout, *configPath))
+	case "verify":
+		os.Exit(verify(os.Stdout, *configPath))
+	case "deadletter":
+		os.Exit(deadLetter(os.Stdout, *configPath, fs.Args()))
+	default:
+		log.Fatalf("unknown command: %s", cmd)
+	}
+}
+
+// validate checks the config file without starting the server.
+func validate(w io.Writer, configPath string) int {
+	if configPath == "" {
+		fmt.Fprintln(w, "-config or SE2GHA_CONFIG is required")
+		return 2
+	}
 
-	err = slack_event.HandleEvent(ctx, mux, dsp)
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(w, err.Error())
+		return 1
+	}
+
+	ctx := context.Background()
+	if cfg.DryRun() {
+		var dryRunCfg *togha.DryRunConfig
+		dryRunCfg, err = cfg.DryRunConfig()
+		if err == nil {
+			_, err = togha.NewDryRunEventDispatcher(ctx, dryRunCfg)
+		}
+	} else {
+		var dspCfg *togha.EventDispatcherConfig
+		dspCfg, err = cfg.EventDispatcherConfig(ctx)
+		if err == nil {
+			_, err = togha.NewEventDispatcher(ctx, dspCfg)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(w, "%s: %s\n", configPath, err.Error())
+		return 1
+	}
+
+	fmt.Fprintf(w, "%s: ok\n", configPath)
+	return 0
+}
+
+func serve(configPath string, watchInterval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdownTracing, err := tracing.Setup(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer func() {
+		// flush spans after the server and the outbox are closed.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush spans: %s", err)
+		}
+	}()
 
-	err = kintone_event.HandleEvent(ctx, mux, dsp)
+	s, cfg, err := newServer(ctx, configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer s.Close()
 
 	port := os.Getenv("PORT")
+	if cfg != nil && cfg.Port() != "" {
+		port = cfg.Port()
+	}
 	if port == "" {
 		port = "8080"
 	}
 
-	och := &ochttp.Handler{
-		Propagation: &propagation.HTTPFormat{},
-		Handler:     mux,
-	}
+	h := otelhttp.NewHandler(s, "se2gha", otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+		return r.Method + " " + r.URL.Path
+	}))
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
-		Handler: och,
+		Handler: h,
 	}
 
 	go func() {
@@ -62,13 +144,33 @@ func main() {
 		}
 	}()
 
+	if configPath != "" && watchInterval > 0 {
+		go s.watch(ctx, watchInterval)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, os.Interrupt)
-	log.Printf("SIGNAL %d received", <-quit)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
+	for waiting := true; waiting; {
+		select {
+		case sig := <-hup:
+			log.Printf("SIGNAL %d received, reloading", sig)
+			if err := s.reload(ctx); err != nil {
+				log.Printf("Failed to reload, keep current configuration: %s", err)
+				continue
+			}
+			log.Println("Configuration reloaded")
+		case sig := <-quit:
+			log.Printf("SIGNAL %d received", sig)
+			waiting = false
+		}
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("Failed to gracefully shutdown: %s", err)
 	}
 	log.Println("Server shutdown")
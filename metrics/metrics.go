@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MaxLabelValues bounds distinct values per label. values over it are reported as OtherLabel.
+const MaxLabelValues = 100
+
+// OtherLabel is a label value for values out of bound.
+const OtherLabel = "other"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	sourceEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "se2gha_source_events_total",
+		Help: "Inbound events by source and event type.",
+	}, []string{"source", "event_type"})
+
+	signatureFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "se2gha_signature_failures_total",
+		Help: "Inbound requests rejected by signature verification.",
+	}, []string{"source"})
+
+	slackAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "se2gha_slack_api_duration_seconds",
+		Help:    "Latency of Slack API calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "outcome"})
+
+	dispatchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "se2gha_github_dispatch_duration_seconds",
+		Help:    "Latency of dispatches to GitHub per receiver including retries.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"receiver", "outcome"})
+
+	dispatchRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "se2gha_github_dispatch_retries_total",
+		Help: "Retried GitHub API calls per receiver.",
+	}, []string{"receiver"})
+
+	outboxDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "se2gha_outbox_deliveries_total",
+		Help: "Deliveries from the outbox by outcome. delivered, retry, gave_up or dropped.",
+	}, []string{"outcome"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		sourceEvents,
+		signatureFailures,
+		slackAPIDuration,
+		dispatchDuration,
+		dispatchRetries,
+		outboxDeliveries,
+	)
+}
+
+// Handler serves metrics in Prometheus text format, or OpenMetrics if requested.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+}
+
+// RegisterGaugeFunc exposes a value which is read on each scrape. e.g. queue depth.
+// it replaces the gauge registered with the same name.
+func RegisterGaugeFunc(name, help string, f func() float64) error {
+	opts := prometheus.GaugeOpts{
+		Name: name,
+		Help: help,
+	}
+	registry.Unregister(prometheus.NewGaugeFunc(opts, f))
+
+	return registry.Register(prometheus.NewGaugeFunc(opts, f))
+}
+
+// ObserveSourceEvent counts an inbound event. eventType must not contain free-form values.
+func ObserveSourceEvent(source, eventType string) {
+	sourceEvents.WithLabelValues(source, eventTypes.bound(eventType)).Inc()
+}
+
+// ObserveSignatureFailure counts a request with invalid signature.
+func ObserveSignatureFailure(source string) {
+	signatureFailures.WithLabelValues(source).Inc()
+}
+
+// ObserveSlackAPI records a Slack API call started at start. method is a Web API name. e.g. conversations.replies
+func ObserveSlackAPI(method string, start time.Time, err error) {
+	slackAPIDuration.WithLabelValues(method, outcomeOf(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDispatch records a dispatch to the receiver.
+// outcome is success, failure or duplicate. attempts over 1 are counted as retries.
+func ObserveDispatch(receiver, outcome string, latency time.Duration, attempts int) {
+	receiver = receivers.bound(receiver)
+	dispatchDuration.WithLabelValues(receiver, outcome).Observe(latency.Seconds())
+	if attempts > 1 {
+		dispatchRetries.WithLabelValues(receiver).Add(float64(attempts - 1))
+	}
+}
+
+// ObserveOutboxDelivery counts a delivery from the outbox.
+func ObserveOutboxDelivery(outcome string) {
+	outboxDeliveries.WithLabelValues(outcome).Inc()
+}
+
+func outcomeOf(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
+}
+
+var (
+	eventTypes = &boundedLabel{}
+	receivers  = &boundedLabel{}
+)
+
+// boundedLabel keeps first MaxLabelValues distinct values. it keeps series count constant
+// against values such as custom emoji or replays to unknown repositories.
+type boundedLabel struct {
+	mu     sync.Mutex
+	values map[string]bool
+}
+
+func (l *boundedLabel) bound(value string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.values[value] {
+		return value
+	}
+	if len(l.values) >= MaxLabelValues {
+		return OtherLabel
+	}
+	if l.values == nil {
+		l.values = make(map[string]bool)
+	}
+	l.values[value] = true
+
+	return value
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoundedLabel(t *testing.T) {
+	l := &boundedLabel{}
+	for i := 0; i < MaxLabelValues; i++ {
+		v := fmt.Sprintf("reaction-%d", i)
+		if got := l.bound(v); got != v {
+			t.Fatalf("unexpected label: %s", got)
+		}
+	}
+	if got := l.bound("reaction-0"); got != "reaction-0" {
+		t.Errorf("known value should be kept: %s", got)
+	}
+	if got := l.bound("custom-emoji"); got != OtherLabel {
+		t.Errorf("unexpected label: %s", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ObserveSourceEvent("slack", "slack-event-reaction_added-create-issue")
+	ObserveSignatureFailure("slack")
+	ObserveSlackAPI("conversations.replies", time.Now(), nil)
+	ObserveDispatch("vvakame/se2gha", "failure", time.Second, 3)
+	if err := RegisterGaugeFunc("se2gha_test_queued", "test", func() float64 { return 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterGaugeFunc("se2gha_test_queued", "test", func() float64 { return 2 }); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(b)
+
+	for _, want := range []string{
+		`se2gha_source_events_total{event_type="slack-event-reaction_added-create-issue",source="slack"} 1`,
+		`se2gha_signature_failures_total{source="slack"} 1`,
+		`se2gha_slack_api_duration_seconds_count{method="conversations.replies",outcome="ok"} 1`,
+		`se2gha_github_dispatch_duration_seconds_count{outcome="failure",receiver="vvakame/se2gha"} 1`,
+		`se2gha_github_dispatch_retries_total{receiver="vvakame/se2gha"} 2`,
+		`se2gha_test_queued 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%s is not found", want)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vvakame/se2gha/admin"
+	"github.com/vvakame/se2gha/config"
+	"github.com/vvakame/se2gha/health"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/togha"
+)
+
+// generation is a set of handler and dispatcher built from one configuration.
+// it is replaced as a whole on reload, requests started on it finish on it.
+type generation struct {
+	cfg     *config.Config
+	handler http.Handler
+	dsp     togha.EventDispatcher
+	limiter *togha.Limiter
+	// resolvers build events deferred to the outbox by source name.
+	resolvers map[string]togha.Resolver
+	close     func()
+
+	mu       sync.Mutex
+	inflight int
+	retired  bool
+	drained  chan struct{}
+}
+
+// acquire marks a request in-flight. it returns false if the generation is already retired.
+func (gen *generation) acquire() bool {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+
+	if gen.retired {
+		return false
+	}
+	gen.inflight++
+
+	return true
+}
+
+// Track marks background work of sources in-flight. see source.Tracker.
+// unlike acquire, it succeeds on the retired generation until it is drained, because requests on it start the work.
+func (gen *generation) Track() (func(), bool) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+
+	if gen.retired && gen.inflight == 0 {
+		return nil, false
+	}
+	gen.inflight++
+
+	return gen.release, true
+}
+
+func (gen *generation) release() {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+
+	gen.inflight--
+	if gen.retired && gen.inflight == 0 {
+		close(gen.drained)
+	}
+}
+
+// retire waits in-flight requests and closes the dispatcher.
+func (gen *generation) retire() {
+	gen.mu.Lock()
+	gen.retired = true
+	if gen.inflight == 0 {
+		close(gen.drained)
+	}
+	gen.mu.Unlock()
+
+	<-gen.drained
+	gen.close()
+}
+
+// server keeps the current generation and things which live across reloads.
+type server struct {
+	configPath string
+	// outbox is fixed at startup. it delivers events with the current generation.
+	outbox *togha.OutboxEventDispatcher
+	// deadLetters is fixed at startup. optional.
+	deadLetters *togha.DeadLetterStore
+	// dedup is shared by generations, so redeliveries are detected across reloads.
+	dedup togha.DedupStore
+	// limiter is shared by generations while its config is unchanged.
+	limiter  *togha.Limiter
+	limitCfg togha.LimitConfig
+
+	reloadMu sync.Mutex
+	current  atomic.Pointer[generation]
+	// ready is closed when the first generation is stored. the outbox worker waits for it.
+	ready chan struct{}
+}
+
+func newServer(ctx context.Context, configPath string) (*server, *config.Config, error) {
+	s := &server{
+		configPath: configPath,
+		dedup:      togha.NewMemoryDedupStore(),
+		ready:      make(chan struct{}),
+	}
+
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.deadLetters, err = newDeadLetterStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outboxDir := os.Getenv("OUTBOX_DIR")
+	if cfg != nil {
+		outboxDir = cfg.OutboxDir()
+	}
+	if outboxDir != "" {
+		s.outbox, err = togha.NewOutboxEventDispatcher(ctx, &togha.OutboxConfig{
+			Dir:         outboxDir,
+			Dispatcher:  dispatcherFunc(s.dispatch),
+			DeadLetters: s.deadLetters,
+			Resolver:    resolverFunc(s.resolve),
+			Observer:    observerFunc(s.observe),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	gen, err := s.newGeneration(ctx, cfg)
+	if err != nil {
+		if s.outbox != nil {
+			_ = s.outbox.Close()
+		}
+		return nil, nil, err
+	}
+	s.current.Store(gen)
+	close(s.ready)
+
+	if err := s.registerMetrics(); err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+
+	return s, cfg, nil
+}
+
+// registerMetrics exposes queue state. values are read from the current generation on each scrape.
+func (s *server) registerMetrics() error {
+	type gauge struct {
+		name string
+		help string
+		f    func() float64
+	}
+	gauges := []gauge{
+		{"se2gha_github_limiter_queue_depth", "GitHub API calls waiting for the limiter.", func() float64 {
+			return float64(s.current.Load().limiter.QueueDepth())
+		}},
+		{"se2gha_github_limiter_in_flight", "GitHub API calls in progress.", func() float64 {
+			return float64(s.current.Load().limiter.InFlight())
+		}},
+	}
+	if s.outbox != nil {
+		gauges = append(gauges, gauge{"se2gha_outbox_queued", "Events waiting for delivery in the outbox.", func() float64 {
+			n, _ := s.outbox.Len()
+			return float64(n)
+		}})
+	}
+	if s.deadLetters != nil {
+		gauges = append(gauges, gauge{"se2gha_dead_letters", "Events kept as dead letters.", func() float64 {
+			n, _ := s.deadLetters.Len()
+			return float64(n)
+		}})
+	}
+
+	for _, g := range gauges {
+		if err := metrics.RegisterGaugeFunc(g.name, g.help, g.f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadConfig returns nil if configPath is empty. environment variables are used then.
+func (s *server) loadConfig() (*config.Config, error) {
+	if s.configPath == "" {
+		return nil, nil
+	}
+
+	return config.Load(s.configPath)
+}
+
+// newGeneration builds EventDispatcher and sources from cfg, or from environment variables if cfg is nil.
+func (s *server) newGeneration(ctx context.Context, cfg *config.Config) (*generation, error) {
+	mux := http.NewServeMux()
+
+	dryRun, _ := strconv.ParseBool(os.Getenv("GHA_DRY_RUN"))
+	debugEndpoint, _ := strconv.ParseBool(os.Getenv("GHA_DRY_RUN_DEBUG_ENDPOINT"))
+	if cfg != nil {
+		dryRun = cfg.DryRun()
+		debugEndpoint = cfg.DryRunDebugEndpoint()
+	}
+
+	gen := &generation{
+		cfg:     cfg,
+		handler: mux,
+		close:   func() {},
+		drained: make(chan struct{}),
+	}
+	var limiter *togha.Limiter
+	var callback *togha.CallbackConfig
+	if dryRun {
+		var dryRunCfg *togha.DryRunConfig
+		if cfg != nil {
+			var err error
+			dryRunCfg, err = cfg.DryRunConfig()
+			if err != nil {
+				return nil, err
+			}
+		}
+		dryRunDsp, err := togha.NewDryRunEventDispatcher(ctx, dryRunCfg)
+		if err != nil {
+			return nil, err
+		}
+		gen.close = func() { _ = dryRunDsp.Close() }
+
+		if debugEndpoint {
+			mux.Handle("/debug/dispatches", dryRunDsp)
+		}
+		gen.dsp = dryRunDsp
+	} else {
+		dspCfg := &togha.EventDispatcherConfig{}
+		if cfg != nil {
+			var err error
+			dspCfg, err = cfg.EventDispatcherConfig(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !dspCfg.DisableDedup {
+			dspCfg.Dedup = s.dedup
+		}
+		if cfg == nil {
+			var err error
+			dspCfg.Callback, err = togha.CallbackConfigFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			if dspCfg.Callback == nil {
+				dspCfg.DisableCallback = true
+			}
+		}
+		if s.limiter != nil && (cfg == nil || *cfg.LimitConfig() == s.limitCfg) {
+			dspCfg.Limiter = s.limiter
+		}
+		dsp, err := togha.NewEventDispatcher(ctx, dspCfg)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := verifyModeOf(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyReceivers(ctx, dsp, mode); err != nil {
+			return nil, err
+		}
+		gen.dsp = dsp
+		gen.limiter = dspCfg.Limiter
+		limiter = dspCfg.Limiter
+		callback = dspCfg.Callback
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<a href="https://github.com/vvakame/se2gha">se2gha</a>`))
+	})
+
+	var srcCfg *source.Config
+	if cfg != nil {
+		srcCfg = cfg.SourceConfig()
+	}
+	var front togha.EventDispatcher = gen.dsp
+	if s.outbox != nil {
+		front = s.outbox
+	} else if s.deadLetters != nil {
+		front = togha.NewDeadLetterEventDispatcher(gen.dsp, s.deadLetters)
+	}
+	// sources stop their background connections when the generation is retired.
+	mountCtx, cancelMount := context.WithCancel(source.WithTracker(ctx, gen))
+	closeDispatcher := gen.close
+	gen.close = func() {
+		cancelMount()
+		closeDispatcher()
+	}
+	mounted, err := source.MountAll(mountCtx, mux, front, srcCfg)
+	if err != nil {
+		gen.close()
+		return nil, err
+	}
+
+	if s.outbox != nil {
+		gen.resolvers, err = source.Resolvers(mounted, srcCfg)
+		if err != nil {
+			gen.close()
+			return nil, err
+		}
+	}
+
+	if callback != nil {
+		responders, err := source.CallbackResponders(mounted, srcCfg)
+		if err != nil {
+			gen.close()
+			return nil, err
+		}
+		mux.Handle("/callback", togha.CallbackHandler(callback.Signer, responders))
+	}
+
+	checkers := []health.Checker{source.Checker(mounted, srcCfg)}
+	if hc, ok := gen.dsp.(health.Checker); ok {
+		checkers = append(checkers, hc)
+	}
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler(checkers...))
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if cfg != nil {
+		adminToken = cfg.AdminToken()
+	}
+	if adminToken != "" {
+		adminCfg := &admin.Config{
+			Token:       adminToken,
+			DeadLetters: s.deadLetters,
+		}
+		if !dryRun {
+			// requests hold this generation, so replay uses this dispatcher.
+			adminCfg.Dispatcher = gen.dsp
+			adminCfg.Verifier, _ = gen.dsp.(togha.ReceiverVerifier)
+		}
+		err = admin.Mount(ctx, mux, "/admin", adminCfg)
+		if err != nil {
+			gen.close()
+			return nil, err
+		}
+	}
+
+	if limiter != nil && limiter != s.limiter {
+		// environment variables never change while running, so it is reused in env mode.
+		s.limiter = limiter
+		if cfg != nil {
+			s.limitCfg = *cfg.LimitConfig()
+		}
+	}
+
+	return gen, nil
+}
+
+// reload builds a new generation and swaps it. the current generation stays active on error.
+func (s *server) reload(ctx context.Context) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	old := s.current.Load()
+	if cfg != nil && old.cfg != nil {
+		if cfg.Port() != old.cfg.Port() {
+			return errors.New("server.port can not be changed by reload")
+		}
+		if cfg.OutboxDir() != old.cfg.OutboxDir() {
+			return errors.New("dispatch.outbox_dir can not be changed by reload")
+		}
+		if cfg.DeadLetterDir() != old.cfg.DeadLetterDir() {
+			return errors.New("dispatch.dead_letter_dir can not be changed by reload")
+		}
+	}
+
+	gen, err := s.newGeneration(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	s.current.Store(gen)
+
+	go old.retire()
+
+	return nil
+}
+
+// acquire returns the current generation marked in-flight. the caller must release it.
+func (s *server) acquire() *generation {
+	for {
+		gen := s.current.Load()
+		if gen.acquire() {
+			return gen
+		}
+		// swapped just now. the next Load returns the new one.
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gen := s.acquire()
+	defer gen.release()
+
+	gen.handler.ServeHTTP(w, r)
+}
+
+// dispatch is used by outbox worker, so queued events are delivered with the latest configuration.
+// the worker starts before the first generation to accept events, queued events wait for it.
+func (s *server) dispatch(ctx context.Context, req togha.DispatchRequest) (*togha.DispatchResult, error) {
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	gen := s.acquire()
+	defer gen.release()
+
+	return gen.dsp.Dispatch(ctx, req)
+}
+
+// resolve is used by outbox worker to build events deferred by sources, with the latest configuration.
+func (s *server) resolve(ctx context.Context, req *togha.StoredRequest) (togha.DispatchRequest, error) {
+	select {
+	case <-s.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	gen := s.acquire()
+	defer gen.release()
+
+	resolver, ok := gen.resolvers[req.SourceName]
+	if !ok {
+		// the source may come back by reload. the outbox retries it.
+		return nil, fmt.Errorf("source %s is not available to resolve the event", req.SourceName)
+	}
+
+	return resolver.Resolve(ctx, req)
+}
+
+// observe tells final results of queued events to their sources.
+func (s *server) observe(ctx context.Context, req *togha.StoredRequest, res *togha.DispatchResult, err error) {
+	gen := s.acquire()
+	defer gen.release()
+
+	if observer, ok := gen.resolvers[req.SourceName].(togha.DeliveryObserver); ok {
+		observer.Delivered(ctx, req, res, err)
+	}
+}
+
+// Close stops the outbox and the current generation.
+func (s *server) Close() {
+	if s.outbox != nil {
+		_ = s.outbox.Close()
+	}
+	s.current.Load().retire()
+}
+
+// watch reloads when the config file is modified. it checks the file every interval.
+func (s *server) watch(ctx context.Context, interval time.Duration) {
+	stat := func() string {
+		fi, err := os.Stat(s.configPath)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%d:%d", fi.ModTime().UnixNano(), fi.Size())
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := stat()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := stat()
+		if current == "" || current == last {
+			continue
+		}
+		last = current
+
+		log.Printf("config file changed: %s", s.configPath)
+		if err := s.reload(ctx); err != nil {
+			log.Printf("Failed to reload, keep current configuration: %s", err)
+			continue
+		}
+		log.Println("Configuration reloaded")
+	}
+}
+
+type dispatcherFunc func(ctx context.Context, req togha.DispatchRequest) (*togha.DispatchResult, error)
+
+func (f dispatcherFunc) Dispatch(ctx context.Context, req togha.DispatchRequest) (*togha.DispatchResult, error) {
+	return f(ctx, req)
+}
+
+type resolverFunc func(ctx context.Context, req *togha.StoredRequest) (togha.DispatchRequest, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, req *togha.StoredRequest) (togha.DispatchRequest, error) {
+	return f(ctx, req)
+}
+
+type observerFunc func(ctx context.Context, req *togha.StoredRequest, res *togha.DispatchResult, err error)
+
+func (f observerFunc) Delivered(ctx context.Context, req *togha.StoredRequest, res *togha.DispatchResult, err error) {
+	f(ctx, req, res, err)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vvakame/se2gha/togha"
+)
+
+const testReloadConfig = `
+sources:
+  kintone: {}
+dispatch:
+  mode: dry_run
+receivers:
+  - repo: %s
+`
+
+func TestServer_reload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "se2gha.yaml")
+	writeConfig := func(content string) {
+		t.Helper()
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	post := func(s *server) []*togha.DryRunRecord {
+		t.Helper()
+		gen := s.current.Load()
+		r := httptest.NewRequest(http.MethodPost, "/kintone/events/action", strings.NewReader(`{"type":"ADD_RECORD"}`))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+		}
+		return gen.dsp.(*togha.DryRunEventDispatcher).Records()
+	}
+
+	writeConfig(strings.Replace(testReloadConfig, "%s", "vvakame/old", 1))
+	s, _, err := newServer(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	records := post(s)
+	if len(records) != 1 || records[0].Receiver != "vvakame/old" {
+		t.Fatalf("unexpected records: %v", records)
+	}
+
+	// an in-flight request keeps the old generation.
+	old := s.acquire()
+
+	writeConfig(strings.Replace(testReloadConfig, "%s", "vvakame/new", 1))
+	err = s.reload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records = post(s)
+	if len(records) != 1 || records[0].Receiver != "vvakame/new" {
+		t.Fatalf("unexpected records: %v", records)
+	}
+
+	select {
+	case <-old.drained:
+		t.Fatal("old generation is drained before release")
+	default:
+	}
+	old.release()
+	<-old.drained
+
+	// invalid config is rejected and the current one stays active.
+	writeConfig("receivers: []\n")
+	err = s.reload(ctx)
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	records = post(s)
+	if len(records) != 2 || records[1].Receiver != "vvakame/new" {
+		t.Fatalf("unexpected records: %v", records)
+	}
+}
+
+func TestServer_outboxOnStartup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	outboxDir := filepath.Join(dir, "outbox")
+
+	// leave an event in the outbox as a previous process did.
+	blocked := dispatcherFunc(func(ctx context.Context, req togha.DispatchRequest) (*togha.DispatchResult, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	outbox, err := togha.NewOutboxEventDispatcher(ctx, &togha.OutboxConfig{Dir: outboxDir, Dispatcher: blocked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = outbox.Dispatch(ctx, &togha.StoredRequest{ID: "0000000001-queued", Type: "kintone-event-ADD_RECORD", ClientPayload: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "se2gha.yaml")
+	config := strings.Replace(testReloadConfig, "%s", "vvakame/se2gha", 1)
+	config = strings.Replace(config, "  mode: dry_run\n", "  mode: dry_run\n  outbox_dir: "+outboxDir+"\n", 1)
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _, err := newServer(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		records := s.current.Load().dsp.(*togha.DryRunEventDispatcher).Records()
+		if len(records) == 1 && records[0].EventType == "kintone-event-ADD_RECORD" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queued event is not delivered: %v", records)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_dispatchBeforeGeneration(t *testing.T) {
+	s := &server{ready: make(chan struct{})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.dispatch(ctx, &togha.StoredRequest{Type: "kintone-event-ADD_RECORD"})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGeneration_Track(t *testing.T) {
+	gen := &generation{close: func() {}, drained: make(chan struct{})}
+	if !gen.acquire() {
+		t.Fatal("acquire failed")
+	}
+
+	retired := make(chan struct{})
+	go func() {
+		gen.retire()
+		close(retired)
+	}()
+	for {
+		gen.mu.Lock()
+		v := gen.retired
+		gen.mu.Unlock()
+		if v {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the request still running starts background work on the retired generation.
+	release, ok := gen.Track()
+	if !ok {
+		t.Fatal("work of the in-flight request is refused")
+	}
+	gen.release()
+	select {
+	case <-retired:
+		t.Fatal("retired before the background work is done")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	<-retired
+
+	if _, ok := gen.Track(); ok {
+		t.Error("drained generation must refuse work")
+	}
+}
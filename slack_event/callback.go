@@ -0,0 +1,178 @@
+package slack_event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/togha"
+)
+
+var _ togha.CallbackTarget = (*DispatchGitHubEventRequest)(nil)
+var _ togha.CallbackTarget = (*DispatchSlashCommandRequest)(nil)
+var _ source.CallbackResponderProvider = (*slackSource)(nil)
+
+// CallbackRef is the source message. events without a message can not be reported back.
+func (req *DispatchGitHubEventRequest) CallbackRef() map[string]string {
+	if req.message == nil {
+		return nil
+	}
+
+	return map[string]string{
+		"channel":   req.message.ChannelID,
+		"ts":        req.message.Timestamp,
+		"thread_ts": req.message.threadTS(),
+	}
+}
+
+// CallbackRef is the channel. slash commands have no message, so replies are posted to the channel.
+func (req *DispatchSlashCommandRequest) CallbackRef() map[string]string {
+	if req.SlashCommand.ChannelID == "" {
+		return nil
+	}
+
+	return map[string]string{
+		"channel": req.SlashCommand.ChannelID,
+	}
+}
+
+// CallbackResponder posts with the access token. it is nil without the token.
+func (s *slackSource) CallbackResponder(settings source.Settings) (togha.CallbackResponder, error) {
+	token := settings.Get("access_token", "SLACK_ACCESS_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+
+	return &callbackResponder{slCli: slack.New(token)}, nil
+}
+
+// callbackMetadataEventType is the metadata of replies posted by callbacks.
+const callbackMetadataEventType = "se2gha_callback"
+
+type callbackResponder struct {
+	slCli *slack.Client
+}
+
+// Respond replies in the thread, updates a message or adds a reaction to the source message.
+// messages are limited to the channel in the token.
+func (r *callbackResponder) Respond(ctx context.Context, claims *togha.CallbackClaims, req *togha.CallbackRequest) (*togha.CallbackResult, error) {
+	channel := claims.Ref["channel"]
+	if channel == "" {
+		return nil, fmt.Errorf("%w: token has no channel", togha.ErrInvalidCallbackRequest)
+	}
+
+	switch req.Action {
+	case "reply":
+		if req.Text == "" {
+			return nil, fmt.Errorf("%w: text is required", togha.ErrInvalidCallbackRequest)
+		}
+		opts := []slack.MsgOption{
+			slack.MsgOptionText(req.Text, false),
+			// update finds replies of the delivery by it.
+			slack.MsgOptionMetadata(slack.SlackMetadata{
+				EventType:    callbackMetadataEventType,
+				EventPayload: map[string]interface{}{"delivery_id": claims.DeliveryID},
+			}),
+		}
+		if ts := claims.Ref["thread_ts"]; ts != "" {
+			opts = append(opts, slack.MsgOptionTS(ts))
+		}
+		var ts string
+		err := callAPI(ctx, "chat.postMessage", func(ctx context.Context) (err error) {
+			_, ts, err = r.slCli.PostMessageContext(ctx, channel, opts...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &togha.CallbackResult{ID: ts}, nil
+
+	case "update":
+		if req.Text == "" {
+			return nil, fmt.Errorf("%w: text is required", togha.ErrInvalidCallbackRequest)
+		}
+		// the token names the source message. other messages must be replies this delivery posted.
+		ts := claims.Ref["ts"]
+		if req.TS != "" && req.TS != ts {
+			ok, err := r.postedByDelivery(ctx, channel, req.TS, claims.DeliveryID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("%w: message %s is not posted by this delivery", togha.ErrInvalidCallbackRequest, req.TS)
+			}
+			ts = req.TS
+		}
+		if ts == "" {
+			return nil, fmt.Errorf("%w: ts is required", togha.ErrInvalidCallbackRequest)
+		}
+		err := callAPI(ctx, "chat.update", func(ctx context.Context) error {
+			_, _, _, err := r.slCli.UpdateMessageContext(ctx, channel, ts, slack.MsgOptionText(req.Text, false))
+			return err
+		})
+		var slackErr slack.SlackErrorResponse
+		if errors.As(err, &slackErr) && (slackErr.Err == "cant_update_message" || slackErr.Err == "message_not_found") {
+			// e.g. the source message is posted by the user.
+			return nil, fmt.Errorf("%w: %s", togha.ErrInvalidCallbackRequest, slackErr.Err)
+		} else if err != nil {
+			return nil, err
+		}
+		return &togha.CallbackResult{ID: ts}, nil
+
+	case "react":
+		reaction := strings.Trim(strings.TrimSpace(req.Reaction), ":")
+		ts := claims.Ref["ts"]
+		if reaction == "" || ts == "" {
+			return nil, fmt.Errorf("%w: reaction and the source message are required", togha.ErrInvalidCallbackRequest)
+		}
+		err := callAPI(ctx, "reactions.add", func(ctx context.Context) error {
+			return r.slCli.AddReactionContext(ctx, reaction, slack.NewRefToMessage(channel, ts))
+		})
+		var slackErr slack.SlackErrorResponse
+		if err != nil && !(errors.As(err, &slackErr) && slackErr.Err == "already_reacted") {
+			return nil, err
+		}
+		return &togha.CallbackResult{ID: ts}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", togha.ErrUnsupportedCallback, req.Action)
+	}
+}
+
+// postedByDelivery reports whether the message ts in channel is a reply posted by the delivery.
+func (r *callbackResponder) postedByDelivery(ctx context.Context, channel, ts, deliveryID string) (bool, error) {
+	if deliveryID == "" {
+		return false, nil
+	}
+
+	var msgs []slack.Message
+	err := callAPI(ctx, "conversations.replies", func(ctx context.Context) (err error) {
+		msgs, _, _, err = r.slCli.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
+			ChannelID:          channel,
+			Timestamp:          ts,
+			Latest:             ts,
+			Oldest:             ts,
+			Inclusive:          true,
+			IncludeAllMetadata: true,
+		})
+		return err
+	})
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) && (slackErr.Err == "thread_not_found" || slackErr.Err == "message_not_found") {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	for _, msg := range msgs {
+		if msg.Timestamp != ts {
+			continue
+		}
+		return msg.Metadata.EventType == callbackMetadataEventType && msg.Metadata.EventPayload["delivery_id"] == deliveryID, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,86 @@
+package slack_event
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vvakame/se2gha/togha"
+)
+
+func Test_callbackResponder_Respond(t *testing.T) {
+	message := map[string]string{"channel": "C1", "ts": "1600000000.000200", "thread_ts": "1600000000.000100"}
+
+	tests := []struct {
+		name    string
+		ref     map[string]string
+		req     *togha.CallbackRequest
+		method  string
+		params  map[string]string
+		wantErr error
+	}{
+		{"reply", message, &togha.CallbackRequest{Action: "reply", Text: "done"}, "chat.postMessage", map[string]string{"channel": "C1", "thread_ts": "1600000000.000100", "text": "done"}, nil},
+		{"reply to channel", map[string]string{"channel": "C1"}, &togha.CallbackRequest{Action: "reply", Text: "done"}, "chat.postMessage", map[string]string{"channel": "C1", "thread_ts": "", "text": "done"}, nil},
+		{"update source", message, &togha.CallbackRequest{Action: "update", Text: "done"}, "chat.update", map[string]string{"channel": "C1", "ts": "1600000000.000200", "text": "done"}, nil},
+		{"update reply", message, &togha.CallbackRequest{Action: "update", Text: "done", TS: "1600000000.000300"}, "chat.update", map[string]string{"channel": "C1", "ts": "1600000000.000300"}, nil},
+		{"update signed ts", message, &togha.CallbackRequest{Action: "update", Text: "done", TS: "1600000000.000200"}, "chat.update", map[string]string{"channel": "C1", "ts": "1600000000.000200"}, nil},
+		{"update other message", message, &togha.CallbackRequest{Action: "update", Text: "done", TS: "1600000000.000900"}, "conversations.replies", nil, togha.ErrInvalidCallbackRequest},
+		{"update without ts", map[string]string{"channel": "C1"}, &togha.CallbackRequest{Action: "update", Text: "done"}, "", nil, togha.ErrInvalidCallbackRequest},
+		{"update user message", map[string]string{"channel": "C1", "ts": "1600000000.000100"}, &togha.CallbackRequest{Action: "update", Text: "done"}, "chat.update", nil, togha.ErrInvalidCallbackRequest},
+		{"react", message, &togha.CallbackRequest{Action: "react", Reaction: ":white_check_mark:"}, "reactions.add", map[string]string{"channel": "C1", "timestamp": "1600000000.000200", "name": "white_check_mark"}, nil},
+		{"already reacted", message, &togha.CallbackRequest{Action: "react", Reaction: "already"}, "reactions.add", map[string]string{"name": "already"}, nil},
+		{"react without message", map[string]string{"channel": "C1"}, &togha.CallbackRequest{Action: "react", Reaction: "eyes"}, "", nil, togha.ErrInvalidCallbackRequest},
+		{"no text", message, &togha.CallbackRequest{Action: "reply"}, "", nil, togha.ErrInvalidCallbackRequest},
+		{"no channel", map[string]string{}, &togha.CallbackRequest{Action: "reply", Text: "done"}, "", nil, togha.ErrInvalidCallbackRequest},
+		{"unsupported", message, &togha.CallbackRequest{Action: "comment", Text: "done"}, "", nil, togha.ErrUnsupportedCallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, calls := newFakeSlackAPI(t)
+			r := &callbackResponder{slCli: api}
+
+			_, err := r.Respond(context.Background(), &togha.CallbackClaims{DeliveryID: "D1", Source: "slack", Ref: tt.ref}, tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				// tt.method is the call which rejected the request, if any.
+				if tt.method == "" && len(calls()) != 0 {
+					t.Errorf("unexpected calls: %d", len(calls()))
+				} else if tt.method != "" && (len(calls()) != 1 || calls()[0].method != tt.method) {
+					t.Errorf("unexpected calls: %d", len(calls()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(calls()) == 0 {
+				t.Fatal("no calls")
+			}
+			// a lookup of the message may come first.
+			call := calls()[len(calls())-1]
+			if call.method != tt.method {
+				t.Fatalf("unexpected method: %s", call.method)
+			}
+			for key, want := range tt.params {
+				if v := call.params[key]; v != want {
+					t.Errorf("unexpected %s: %q", key, v)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchGitHubEventRequest_CallbackRef(t *testing.T) {
+	req := &DispatchGitHubEventRequest{}
+	if v := req.CallbackRef(); v != nil {
+		t.Errorf("event without message must have no ref: %v", v)
+	}
+
+	req.message = &messageRef{ChannelID: "C1", Timestamp: "1600000000.000200"}
+	ref := req.CallbackRef()
+	if ref["channel"] != "C1" || ref["ts"] != "1600000000.000200" || ref["thread_ts"] != "1600000000.000200" {
+		t.Errorf("unexpected ref: %v", ref)
+	}
+}
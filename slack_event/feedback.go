@@ -0,0 +1,178 @@
+package slack_event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/togha"
+)
+
+// FeedbackMode is how se2gha tells the dispatch result in the source message.
+type FeedbackMode string
+
+const (
+	FeedbackOff FeedbackMode = "off"
+	// FeedbackReaction adds a reaction to the source message.
+	FeedbackReaction FeedbackMode = "reaction"
+	// FeedbackReply posts a threaded reply naming the repositories and the event type.
+	FeedbackReply FeedbackMode = "reply"
+)
+
+const defaultFeedbackReaction = "eyes"
+
+// ParseFeedbackMode parses off, reaction or reply. empty means off.
+func ParseFeedbackMode(s string) (FeedbackMode, error) {
+	switch mode := FeedbackMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return FeedbackOff, nil
+	case FeedbackOff, FeedbackReaction, FeedbackReply:
+		return mode, nil
+	default:
+		return FeedbackOff, fmt.Errorf("feedback must be off, reaction or reply: %s", s)
+	}
+}
+
+// messageRef points the source message of the event.
+type messageRef struct {
+	ChannelID string
+	Timestamp string
+	// ThreadTS is the parent of the thread. replies go to it.
+	ThreadTS string
+}
+
+func (ref *messageRef) threadTS() string {
+	if ref.ThreadTS != "" {
+		return ref.ThreadTS
+	}
+
+	return ref.Timestamp
+}
+
+// feedbackFromSettings reads feedback and feedback_reaction setting, or SLACK_FEEDBACK and SLACK_FEEDBACK_REACTION.
+func feedbackFromSettings(settings source.Settings) (FeedbackMode, string, error) {
+	mode, err := ParseFeedbackMode(settings.Get("feedback", "SLACK_FEEDBACK"))
+	if err != nil {
+		return FeedbackOff, "", err
+	}
+	reaction := strings.Trim(strings.TrimSpace(settings.Get("feedback_reaction", "SLACK_FEEDBACK_REACTION")), ":")
+	if reaction == "" {
+		reaction = defaultFeedbackReaction
+	}
+
+	return mode, reaction, nil
+}
+
+// dispatchFailed reports whether the dispatch or any receiver failed.
+func dispatchFailed(res *togha.DispatchResult, err error) bool {
+	return err != nil || len(res.Failed()) != 0
+}
+
+// onlyDuplicates reports whether every receiver already had the event. redeliveries get no feedback twice.
+func onlyDuplicates(res *togha.DispatchResult) bool {
+	if res == nil || len(res.Receivers) == 0 {
+		return false
+	}
+	for _, rr := range res.Receivers {
+		if !rr.Duplicate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sendFeedback tells the dispatch result in the source message of req.
+// failures are always posted as a threaded warning. successes follow feedbackMode.
+// queued events get the final result from the outbox. see outboxHandler.Delivered.
+func (h *slackEventHandler) sendFeedback(ctx context.Context, req togha.DispatchRequest, eventType string, res *togha.DispatchResult, dispatchErr error) {
+	ghe, ok := req.(*DispatchGitHubEventRequest)
+	if !ok || ghe.message == nil {
+		// slash commands and global shortcuts have no source message.
+		return
+	}
+
+	h.feedback(ctx, ghe.message, eventType, res, dispatchErr)
+}
+
+func (h *slackEventHandler) feedback(ctx context.Context, ref *messageRef, eventType string, res *togha.DispatchResult, dispatchErr error) {
+	if h.feedbackMode == "" || h.feedbackMode == FeedbackOff {
+		return
+	}
+
+	failed := dispatchFailed(res, dispatchErr)
+	switch {
+	case failed:
+		// fall through to the warning reply.
+	case res != nil && res.Queued:
+		// not delivered yet.
+		return
+	case res != nil && len(res.Receivers) == 0:
+		return
+	case onlyDuplicates(res):
+		return
+	case h.feedbackMode == FeedbackReaction:
+		err := callAPI(ctx, "reactions.add", func(ctx context.Context) error {
+			return h.slCli.AddReactionContext(ctx, h.feedbackReaction, slack.NewRefToMessage(ref.ChannelID, ref.Timestamp))
+		})
+		var slackErr slack.SlackErrorResponse
+		if err != nil && !(errors.As(err, &slackErr) && slackErr.Err == "already_reacted") {
+			log.Warnf(ctx, "feedback reaction failed: %s", err.Error())
+		}
+		return
+	}
+
+	text := dispatchResultText(eventType, res, dispatchErr)
+	if failed {
+		text = ":warning: " + text
+	}
+	err := callAPI(ctx, "chat.postMessage", func(ctx context.Context) error {
+		_, _, err := h.slCli.PostMessageContext(ctx, ref.ChannelID, slack.MsgOptionText(text, false), slack.MsgOptionTS(ref.threadTS()))
+		return err
+	})
+	if err != nil {
+		log.Warnf(ctx, "feedback reply failed: %s", err.Error())
+	}
+}
+
+// isFeedbackReaction reports whether rae is the reaction se2gha added as feedback. it must not be dispatched again.
+func (h *slackEventHandler) isFeedbackReaction(ctx context.Context, rae *slackevents.ReactionAddedEvent) bool {
+	if h.feedbackMode != FeedbackReaction || normalizeReaction(rae.Reaction) != h.feedbackReaction {
+		return false
+	}
+
+	userID, err := h.botUserID(ctx)
+	if err != nil {
+		log.Warnf(ctx, "auth.test failed: %s", err.Error())
+		return false
+	}
+
+	return rae.User == userID
+}
+
+// botUserID returns the user ID of the access token. it is cached after the first success.
+func (h *slackEventHandler) botUserID(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.userID != "" {
+		return h.userID, nil
+	}
+
+	var res *slack.AuthTestResponse
+	err := callAPI(ctx, "auth.test", func(ctx context.Context) (err error) {
+		res, err = h.slCli.AuthTestContext(ctx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	h.userID = res.UserID
+
+	return h.userID, nil
+}
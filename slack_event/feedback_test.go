@@ -0,0 +1,232 @@
+package slack_event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/vvakame/se2gha/togha"
+)
+
+type apiCall struct {
+	method string
+	params map[string]string
+}
+
+func newFakeSlackAPI(t *testing.T) (*slack.Client, func() []*apiCall) {
+	t.Helper()
+
+	var calls []*apiCall
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		call := &apiCall{method: strings.TrimPrefix(r.URL.Path, "/"), params: make(map[string]string)}
+		for key := range r.PostForm {
+			call.params[key] = r.PostForm.Get(key)
+		}
+		calls = append(calls, call)
+
+		switch call.method {
+		case "auth.test":
+			_, _ = w.Write([]byte(`{"ok":true,"user_id":"UBOT"}`))
+		case "conversations.replies":
+			if call.params["include_all_metadata"] != "1" {
+				// events are not resolved. see Test_slackEventHandler_serveEvent_deferred for it.
+				_, _ = w.Write([]byte(`{"ok":true,"messages":[]}`))
+				return
+			}
+			// a reply posted by the delivery D1 for callbacks.
+			_, _ = w.Write([]byte(`{"ok":true,"messages":[{"type":"message","user":"UBOT","text":"posted","ts":"1600000000.000300","metadata":{"event_type":"se2gha_callback","event_payload":{"delivery_id":"D1"}}}]}`))
+		case "chat.update":
+			if call.params["ts"] == "1600000000.000100" {
+				_, _ = w.Write([]byte(`{"ok":false,"error":"cant_update_message"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"ok":true,"channel":"C1","ts":"` + call.params["ts"] + `"}`))
+		case "reactions.add":
+			if call.params["name"] == "already" {
+				_, _ = w.Write([]byte(`{"ok":false,"error":"already_reacted"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		default:
+			_, _ = w.Write([]byte(`{"ok":true,"channel":"C1","ts":"1600000000.000300"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return slack.New("token", slack.OptionAPIURL(srv.URL+"/")), func() []*apiCall {
+		return calls
+	}
+}
+
+func Test_slackEventHandler_sendFeedback(t *testing.T) {
+	succeeded := &togha.DispatchResult{
+		EventType: "slack-event-reaction_added-create-issue",
+		Receivers: []*togha.ReceiverResult{{Repo: "vvakame/se2gha"}},
+	}
+	failed := &togha.DispatchResult{
+		EventType: "slack-event-reaction_added-create-issue",
+		Receivers: []*togha.ReceiverResult{{Repo: "vvakame/se2gha", Err: errors.New("404 Not Found"), Error: "404 Not Found"}},
+	}
+	duplicated := &togha.DispatchResult{
+		EventType: "slack-event-reaction_added-create-issue",
+		Receivers: []*togha.ReceiverResult{{Repo: "vvakame/se2gha", Duplicate: true}},
+	}
+	message := &messageRef{ChannelID: "C1", Timestamp: "1600000000.000200", ThreadTS: "1600000000.000100"}
+
+	tests := []struct {
+		name     string
+		mode     FeedbackMode
+		reaction string
+		message  *messageRef
+		res      *togha.DispatchResult
+		err      error
+		method   string
+		want     string
+	}{
+		{"off", FeedbackOff, "eyes", message, succeeded, nil, "", ""},
+		{"no message", FeedbackReply, "eyes", nil, succeeded, nil, "", ""},
+		{"reaction", FeedbackReaction, "eyes", message, succeeded, nil, "reactions.add", "eyes"},
+		{"already reacted", FeedbackReaction, "already", message, succeeded, nil, "reactions.add", "already"},
+		{"reply", FeedbackReply, "eyes", message, succeeded, nil, "chat.postMessage", "• vvakame/se2gha: ok"},
+		{"receiver failure", FeedbackReaction, "eyes", message, failed, nil, "chat.postMessage", ":warning: `slack-event-reaction_added-create-issue` dispatched\n• vvakame/se2gha: failed: 404 Not Found"},
+		{"dispatch failure", FeedbackReply, "eyes", message, nil, errors.New("no route"), "chat.postMessage", ":warning: `slack-event-reaction_added-create-issue` failed: no route"},
+		{"redelivery", FeedbackReply, "eyes", message, duplicated, nil, "", ""},
+		{"no receivers", FeedbackReply, "eyes", message, &togha.DispatchResult{}, nil, "", ""},
+		{"queued", FeedbackReaction, "eyes", message, &togha.DispatchResult{Queued: true}, nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, calls := newFakeSlackAPI(t)
+			h := &slackEventHandler{slCli: api, feedbackMode: tt.mode, feedbackReaction: tt.reaction}
+			req := &DispatchGitHubEventRequest{SlackEventType: "reaction_added-create-issue", message: tt.message}
+
+			h.sendFeedback(context.Background(), req, "slack-event-reaction_added-create-issue", tt.res, tt.err)
+
+			if tt.method == "" {
+				if len(calls()) != 0 {
+					t.Errorf("unexpected calls: %d", len(calls()))
+				}
+				return
+			}
+			if len(calls()) != 1 {
+				t.Fatalf("unexpected calls: %d", len(calls()))
+			}
+			call := calls()[0]
+			if call.method != tt.method {
+				t.Fatalf("unexpected method: %s", call.method)
+			}
+			switch tt.method {
+			case "reactions.add":
+				if call.params["name"] != tt.want || call.params["channel"] != "C1" || call.params["timestamp"] != "1600000000.000200" {
+					t.Errorf("unexpected params: %v", call.params)
+				}
+			case "chat.postMessage":
+				if !strings.Contains(call.params["text"], tt.want) {
+					t.Errorf("unexpected text: %s", call.params["text"])
+				}
+				if call.params["thread_ts"] != "1600000000.000100" {
+					t.Errorf("unexpected thread_ts: %s", call.params["thread_ts"])
+				}
+			}
+		})
+	}
+}
+
+func Test_outboxHandler_Delivered(t *testing.T) {
+	succeeded := &togha.DispatchResult{
+		EventType: "slack-event-reaction_added-create-issue",
+		Receivers: []*togha.ReceiverResult{{Repo: "vvakame/se2gha"}},
+	}
+
+	var posted []*slack.WebhookMessage
+	responseSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := &slack.WebhookMessage{}
+		if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+			t.Error(err)
+		}
+		posted = append(posted, msg)
+	}))
+	t.Cleanup(responseSrv.Close)
+
+	tests := []struct {
+		name    string
+		ref     map[string]string
+		reply   bool
+		method  string
+		respond bool
+	}{
+		{"source message", map[string]string{"channel": "C1", "ts": "1600000000.000200", "thread_ts": "1600000000.000100"}, false, "reactions.add", false},
+		{"slash command", map[string]string{"channel": "C1"}, true, "", true},
+		{"view submission", map[string]string{"channel": "C1", "ts": "1600000000.000200", "thread_ts": "1600000000.000100"}, true, "reactions.add", true},
+		{"no ref", nil, false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, calls := newFakeSlackAPI(t)
+			r := &outboxHandler{h: &slackEventHandler{slCli: api, feedbackMode: FeedbackReaction, feedbackReaction: "eyes"}}
+
+			posted = nil
+			req := &togha.StoredRequest{Type: "slack-event-reaction_added-create-issue", Ref: tt.ref}
+			if tt.reply {
+				req.Reply = map[string]string{"response_url": responseSrv.URL}
+			}
+			r.Delivered(context.Background(), req, succeeded, nil)
+
+			if !tt.respond && len(posted) != 0 {
+				t.Errorf("unexpected response: %d", len(posted))
+			}
+			if tt.respond && (len(posted) != 1 || !strings.Contains(posted[0].Text, "vvakame/se2gha: ok")) {
+				t.Errorf("final result is not posted: %+v", posted)
+			}
+
+			if tt.method == "" {
+				if len(calls()) != 0 {
+					t.Errorf("unexpected calls: %d", len(calls()))
+				}
+				return
+			}
+			if len(calls()) != 1 || calls()[0].method != tt.method {
+				t.Fatalf("unexpected calls: %d", len(calls()))
+			}
+			if v := calls()[0].params["timestamp"]; v != "1600000000.000200" {
+				t.Errorf("unexpected timestamp: %s", v)
+			}
+		})
+	}
+}
+
+func Test_slackEventHandler_isFeedbackReaction(t *testing.T) {
+	api, calls := newFakeSlackAPI(t)
+	h := &slackEventHandler{slCli: api, feedbackMode: FeedbackReaction, feedbackReaction: "eyes"}
+
+	tests := []struct {
+		name     string
+		user     string
+		reaction string
+		want     bool
+	}{
+		{"by se2gha", "UBOT", "eyes", true},
+		{"by user", "U1", "eyes", false},
+		{"other reaction", "UBOT", "create-issue", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rae := &slackevents.ReactionAddedEvent{User: tt.user, Reaction: tt.reaction}
+			if v := h.isFeedbackReaction(context.Background(), rae); v != tt.want {
+				t.Errorf("unexpected result: %v", v)
+			}
+		})
+	}
+	if v := len(calls()); v != 1 {
+		t.Errorf("auth.test must be cached: %d calls", v)
+	}
+}
@@ -0,0 +1,243 @@
+package slack_event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/slack-go/slack"
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/tracing"
+)
+
+// InteractionDispatch is dispatched as "slack-event-<type>-<action_id>" for Block Kit buttons and shortcuts.
+// ActionID is callback_id for message_action and shortcut.
+type InteractionDispatch struct {
+	Type      string `json:"type"`
+	ActionID  string `json:"action_id"`
+	BlockID   string `json:"block_id,omitempty"`
+	Value     string `json:"value,omitempty"`
+	TeamID    string `json:"team_id"`
+	ChannelID string `json:"channel_id,omitempty"`
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Text      string `json:"text,omitempty"`
+	Link      string `json:"link,omitempty"`
+	MessageTS string `json:"message_ts,omitempty"`
+	ThreadTS  string `json:"thread_ts,omitempty"`
+}
+
+// parseInteraction decodes payload form field of the interactivity request.
+func parseInteraction(body []byte) (json.RawMessage, *slack.InteractionCallback, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	payload := form.Get("payload")
+	if payload == "" {
+		return nil, nil, fmt.Errorf("payload form field is required")
+	}
+
+	callback := &slack.InteractionCallback{}
+	err = json.Unmarshal([]byte(payload), callback)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return json.RawMessage(payload), callback, nil
+}
+
+// interactionHandler converts block_actions, message_action and shortcut.
+func (h *slackEventHandler) interactionHandler(ctx context.Context, original json.RawMessage, callback *slack.InteractionCallback) (*DispatchGitHubEventRequest, error) {
+	interaction := &InteractionDispatch{
+		Type:      string(callback.Type),
+		TeamID:    callback.Team.ID,
+		ChannelID: callback.Channel.ID,
+		UserID:    callback.User.ID,
+		UserName:  callback.User.Name,
+		Text:      callback.Message.Text,
+	}
+
+	switch callback.Type {
+	case slack.InteractionTypeBlockActions:
+		if len(callback.ActionCallback.BlockActions) == 0 {
+			return nil, fmt.Errorf("block_actions has no actions")
+		}
+		// Slack sends one action per request.
+		action := callback.ActionCallback.BlockActions[0]
+		interaction.ActionID = action.ActionID
+		interaction.BlockID = action.BlockID
+		interaction.Value = blockActionValue(action)
+		if interaction.ChannelID == "" {
+			interaction.ChannelID = callback.Container.ChannelID
+		}
+
+	case slack.InteractionTypeMessageAction, slack.InteractionTypeShortcut:
+		interaction.ActionID = callback.CallbackID
+
+	default:
+		return nil, fmt.Errorf("unsupported interaction type: %s", callback.Type)
+	}
+	if interaction.ActionID == "" {
+		return nil, fmt.Errorf("%s has no action_id or callback_id", callback.Type)
+	}
+
+	// actions in modals or App Home and global shortcuts have no source message.
+	if ts := callback.Message.Timestamp; interaction.ChannelID != "" && ts != "" {
+		// the message tells whether it is a thread reply. conversations.replies is not needed.
+		threadTS := callback.Message.ThreadTimestamp
+		if threadTS == ts {
+			threadTS = ""
+		}
+		isThreadReply := threadTS != ""
+		link, err := h.buildSlackURL(ctx, &slackURLFragment{
+			TeamName:      callback.Team.Domain,
+			ChannelID:     interaction.ChannelID,
+			Timestamp:     ts,
+			ThreadTS:      threadTS,
+			IsThreadReply: &isThreadReply,
+		})
+		if err != nil {
+			return nil, err
+		}
+		interaction.Link = link
+		interaction.MessageTS = ts
+		interaction.ThreadTS = threadTS
+	}
+
+	req := &DispatchGitHubEventRequest{
+		SlackEvent:     original,
+		SlackEventType: fmt.Sprintf("%s-%s", interaction.Type, interaction.ActionID),
+		Interaction:    interaction,
+		attributes: map[string]string{
+			"team":    interaction.TeamID,
+			"event":   interaction.Type,
+			"channel": interaction.ChannelID,
+			"action":  interaction.ActionID,
+		},
+	}
+	if callback.TriggerID != "" {
+		// Slack issues a new trigger_id for each interaction.
+		req.eventID = "interaction:" + callback.TriggerID
+	}
+	req.message = interaction.messageRef()
+
+	return req, nil
+}
+
+// messageRef returns the source message. nil if the interaction has no message.
+func (interaction *InteractionDispatch) messageRef() *messageRef {
+	if interaction == nil || interaction.ChannelID == "" || interaction.MessageTS == "" {
+		return nil
+	}
+
+	return &messageRef{
+		ChannelID: interaction.ChannelID,
+		Timestamp: interaction.MessageTS,
+		ThreadTS:  interaction.ThreadTS,
+	}
+}
+
+// blockActionValue returns the value of buttons, or the selection of menus and pickers.
+func blockActionValue(action *slack.BlockAction) string {
+	switch {
+	case action.Value != "":
+		return action.Value
+	case action.SelectedOption.Value != "":
+		return action.SelectedOption.Value
+	case action.SelectedUser != "":
+		return action.SelectedUser
+	case action.SelectedChannel != "":
+		return action.SelectedChannel
+	case action.SelectedConversation != "":
+		return action.SelectedConversation
+	case action.SelectedDate != "":
+		return action.SelectedDate
+	default:
+		return action.SelectedTime
+	}
+}
+
+// interactivityHandler acknowledges the interaction immediately, and posts the dispatch result to response_url later.
+// the interaction which has a modal opens it instead of dispatching. the modal is dispatched on view_submission.
+// Slack shows an error to the user if the acknowledgement takes over 3 seconds.
+func (h *slackEventHandler) interactivityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+	defer r.Body.Close()
+
+	_, span := tracing.Start(ctx, "slack.verify_signature")
+	s, err := h.checkSignature(ctx, r.Header, b)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.ObserveSignatureFailure("slack")
+		w.WriteHeader(s)
+		_, _ = w.Write([]byte(err.Error()))
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+
+	h.serveInteraction(ctx, w, b)
+}
+
+// serveInteraction handles the verified body of an interaction. it is shared by HTTP and Socket Mode.
+func (h *slackEventHandler) serveInteraction(ctx context.Context, w http.ResponseWriter, b []byte) {
+	original, callback, err := parseInteraction(b)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+	log.Debugf(ctx, "interaction type: %s", callback.Type)
+
+	if callback.Type == slack.InteractionTypeViewSubmission {
+		h.viewSubmissionHandler(ctx, w, original, callback)
+		return
+	}
+
+	ghe, err := h.interactionHandler(ctx, original, callback)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+
+	if modal, ok := h.modals[ghe.Interaction.ActionID]; ok {
+		err = h.openModal(ctx, modal, callback, ghe.Interaction)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(err.Error()))
+			log.Warnf(ctx, "views.open failed: %s", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	eventType, _ := ghe.EventType()
+	metrics.ObserveSourceEvent("slack", ghe.metricsEventType())
+	log.Info(ctx, "interaction received", "type", ghe.Interaction.Type, "action_id", ghe.Interaction.ActionID, "user", ghe.Interaction.UserID, "channel", ghe.Interaction.ChannelID)
+
+	ghe.responseURL = callback.ResponseURL
+	if !source.Go(h.tracker, func() { h.dispatchAndRespond(detach(ctx), eventType, ghe) }) {
+		// shutting down. Slack tells the user that the interaction failed.
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
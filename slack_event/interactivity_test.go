@@ -0,0 +1,167 @@
+package slack_event
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vvakame/se2gha/togha"
+)
+
+func Test_slackEventHandler_interactivityHandler(t *testing.T) {
+	dsp := &recordingDispatcher{reqs: make(chan togha.DispatchRequest, 1)}
+	h := &slackEventHandler{dsp: dsp, signingSecret: "secret"}
+
+	tests := []struct {
+		name      string
+		payload   string
+		secret    string
+		status    int
+		eventType string
+		want      *InteractionDispatch
+	}{
+		{
+			name:      "block_actions button",
+			payload:   `{"type":"block_actions","trigger_id":"1.2.a","team":{"id":"T1","domain":"vvakame"},"user":{"id":"U1","name":"vvakame"},"container":{"type":"message","channel_id":"C1","message_ts":"1600000000.000100"},"channel":{"id":"C1"},"message":{"ts":"1600000000.000100","text":"deploy?"},"actions":[{"action_id":"deploy","block_id":"b1","type":"button","value":"production"}]}`,
+			secret:    "secret",
+			status:    http.StatusOK,
+			eventType: "slack-event-block_actions-deploy",
+			want: &InteractionDispatch{
+				Type:      "block_actions",
+				ActionID:  "deploy",
+				BlockID:   "b1",
+				Value:     "production",
+				TeamID:    "T1",
+				ChannelID: "C1",
+				UserID:    "U1",
+				UserName:  "vvakame",
+				Text:      "deploy?",
+				Link:      "https://vvakame.slack.com/archives/C1/p1600000000000100",
+				MessageTS: "1600000000.000100",
+			},
+		},
+		{
+			name:      "message_action in thread",
+			payload:   `{"type":"message_action","callback_id":"send_to_github","trigger_id":"1.2.b","team":{"id":"T1","domain":"vvakame"},"user":{"id":"U1","name":"vvakame"},"channel":{"id":"C1"},"message":{"ts":"1600000000.000200","thread_ts":"1600000000.000100","text":"bug"}}`,
+			secret:    "secret",
+			status:    http.StatusOK,
+			eventType: "slack-event-message_action-send_to_github",
+			want: &InteractionDispatch{
+				Type:      "message_action",
+				ActionID:  "send_to_github",
+				TeamID:    "T1",
+				ChannelID: "C1",
+				UserID:    "U1",
+				UserName:  "vvakame",
+				Text:      "bug",
+				Link:      "https://vvakame.slack.com/archives/C1/p1600000000000100?thread_ts=1600000000.000200",
+				MessageTS: "1600000000.000200",
+				ThreadTS:  "1600000000.000100",
+			},
+		},
+		{
+			name:      "global shortcut",
+			payload:   `{"type":"shortcut","callback_id":"new_issue","trigger_id":"1.2.c","team":{"id":"T1","domain":"vvakame"},"user":{"id":"U1","name":"vvakame"}}`,
+			secret:    "secret",
+			status:    http.StatusOK,
+			eventType: "slack-event-shortcut-new_issue",
+			want: &InteractionDispatch{
+				Type:     "shortcut",
+				ActionID: "new_issue",
+				TeamID:   "T1",
+				UserID:   "U1",
+				UserName: "vvakame",
+			},
+		},
+		{
+			name:    "unsupported type",
+			payload: `{"type":"view_closed","team":{"id":"T1"},"user":{"id":"U1"}}`,
+			secret:  "secret",
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "invalid signature",
+			payload: `{"type":"shortcut","callback_id":"new_issue"}`,
+			secret:  "wrong",
+			status:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"payload": {tt.payload}}
+			w := httptest.NewRecorder()
+			h.interactivityHandler(w, newSignedRequest(t, "/slack/interactivity", tt.secret, form.Encode()))
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+			}
+			if tt.want == nil {
+				return
+			}
+
+			var req *DispatchGitHubEventRequest
+			select {
+			case r := <-dsp.reqs:
+				req = r.(*DispatchGitHubEventRequest)
+			case <-time.After(5 * time.Second):
+				t.Fatal("not dispatched")
+			}
+			if v, _ := req.EventType(); v != tt.eventType {
+				t.Errorf("unexpected event type: %s", v)
+			}
+			if *req.Interaction != *tt.want {
+				t.Errorf("unexpected interaction: %+v", req.Interaction)
+			}
+			if v := req.SourceAttributes()["action"]; v != tt.want.ActionID {
+				t.Errorf("unexpected action attribute: %s", v)
+			}
+			if v := string(req.SourceBody()); v != tt.payload {
+				t.Errorf("unexpected source body: %s", v)
+			}
+			if req.IdempotencyKey() == "" {
+				t.Error("idempotency key is empty")
+			}
+		})
+	}
+}
+
+func Test_slackEventHandler_interactivityHandler_tracked(t *testing.T) {
+	payload := `{"type":"shortcut","callback_id":"new_issue","trigger_id":"1.2.c","team":{"id":"T1","domain":"vvakame"},"user":{"id":"U1","name":"vvakame"}}`
+	form := url.Values{"payload": {payload}}
+
+	tests := []struct {
+		name   string
+		refuse bool
+		status int
+	}{
+		{"tracked", false, http.StatusOK},
+		{"shutting down", true, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsp := &recordingDispatcher{reqs: make(chan togha.DispatchRequest, 1)}
+			tracker := newTestTracker(tt.refuse)
+			h := &slackEventHandler{dsp: dsp, signingSecret: "secret", tracker: tracker}
+
+			w := httptest.NewRecorder()
+			h.interactivityHandler(w, newSignedRequest(t, "/slack/interactivity", "secret", form.Encode()))
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+			}
+			if tt.refuse {
+				if len(dsp.reqs) != 0 {
+					t.Error("refused interaction is dispatched")
+				}
+				return
+			}
+			<-tracker.tracked
+			<-dsp.reqs
+			select {
+			case <-tracker.released:
+			case <-time.After(5 * time.Second):
+				t.Fatal("dispatch is not released")
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+package slack_event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/source"
+)
+
+// Slack limits of modals.
+const (
+	maxModalTitleLength      = 24
+	maxPrivateMetadataLength = 3000
+	maxTextInputLength       = 3000
+)
+
+// ModalFieldType is an input element of ModalField.
+type ModalFieldType string
+
+const (
+	ModalFieldText        ModalFieldType = "text"
+	ModalFieldTextarea    ModalFieldType = "textarea"
+	ModalFieldSelect      ModalFieldType = "select"
+	ModalFieldMultiSelect ModalFieldType = "multi_select"
+)
+
+// Modal is opened instead of dispatching, by the shortcut or the button which has CallbackID.
+// submitted values are dispatched as "slack-event-view_submission-<callback_id>".
+type Modal struct {
+	// CallbackID is callback_id of the shortcut, or action_id of the button.
+	CallbackID string `json:"callback_id"`
+	Title      string `json:"title"`
+	// Submit is a label of the submit button. default is "Dispatch".
+	Submit string        `json:"submit,omitempty"`
+	Fields []*ModalField `json:"fields"`
+}
+
+// ModalField is an input of Modal. the value is dispatched under Name.
+type ModalField struct {
+	Name        string         `json:"name"`
+	Label       string         `json:"label"`
+	Type        ModalFieldType `json:"type,omitempty"`
+	Placeholder string         `json:"placeholder,omitempty"`
+	Hint        string         `json:"hint,omitempty"`
+	Optional    bool           `json:"optional,omitempty"`
+	// Options are values of select and multi_select.
+	Options   []string `json:"options,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	// Pattern is a regular expression which text values must match.
+	Pattern string `json:"pattern,omitempty"`
+	// PatternError is shown if Pattern does not match.
+	PatternError string `json:"pattern_error,omitempty"`
+	// InitialFromMessage fills text with the source message of the message shortcut or the button.
+	InitialFromMessage bool `json:"initial_from_message,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// ViewSubmissionDispatch has submitted values. Values are string, or []string for multi_select.
+type ViewSubmissionDispatch struct {
+	CallbackID string                 `json:"callback_id"`
+	TeamID     string                 `json:"team_id"`
+	UserID     string                 `json:"user_id"`
+	UserName   string                 `json:"user_name"`
+	Values     map[string]interface{} `json:"values"`
+	// Origin is the interaction which opened the modal.
+	Origin *InteractionDispatch `json:"origin,omitempty"`
+}
+
+// modalMetadata is kept in private_metadata of the view between views.open and view_submission.
+type modalMetadata struct {
+	Origin      *InteractionDispatch `json:"origin,omitempty"`
+	ResponseURL string               `json:"response_url,omitempty"`
+}
+
+// ParseModals parses JSON array of Modal and validates it.
+func ParseModals(b []byte) ([]*Modal, error) {
+	var modals []*Modal
+	err := json.Unmarshal(b, &modals)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modals: %w", err)
+	}
+
+	callbackIDs := make(map[string]bool)
+	for idx, modal := range modals {
+		if modal.CallbackID == "" {
+			return nil, fmt.Errorf("modal #%d: callback_id is required", idx)
+		}
+		if callbackIDs[modal.CallbackID] {
+			return nil, fmt.Errorf("modal %s: duplicated callback_id", modal.CallbackID)
+		}
+		callbackIDs[modal.CallbackID] = true
+
+		if modal.Title == "" {
+			return nil, fmt.Errorf("modal %s: title is required", modal.CallbackID)
+		}
+		if v := utf8.RuneCountInString(modal.Title); v > maxModalTitleLength {
+			return nil, fmt.Errorf("modal %s: title must be up to %d characters: %d", modal.CallbackID, maxModalTitleLength, v)
+		}
+		if len(modal.Fields) == 0 {
+			return nil, fmt.Errorf("modal %s: fields are required", modal.CallbackID)
+		}
+
+		names := make(map[string]bool)
+		for _, field := range modal.Fields {
+			if field.Name == "" {
+				return nil, fmt.Errorf("modal %s: field name is required", modal.CallbackID)
+			}
+			if names[field.Name] {
+				return nil, fmt.Errorf("modal %s: duplicated field %s", modal.CallbackID, field.Name)
+			}
+			names[field.Name] = true
+			if err := field.validate(); err != nil {
+				return nil, fmt.Errorf("modal %s: field %s: %w", modal.CallbackID, field.Name, err)
+			}
+		}
+	}
+
+	return modals, nil
+}
+
+func (field *ModalField) validate() error {
+	if field.Label == "" {
+		return errors.New("label is required")
+	}
+	if field.Type == "" {
+		field.Type = ModalFieldText
+	}
+	switch field.Type {
+	case ModalFieldText, ModalFieldTextarea:
+		if len(field.Options) != 0 {
+			return fmt.Errorf("options are not available for %s", field.Type)
+		}
+		if field.MaxLength < 0 || field.MaxLength > maxTextInputLength {
+			return fmt.Errorf("max_length must be between 0 and %d", maxTextInputLength)
+		}
+		if field.Pattern != "" {
+			re, err := regexp.Compile(field.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern: %w", err)
+			}
+			field.pattern = re
+		}
+	case ModalFieldSelect, ModalFieldMultiSelect:
+		if len(field.Options) == 0 {
+			return fmt.Errorf("options are required for %s", field.Type)
+		}
+		if field.Pattern != "" || field.MaxLength != 0 || field.InitialFromMessage {
+			return fmt.Errorf("pattern, max_length and initial_from_message are not available for %s", field.Type)
+		}
+	default:
+		return fmt.Errorf("type must be text, textarea, select or multi_select: %s", field.Type)
+	}
+
+	return nil
+}
+
+// modalsFromSettings reads modals or modals_file setting, or SLACK_MODALS or SLACK_MODALS_FILE. returns nil if all are empty.
+func modalsFromSettings(settings source.Settings) ([]*Modal, error) {
+	modals := settings.Get("modals", "SLACK_MODALS")
+	modalsFile := settings.Get("modals_file", "SLACK_MODALS_FILE")
+	switch {
+	case modals != "" && modalsFile != "":
+		return nil, errors.New("modals and modals_file are exclusive")
+	case modals != "":
+		return ParseModals([]byte(modals))
+	case modalsFile != "":
+		b, err := os.ReadFile(modalsFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParseModals(b)
+	default:
+		return nil, nil
+	}
+}
+
+// viewRequest builds the modal. metadata must fit in private_metadata.
+func (modal *Modal) viewRequest(origin *InteractionDispatch, metadata string) slack.ModalViewRequest {
+	submit := modal.Submit
+	if submit == "" {
+		submit = "Dispatch"
+	}
+
+	blocks := make([]slack.Block, 0, len(modal.Fields))
+	for _, field := range modal.Fields {
+		var placeholder, hint *slack.TextBlockObject
+		if field.Placeholder != "" {
+			placeholder = slack.NewTextBlockObject(slack.PlainTextType, field.Placeholder, false, false)
+		}
+		if field.Hint != "" {
+			hint = slack.NewTextBlockObject(slack.PlainTextType, field.Hint, false, false)
+		}
+
+		var element slack.BlockElement
+		switch field.Type {
+		case ModalFieldSelect, ModalFieldMultiSelect:
+			options := make([]*slack.OptionBlockObject, 0, len(field.Options))
+			for _, option := range field.Options {
+				options = append(options, slack.NewOptionBlockObject(option, slack.NewTextBlockObject(slack.PlainTextType, option, false, false), nil))
+			}
+			if field.Type == ModalFieldSelect {
+				element = slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, placeholder, field.Name, options...)
+			} else {
+				element = slack.NewOptionsMultiSelectBlockElement(slack.MultiOptTypeStatic, placeholder, field.Name, options...)
+			}
+		default:
+			input := slack.NewPlainTextInputBlockElement(placeholder, field.Name)
+			input.Multiline = field.Type == ModalFieldTextarea
+			input.MaxLength = field.MaxLength
+			if field.InitialFromMessage && origin != nil {
+				input.InitialValue = truncateRunes(origin.Text, field.maxLength())
+			}
+			element = input
+		}
+
+		block := slack.NewInputBlock(field.Name, slack.NewTextBlockObject(slack.PlainTextType, field.Label, false, false), hint, element)
+		block.Optional = field.Optional
+		blocks = append(blocks, block)
+	}
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      modal.CallbackID,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, modal.Title, false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, submit, false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks:          slack.Blocks{BlockSet: blocks},
+		PrivateMetadata: metadata,
+	}
+}
+
+func (field *ModalField) maxLength() int {
+	if field.MaxLength != 0 {
+		return field.MaxLength
+	}
+
+	return maxTextInputLength
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n])
+}
+
+// encode returns JSON within maxPrivateMetadataLength. the message text, then the origin, are dropped if it is too long.
+func (md *modalMetadata) encode() (string, error) {
+	b, err := json.Marshal(md)
+	if err != nil {
+		return "", err
+	}
+	if len(b) <= maxPrivateMetadataLength || md.Origin == nil {
+		return string(b), nil
+	}
+
+	origin := *md.Origin
+	origin.Text = ""
+	shrunk := &modalMetadata{Origin: &origin, ResponseURL: md.ResponseURL}
+	if b, err = json.Marshal(shrunk); err == nil && len(b) <= maxPrivateMetadataLength {
+		return string(b), nil
+	}
+
+	return (&modalMetadata{ResponseURL: md.ResponseURL}).encode()
+}
+
+// openModal opens modal for the interaction. trigger_id expires in 3 seconds, so it is called before acknowledging.
+func (h *slackEventHandler) openModal(ctx context.Context, modal *Modal, callback *slack.InteractionCallback, origin *InteractionDispatch) error {
+	metadata, err := (&modalMetadata{Origin: origin, ResponseURL: callback.ResponseURL}).encode()
+	if err != nil {
+		return err
+	}
+
+	return callAPI(ctx, "views.open", func(ctx context.Context) error {
+		_, err := h.slCli.OpenViewContext(ctx, callback.TriggerID, modal.viewRequest(origin, metadata))
+		return err
+	})
+}
+
+// viewSubmissionHandler validates the submitted values, and dispatches them after closing the modal.
+// validation errors are shown under each field.
+func (h *slackEventHandler) viewSubmissionHandler(ctx context.Context, w http.ResponseWriter, original json.RawMessage, callback *slack.InteractionCallback) {
+	modal, ok := h.modals[callback.View.CallbackID]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf("unknown modal: %s", callback.View.CallbackID)))
+		log.Warnf(ctx, "unknown modal: %s", callback.View.CallbackID)
+		return
+	}
+
+	var state map[string]map[string]slack.BlockAction
+	if callback.View.State != nil {
+		state = callback.View.State.Values
+	}
+	values, validationErrors := modal.values(state)
+	if len(validationErrors) != 0 {
+		writeJSON(ctx, w, slack.NewErrorsViewSubmissionResponse(validationErrors))
+		return
+	}
+
+	md := &modalMetadata{}
+	if v := callback.View.PrivateMetadata; v != "" {
+		if err := json.Unmarshal([]byte(v), md); err != nil {
+			log.Warnf(ctx, "invalid private_metadata: %s", err.Error())
+		}
+	}
+
+	ghe := &DispatchGitHubEventRequest{
+		SlackEvent:     original,
+		SlackEventType: fmt.Sprintf("%s-%s", callback.Type, modal.CallbackID),
+		ViewSubmission: &ViewSubmissionDispatch{
+			CallbackID: modal.CallbackID,
+			TeamID:     callback.Team.ID,
+			UserID:     callback.User.ID,
+			UserName:   callback.User.Name,
+			Values:     values,
+			Origin:     md.Origin,
+		},
+		attributes:  modal.attributes(callback, md.Origin, values),
+		message:     md.Origin.messageRef(),
+		responseURL: md.ResponseURL,
+	}
+	if callback.TriggerID != "" {
+		ghe.eventID = "interaction:" + callback.TriggerID
+	}
+
+	eventType, _ := ghe.EventType()
+	metrics.ObserveSourceEvent("slack", ghe.metricsEventType())
+	log.Info(ctx, "view submission received", "callback_id", modal.CallbackID, "user", callback.User.ID)
+
+	if !source.Go(h.tracker, func() { h.dispatchAndRespond(detach(ctx), eventType, ghe) }) {
+		// shutting down. the modal stays open with an error, so the user can submit it again.
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// an empty response closes the modal.
+	w.WriteHeader(http.StatusOK)
+}
+
+// values returns submitted values by field name, or error messages by block_id.
+func (modal *Modal) values(state map[string]map[string]slack.BlockAction) (map[string]interface{}, map[string]string) {
+	values := make(map[string]interface{}, len(modal.Fields))
+	validationErrors := make(map[string]string)
+	for _, field := range modal.Fields {
+		action := state[field.Name][field.Name]
+
+		if field.Type == ModalFieldMultiSelect {
+			selected := make([]string, 0, len(action.SelectedOptions))
+			for _, option := range action.SelectedOptions {
+				selected = append(selected, option.Value)
+			}
+			if msg := field.check(selected...); msg != "" {
+				validationErrors[field.Name] = msg
+			}
+			values[field.Name] = selected
+			continue
+		}
+
+		value := action.Value
+		if field.Type == ModalFieldSelect {
+			value = action.SelectedOption.Value
+		}
+		value = strings.TrimSpace(value)
+		var msg string
+		if value == "" {
+			msg = field.check()
+		} else {
+			msg = field.check(value)
+		}
+		if msg != "" {
+			validationErrors[field.Name] = msg
+		}
+		values[field.Name] = value
+	}
+
+	return values, validationErrors
+}
+
+// check returns an error message for the values, or empty string if they are valid.
+func (field *ModalField) check(values ...string) string {
+	if len(values) == 0 {
+		if field.Optional {
+			return ""
+		}
+		return "required"
+	}
+
+	for _, value := range values {
+		switch field.Type {
+		case ModalFieldSelect, ModalFieldMultiSelect:
+			if !contains(field.Options, value) {
+				return fmt.Sprintf("unknown option: %s", value)
+			}
+		default:
+			if v := utf8.RuneCountInString(value); v > field.maxLength() {
+				return fmt.Sprintf("must be up to %d characters", field.maxLength())
+			}
+			if field.pattern != nil && !field.pattern.MatchString(value) {
+				if field.PatternError != "" {
+					return field.PatternError
+				}
+				return fmt.Sprintf("must match %s", field.Pattern)
+			}
+		}
+	}
+
+	return ""
+}
+
+// attributes are routing attributes of the submission. values of select fields are added as "field.<name>".
+func (modal *Modal) attributes(callback *slack.InteractionCallback, origin *InteractionDispatch, values map[string]interface{}) map[string]string {
+	attrs := map[string]string{
+		"team":   callback.Team.ID,
+		"event":  string(callback.Type),
+		"action": modal.CallbackID,
+	}
+	if origin != nil {
+		attrs["channel"] = origin.ChannelID
+	}
+	for _, field := range modal.Fields {
+		if field.Type != ModalFieldSelect {
+			continue
+		}
+		if v, ok := values[field.Name].(string); ok && v != "" {
+			attrs["field."+field.Name] = v
+		}
+	}
+
+	return attrs
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,250 @@
+package slack_event
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/vvakame/se2gha/togha"
+)
+
+const testModals = `[{
+	"callback_id": "new_issue",
+	"title": "New issue",
+	"fields": [
+		{"name": "title", "label": "Title", "max_length": 10, "initial_from_message": true},
+		{"name": "labels", "label": "Labels", "type": "multi_select", "options": ["bug", "enhancement"], "optional": true},
+		{"name": "repo", "label": "Repository", "type": "select", "options": ["vvakame/se2gha"]},
+		{"name": "priority", "label": "Priority", "pattern": "^p[0-3]$", "pattern_error": "p0 to p3", "optional": true}
+	]
+}]`
+
+func TestParseModals(t *testing.T) {
+	tests := []struct {
+		name    string
+		modals  string
+		wantErr bool
+	}{
+		{"valid", testModals, false},
+		{"missing callback_id", `[{"title":"a","fields":[{"name":"a","label":"a"}]}]`, true},
+		{"duplicated callback_id", `[{"callback_id":"a","title":"a","fields":[{"name":"a","label":"a"}]},{"callback_id":"a","title":"a","fields":[{"name":"a","label":"a"}]}]`, true},
+		{"too long title", `[{"callback_id":"a","title":"1234567890123456789012345","fields":[{"name":"a","label":"a"}]}]`, true},
+		{"no fields", `[{"callback_id":"a","title":"a"}]`, true},
+		{"duplicated field", `[{"callback_id":"a","title":"a","fields":[{"name":"a","label":"a"},{"name":"a","label":"b"}]}]`, true},
+		{"select without options", `[{"callback_id":"a","title":"a","fields":[{"name":"a","label":"a","type":"select"}]}]`, true},
+		{"unknown type", `[{"callback_id":"a","title":"a","fields":[{"name":"a","label":"a","type":"date"}]}]`, true},
+		{"invalid pattern", `[{"callback_id":"a","title":"a","fields":[{"name":"a","label":"a","pattern":"("}]}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseModals([]byte(tt.modals))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestModal_values(t *testing.T) {
+	modals, err := ParseModals([]byte(testModals))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modal := modals[0]
+
+	state := func(title, repo, priority string, labels ...string) map[string]map[string]slack.BlockAction {
+		options := make([]slack.OptionBlockObject, 0, len(labels))
+		for _, label := range labels {
+			options = append(options, slack.OptionBlockObject{Value: label})
+		}
+		return map[string]map[string]slack.BlockAction{
+			"title":    {"title": {Value: title}},
+			"labels":   {"labels": {SelectedOptions: options}},
+			"repo":     {"repo": {SelectedOption: slack.OptionBlockObject{Value: repo}}},
+			"priority": {"priority": {Value: priority}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		state  map[string]map[string]slack.BlockAction
+		values map[string]interface{}
+		errors map[string]string
+	}{
+		{
+			name:   "valid",
+			state:  state(" crash ", "vvakame/se2gha", "p1", "bug"),
+			values: map[string]interface{}{"title": "crash", "labels": []string{"bug"}, "repo": "vvakame/se2gha", "priority": "p1"},
+			errors: map[string]string{},
+		},
+		{
+			name:   "invalid",
+			state:  state("", "vvakame/other", "high", "wontfix"),
+			values: map[string]interface{}{"title": "", "labels": []string{"wontfix"}, "repo": "vvakame/other", "priority": "high"},
+			errors: map[string]string{"title": "required", "labels": "unknown option: wontfix", "repo": "unknown option: vvakame/other", "priority": "p0 to p3"},
+		},
+		{
+			name:   "too long",
+			state:  state("12345678901", "vvakame/se2gha", ""),
+			values: map[string]interface{}{"title": "12345678901", "labels": []string{}, "repo": "vvakame/se2gha", "priority": ""},
+			errors: map[string]string{"title": "must be up to 10 characters"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, errors := modal.values(tt.state)
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("unexpected values: %#v", values)
+			}
+			if !reflect.DeepEqual(errors, tt.errors) {
+				t.Errorf("unexpected errors: %#v", errors)
+			}
+		})
+	}
+}
+
+func Test_slackEventHandler_modal(t *testing.T) {
+	modals, err := ParseModals([]byte(testModals))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened := make(chan *slack.ModalViewRequest, 1)
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/views.open" {
+			t.Errorf("unexpected api: %s", r.URL.Path)
+		}
+		body := &struct {
+			TriggerID string                  `json:"trigger_id"`
+			View      *slack.ModalViewRequest `json:"view"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			t.Error(err)
+		}
+		if body.TriggerID != "1.2.a" {
+			t.Errorf("unexpected trigger_id: %s", body.TriggerID)
+		}
+		opened <- body.View
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer apiSrv.Close()
+
+	dsp := &recordingDispatcher{reqs: make(chan togha.DispatchRequest, 1)}
+	h := &slackEventHandler{
+		slCli:         slack.New("token", slack.OptionAPIURL(apiSrv.URL+"/")),
+		dsp:           dsp,
+		signingSecret: "secret",
+		modals:        map[string]*Modal{"new_issue": modals[0]},
+	}
+
+	post := func(payload string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.interactivityHandler(w, newSignedRequest(t, "/slack/interactivity", "secret", url.Values{"payload": {payload}}.Encode()))
+		return w
+	}
+
+	w := post(`{"type":"message_action","callback_id":"new_issue","trigger_id":"1.2.a","team":{"id":"T1","domain":"vvakame"},"user":{"id":"U1","name":"vvakame"},"channel":{"id":"C1"},"message":{"ts":"1600000000.000100","text":"it crashes on startup"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+	}
+	var view *slack.ModalViewRequest
+	select {
+	case view = <-opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("modal is not opened")
+	}
+	if view.CallbackID != "new_issue" || len(view.Blocks.BlockSet) != 4 {
+		t.Fatalf("unexpected view: %+v", view)
+	}
+	select {
+	case req := <-dsp.reqs:
+		t.Fatalf("dispatched before submission: %+v", req)
+	default:
+	}
+
+	t.Run("validation error", func(t *testing.T) {
+		submission := map[string]interface{}{
+			"type": "view_submission",
+			"team": map[string]string{"id": "T1"},
+			"user": map[string]string{"id": "U1", "name": "vvakame"},
+			"view": map[string]interface{}{"callback_id": "new_issue", "private_metadata": view.PrivateMetadata, "state": map[string]interface{}{"values": map[string]interface{}{}}},
+		}
+		b, _ := json.Marshal(submission)
+		w := post(string(b))
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+		}
+		res := &slack.ViewSubmissionResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		if res.ResponseAction != slack.RAErrors || res.Errors["title"] != "required" || res.Errors["repo"] != "required" {
+			t.Errorf("unexpected response: %s", w.Body.String())
+		}
+	})
+
+	submission := map[string]interface{}{
+		"type":       "view_submission",
+		"trigger_id": "1.2.b",
+		"team":       map[string]string{"id": "T1"},
+		"user":       map[string]string{"id": "U1", "name": "vvakame"},
+		"view": map[string]interface{}{
+			"callback_id":      "new_issue",
+			"private_metadata": view.PrivateMetadata,
+			"state": map[string]interface{}{"values": map[string]interface{}{
+				"title":  map[string]interface{}{"title": map[string]string{"type": "plain_text_input", "value": "crash"}},
+				"labels": map[string]interface{}{"labels": map[string]interface{}{"type": "multi_static_select", "selected_options": []map[string]string{{"value": "bug"}}}},
+				"repo":   map[string]interface{}{"repo": map[string]interface{}{"type": "static_select", "selected_option": map[string]string{"value": "vvakame/se2gha"}}},
+			}},
+		},
+	}
+	t.Run("dispatch", func(t *testing.T) {
+		b, _ := json.Marshal(submission)
+		w := post(string(b))
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Fatalf("unexpected response: %d, %s", w.Code, w.Body.String())
+		}
+
+		var req *DispatchGitHubEventRequest
+		select {
+		case r := <-dsp.reqs:
+			req = r.(*DispatchGitHubEventRequest)
+		case <-time.After(5 * time.Second):
+			t.Fatal("not dispatched")
+		}
+		if v, _ := req.EventType(); v != "slack-event-view_submission-new_issue" {
+			t.Errorf("unexpected event type: %s", v)
+		}
+		sub := req.ViewSubmission
+		wantValues := map[string]interface{}{"title": "crash", "labels": []string{"bug"}, "repo": "vvakame/se2gha", "priority": ""}
+		if !reflect.DeepEqual(sub.Values, wantValues) {
+			t.Errorf("unexpected values: %#v", sub.Values)
+		}
+		if sub.Origin == nil || sub.Origin.Link != "https://vvakame.slack.com/archives/C1/p1600000000000100" || sub.Origin.Text != "it crashes on startup" {
+			t.Errorf("unexpected origin: %+v", sub.Origin)
+		}
+		attrs := req.SourceAttributes()
+		if attrs["field.repo"] != "vvakame/se2gha" || attrs["channel"] != "C1" || attrs["action"] != "new_issue" {
+			t.Errorf("unexpected attributes: %v", attrs)
+		}
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		h.tracker = newTestTracker(true)
+		defer func() { h.tracker = nil }()
+
+		b, _ := json.Marshal(submission)
+		w := post(string(b))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected response: %d, %s", w.Code, w.Body.String())
+		}
+		if len(dsp.reqs) != 0 {
+			t.Error("refused submission is dispatched")
+		}
+	})
+}
@@ -12,15 +12,20 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
+	"github.com/vvakame/se2gha/health"
 	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/source"
 	"github.com/vvakame/se2gha/togha"
+	"github.com/vvakame/se2gha/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SlackChallengeRequest struct {
@@ -37,13 +42,32 @@ type slackEventHandler struct {
 	slCli         *slack.Client
 	dsp           togha.EventDispatcher
 	signingSecret string
+	// modals by callback ID.
+	modals           map[string]*Modal
+	feedbackMode     FeedbackMode
+	feedbackReaction string
+	// tracker counts work which outlives requests. see source.Go.
+	tracker source.Tracker
+
+	mu sync.Mutex
+	// userID is the user of the access token. see botUserID.
+	userID string
 }
 
 type DispatchGitHubEventRequest struct {
 	SlackEvent     json.RawMessage `json:"slack_event"`
 	SlackEventType string          `json:"slack_event_type"`
 
-	ReactionAdded *ReactionAddedEventDispatch `json:"reaction_added,omitempty"`
+	ReactionAdded  *ReactionAddedEventDispatch `json:"reaction_added,omitempty"`
+	Interaction    *InteractionDispatch        `json:"interaction,omitempty"`
+	ViewSubmission *ViewSubmissionDispatch     `json:"view_submission,omitempty"`
+
+	attributes map[string]string
+	eventID    string
+	// message is the source message. nil if the event has no message.
+	message *messageRef
+	// responseURL tells the result to the user of interactions and view submissions.
+	responseURL string
 }
 
 func (req *DispatchGitHubEventRequest) EventType() (string, error) {
@@ -59,6 +83,41 @@ func (req *DispatchGitHubEventRequest) Payload() (json.RawMessage, error) {
 	return b, nil
 }
 
+func (req *DispatchGitHubEventRequest) Source() string {
+	return "slack"
+}
+
+func (req *DispatchGitHubEventRequest) SourceAttributes() map[string]string {
+	return req.attributes
+}
+
+// metricsEventType is EventType with normalized reaction. e.g. skin tone variants are counted as the base emoji.
+func (req *DispatchGitHubEventRequest) metricsEventType() string {
+	if req.ReactionAdded == nil {
+		return fmt.Sprintf("slack-event-%s", req.SlackEventType)
+	}
+
+	return fmt.Sprintf("slack-event-%s-%s", req.attributes["event"], normalizeReaction(req.ReactionAdded.Reaction))
+}
+
+// normalizeReaction strips modifiers. e.g. "+1::skin-tone-2" to "+1"
+func normalizeReaction(reaction string) string {
+	reaction, _, _ = strings.Cut(reaction, "::")
+	return reaction
+}
+
+func (req *DispatchGitHubEventRequest) SourceBody() []byte {
+	return req.SlackEvent
+}
+
+func (req *DispatchGitHubEventRequest) IdempotencyKey() string {
+	if req.eventID == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("slack:%s", req.eventID)
+}
+
 type ReactionAddedEventDispatch struct {
 	UserName string `json:"user_name"`
 	Text     string `json:"text"`
@@ -66,24 +125,183 @@ type ReactionAddedEventDispatch struct {
 	Link     string `json:"link"`
 }
 
+func init() {
+	source.Register(&slackSource{})
+}
+
+type slackSource struct {
+	mu sync.Mutex
+	// healthToken is the token of healthCache. the cache is dropped when the token is rotated.
+	healthToken string
+	healthCache *health.Cache
+}
+
+func (s *slackSource) Name() string {
+	return "slack"
+}
+
+func (s *slackSource) DefaultPath() string {
+	return "/slack"
+}
+
+func (s *slackSource) SettingKeys() []string {
+	return []string{"access_token", "signing_secret", "modals", "modals_file", "feedback", "feedback_reaction", "transport", "app_token", "socket_connections"}
+}
+
+func (s *slackSource) Mount(ctx context.Context, mux *http.ServeMux, prefix string, dsp togha.EventDispatcher, settings source.Settings) error {
+	return mount(ctx, mux, prefix, dsp, settings)
+}
+
+var _ source.HealthChecker = (*slackSource)(nil)
+
+// CheckHealth verifies the access token by auth.test.
+func (s *slackSource) CheckHealth(ctx context.Context, settings source.Settings) []*health.Check {
+	token := settings.Get("access_token", "SLACK_ACCESS_TOKEN")
+
+	s.mu.Lock()
+	if s.healthCache == nil || s.healthToken != token {
+		s.healthToken = token
+		s.healthCache = &health.Cache{}
+	}
+	cache := s.healthCache
+	s.mu.Unlock()
+
+	check := cache.Do(ctx, "slack:auth.test", func(ctx context.Context) error {
+		_, err := slack.New(token).AuthTestContext(ctx)
+		return err
+	})
+
+	return []*health.Check{check}
+}
+
+var _ source.ResolverProvider = (*slackSource)(nil)
+
+// Resolver calls Slack APIs for events deferred to the outbox, and gives feedback of queued events.
+// it is nil without the access token.
+func (s *slackSource) Resolver(settings source.Settings) (togha.Resolver, error) {
+	token := settings.Get("access_token", "SLACK_ACCESS_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+	feedbackMode, feedbackReaction, err := feedbackFromSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &outboxHandler{h: &slackEventHandler{
+		slCli:            slack.New(token),
+		feedbackMode:     feedbackMode,
+		feedbackReaction: feedbackReaction,
+	}}, nil
+}
+
+var _ togha.DeliveryObserver = (*outboxHandler)(nil)
+
+// outboxHandler builds and reports events which the slack source queued to the outbox.
+type outboxHandler struct {
+	h *slackEventHandler
+}
+
+// Resolve rebuilds the request from the body of Events API, and fills it by Slack APIs.
+func (r *outboxHandler) Resolve(ctx context.Context, req *togha.StoredRequest) (togha.DispatchRequest, error) {
+	body := req.SourceBody()
+	ev, err := slackevents.ParseEvent(body, slackevents.OptionNoVerifyToken())
+	if err != nil {
+		return nil, &togha.ValidationError{EventType: req.Type, Reason: err.Error()}
+	}
+	ghe, err := r.h.eventCallbackHandler(ctx, body, &ev)
+	if err != nil {
+		return nil, &togha.ValidationError{EventType: req.Type, Reason: err.Error()}
+	}
+	if ghe == nil {
+		return nil, nil
+	}
+	if cbe, ok := ev.Data.(*slackevents.EventsAPICallbackEvent); ok {
+		ghe.eventID = cbe.EventID
+	}
+
+	err = r.h.resolveEvent(ctx, ghe)
+	if err != nil {
+		return nil, err
+	}
+
+	return ghe, nil
+}
+
+// Delivered tells the final result to response_url of the request, and in the source message.
+// slash commands have no source message.
+func (r *outboxHandler) Delivered(ctx context.Context, req *togha.StoredRequest, res *togha.DispatchResult, err error) {
+	if responseURL := req.ReplyRef()["response_url"]; responseURL != "" {
+		r.h.respond(ctx, responseURL, dispatchResultText(req.Type, res, err))
+	}
+
+	ref := req.CallbackRef()
+	if ref["channel"] == "" || ref["ts"] == "" {
+		return
+	}
+
+	r.h.feedback(ctx, &messageRef{ChannelID: ref["channel"], Timestamp: ref["ts"], ThreadTS: ref["thread_ts"]}, req.Type, res, err)
+}
+
+// HandleEvent mounts handlers on /slack .
 func HandleEvent(ctx context.Context, mux *http.ServeMux, dsp togha.EventDispatcher) error {
-	slackAccessToken := os.Getenv("SLACK_ACCESS_TOKEN")
+	return mount(ctx, mux, "/slack", dsp, nil)
+}
+
+func mount(ctx context.Context, mux *http.ServeMux, prefix string, dsp togha.EventDispatcher, settings source.Settings) error {
+	slackAccessToken := settings.Get("access_token", "SLACK_ACCESS_TOKEN")
 	if slackAccessToken == "" {
-		return errors.New("SLACK_ACCESS_TOKEN environment variable is required")
+		return errors.New("access_token setting or SLACK_ACCESS_TOKEN environment variable is required")
 	}
-	slackSigningSecret := os.Getenv("SLACK_SIGNING_SECRET")
-	if slackSigningSecret == "" {
-		return errors.New("SLACK_SIGNING_SECRET environment variable is required")
+	transport, err := ParseTransport(settings.Get("transport", "SLACK_TRANSPORT"))
+	if err != nil {
+		return err
+	}
+	var socketCfg *socketModeConfig
+	slackSigningSecret := settings.Get("signing_secret", "SLACK_SIGNING_SECRET")
+	switch transport {
+	case TransportSocketMode:
+		// requests come over the connection authenticated by the app token, they are not signed.
+		socketCfg, err = socketModeFromSettings(settings)
+		if err != nil {
+			return err
+		}
+	default:
+		if slackSigningSecret == "" {
+			return errors.New("signing_secret setting or SLACK_SIGNING_SECRET environment variable is required")
+		}
+	}
+
+	modals, err := modalsFromSettings(settings)
+	if err != nil {
+		return err
+	}
+	feedbackMode, feedbackReaction, err := feedbackFromSettings(settings)
+	if err != nil {
+		return err
 	}
 
 	api := slack.New(slackAccessToken)
 
 	h := &slackEventHandler{
-		slCli:         api,
-		dsp:           dsp,
-		signingSecret: slackSigningSecret,
+		slCli:            api,
+		dsp:              dsp,
+		signingSecret:    slackSigningSecret,
+		modals:           make(map[string]*Modal, len(modals)),
+		feedbackMode:     feedbackMode,
+		feedbackReaction: feedbackReaction,
+		tracker:          source.TrackerFromContext(ctx),
+	}
+	for _, modal := range modals {
+		h.modals[modal.CallbackID] = modal
 	}
-	mux.HandleFunc("/slack/events/action", h.eventHandler)
+	if socketCfg != nil {
+		h.startSocketMode(ctx, slackAccessToken, socketCfg)
+		return nil
+	}
+	mux.HandleFunc(prefix+"/events/action", h.eventHandler)
+	mux.HandleFunc(prefix+"/commands", h.commandHandler)
+	mux.HandleFunc(prefix+"/interactivity", h.interactivityHandler)
 
 	return nil
 }
@@ -95,20 +313,29 @@ func (h *slackEventHandler) eventHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte(err.Error()))
-		log.Warnf(ctx, err.Error())
+		log.Warnf(ctx, "%s", err)
 		return
 	}
 	defer r.Body.Close()
 
-	if s, err := h.checkSignature(ctx, r.Header, b); err != nil {
+	_, span := tracing.Start(ctx, "slack.verify_signature")
+	s, err := h.checkSignature(ctx, r.Header, b)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.ObserveSignatureFailure("slack")
 		w.WriteHeader(s)
 		_, _ = w.Write([]byte(err.Error()))
-		log.Warnf(ctx, err.Error())
+		log.Warnf(ctx, "%s", err)
 		return
 	}
 
+	h.serveEvent(ctx, w, r.Header, b)
+}
+
+// serveEvent handles the verified body of Events API. it is shared by HTTP and Socket Mode.
+func (h *slackEventHandler) serveEvent(ctx context.Context, w http.ResponseWriter, header http.Header, b []byte) {
 	req := &SlackChallengeRequest{}
-	err = json.Unmarshal(b, req)
+	err := json.Unmarshal(b, req)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -130,6 +357,9 @@ func (h *slackEventHandler) eventHandler(w http.ResponseWriter, r *http.Request)
 
 	case "event_callback":
 		log.Debugf(ctx, "event payload: %s", string(b))
+		if v := header.Get("X-Slack-Retry-Num"); v != "" {
+			log.Infof(ctx, "slack retry: %s, %s", v, header.Get("X-Slack-Retry-Reason"))
+		}
 
 		ev, err := slackevents.ParseEvent(
 			b,
@@ -138,7 +368,7 @@ func (h *slackEventHandler) eventHandler(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			_, _ = w.Write([]byte(err.Error()))
-			log.Warnf(ctx, err.Error())
+			log.Warnf(ctx, "%s", err)
 			return
 		}
 
@@ -146,19 +376,39 @@ func (h *slackEventHandler) eventHandler(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			_, _ = w.Write([]byte(err.Error()))
-			log.Warnf(ctx, err.Error())
+			log.Warnf(ctx, "%s", err)
 			return
 		}
-
-		err = h.dsp.Dispatch(ctx, ghe)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(err.Error()))
-			log.Warnf(ctx, err.Error())
+		if ghe == nil {
+			w.WriteHeader(http.StatusOK)
 			return
 		}
+		if cbe, ok := ev.Data.(*slackevents.EventsAPICallbackEvent); ok {
+			ghe.eventID = cbe.EventID
+		}
+		metrics.ObserveSourceEvent("slack", ghe.metricsEventType())
 
-		w.WriteHeader(http.StatusOK)
+		var res *togha.DispatchResult
+		if d, ok := h.dsp.(togha.Deferrer); ok {
+			// the outbox worker calls Slack APIs by the resolver, so the event is acknowledged without waiting them.
+			res, err = d.Defer(ctx, ghe)
+		} else {
+			err = h.resolveEvent(ctx, ghe)
+			if err != nil {
+				// Slack APIs failed. Slack redelivers the event.
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(err.Error()))
+				log.Warnf(ctx, "%s", err)
+				return
+			}
+			res, err = h.dsp.Dispatch(ctx, ghe)
+		}
+		eventType, _ := ghe.EventType()
+		if !source.Go(h.tracker, func() { h.sendFeedback(detach(ctx), ghe, eventType, res, err) }) {
+			// the event is already dispatched. only the feedback is skipped while shutting down.
+			log.Warnf(ctx, "feedback of %s is skipped, se2gha is shutting down", eventType)
+		}
+		togha.WriteDispatchResult(ctx, w, res, err)
 		return
 
 	default:
@@ -168,6 +418,7 @@ func (h *slackEventHandler) eventHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 }
 
+// eventCallbackHandler returns nil request for the event which should not be dispatched.
 func (h *slackEventHandler) eventCallbackHandler(ctx context.Context, original json.RawMessage, ev *slackevents.EventsAPIEvent) (*DispatchGitHubEventRequest, error) {
 	switch eventType := ev.InnerEvent.Type; slackevents.EventsAPIType(eventType) {
 	case slackevents.ReactionAdded:
@@ -183,26 +434,65 @@ func (h *slackEventHandler) eventCallbackHandler(ctx context.Context, original j
 	}
 }
 
+// reactionAddedEventHandler builds the request from the event only. resolveEvent fills the rest by Slack APIs.
 func (h *slackEventHandler) reactionAddedEventHandler(ctx context.Context, original json.RawMessage, ev *slackevents.EventsAPIEvent, rae *slackevents.ReactionAddedEvent) (*DispatchGitHubEventRequest, error) {
-	msgs, _, _, err := h.slCli.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
-		ChannelID: rae.Item.Channel,
-		Timestamp: rae.Item.Timestamp,
+	if h.isFeedbackReaction(ctx, rae) {
+		log.Debugf(ctx, "ignore feedback reaction: %s", rae.Reaction)
+		return nil, nil
+	}
+
+	return &DispatchGitHubEventRequest{
+		SlackEvent:     original,
+		SlackEventType: fmt.Sprintf("%s-%s", rae.Type, rae.Reaction),
+		ReactionAdded: &ReactionAddedEventDispatch{
+			Reaction: rae.Reaction,
+		},
+		attributes: map[string]string{
+			"team":     ev.TeamID,
+			"event":    rae.Type,
+			"channel":  rae.Item.Channel,
+			"reaction": rae.Reaction,
+		},
+		message: &messageRef{
+			ChannelID: rae.Item.Channel,
+			Timestamp: rae.Item.Timestamp,
+		},
+	}, nil
+}
+
+// resolveEvent fills fields of req which need Slack APIs. e.g. the text and the author of the message.
+func (h *slackEventHandler) resolveEvent(ctx context.Context, req *DispatchGitHubEventRequest) error {
+	if req.ReactionAdded == nil || req.message == nil {
+		return nil
+	}
+
+	var msgs []slack.Message
+	err := callAPI(ctx, "conversations.replies", func(ctx context.Context) (err error) {
+		msgs, _, _, err = h.slCli.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
+			ChannelID: req.message.ChannelID,
+			Timestamp: req.message.Timestamp,
+		})
+		return err
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if v := len(msgs); v == 0 {
-		return nil, fmt.Errorf(fmt.Sprintf("unexpected messages len: %d", v))
+		return fmt.Errorf("unexpected messages len: %d", v)
 	} else if v != 1 {
 		log.Debugf(ctx, "messages len: %d", v)
 	}
 
-	userProfile, err := h.slCli.GetUserProfileContext(ctx, &slack.GetUserProfileParameters{
-		UserID:        msgs[0].User,
-		IncludeLabels: false,
+	var userProfile *slack.UserProfile
+	err = callAPI(ctx, "users.profile.get", func(ctx context.Context) (err error) {
+		userProfile, err = h.slCli.GetUserProfileContext(ctx, &slack.GetUserProfileParameters{
+			UserID:        msgs[0].User,
+			IncludeLabels: false,
+		})
+		return err
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	slackName := userProfile.DisplayName
@@ -211,26 +501,22 @@ func (h *slackEventHandler) reactionAddedEventHandler(ctx context.Context, origi
 	}
 
 	msg := msgs[0]
-	text := msg.Text
 	messageURL, err := h.buildSlackURL(ctx, &slackURLFragment{
-		ChannelID: rae.Item.Channel,
+		ChannelID: req.message.ChannelID,
 		Timestamp: msg.Timestamp,
 		ThreadTS:  msg.ThreadTimestamp,
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &DispatchGitHubEventRequest{
-		SlackEvent:     original,
-		SlackEventType: fmt.Sprintf("%s-%s", rae.Type, rae.Reaction),
-		ReactionAdded: &ReactionAddedEventDispatch{
-			UserName: slackName,
-			Text:     text,
-			Reaction: rae.Reaction,
-			Link:     messageURL,
-		},
-	}, nil
+	req.ReactionAdded.UserName = slackName
+	req.ReactionAdded.Text = msg.Text
+	req.ReactionAdded.Link = messageURL
+	req.message.Timestamp = msg.Timestamp
+	req.message.ThreadTS = msg.ThreadTimestamp
+
+	return nil
 }
 
 func (h *slackEventHandler) checkSignature(ctx context.Context, header http.Header, body []byte) (int, error) {
@@ -259,15 +545,12 @@ func (h *slackEventHandler) checkSignature(ctx context.Context, header http.Head
 	if slackSignature == "" {
 		return http.StatusBadRequest, errors.New("X-Slack-Signature header is required")
 	}
-	log.Debugf(ctx, "X-Slack-Signature: %s", slackSignature)
 
 	binarySignature, err := hex.DecodeString(strings.TrimPrefix(slackSignature, "v0="))
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
 
-	log.Debugf(ctx, "body length: %d", len(body))
-
 	var buf bytes.Buffer
 	buf.WriteString("v0")
 	buf.WriteString(":")
@@ -277,7 +560,6 @@ func (h *slackEventHandler) checkSignature(ctx context.Context, header http.Head
 
 	hash := hmac.New(sha256.New, []byte(h.signingSecret))
 	hash.Write(buf.Bytes())
-	log.Debugf(ctx, "computed signature: v0=%s", hex.EncodeToString(hash.Sum(nil)))
 	if !hmac.Equal(hash.Sum(nil), binarySignature) {
 		return http.StatusBadRequest, errors.New("signature mismatch")
 	}
@@ -285,6 +567,17 @@ func (h *slackEventHandler) checkSignature(ctx context.Context, header http.Head
 	return 0, nil
 }
 
+// callAPI calls Slack Web API method in a span and records its latency.
+func callAPI(ctx context.Context, method string, f func(ctx context.Context) error) error {
+	ctx, span := tracing.Start(ctx, "slack."+method, trace.WithSpanKind(trace.SpanKindClient))
+	start := time.Now()
+	err := f(ctx)
+	metrics.ObserveSlackAPI(method, start, err)
+	tracing.End(span, err)
+
+	return err
+}
+
 type slackURLFragment struct {
 	TeamName      string
 	ChannelID     string
@@ -297,7 +590,11 @@ func (h *slackEventHandler) buildSlackURL(ctx context.Context, fragment *slackUR
 	if fragment.TeamName != "" {
 		// ok
 	} else {
-		teamInfo, err := h.slCli.GetTeamInfoContext(ctx)
+		var teamInfo *slack.TeamInfo
+		err := callAPI(ctx, "team.info", func(ctx context.Context) (err error) {
+			teamInfo, err = h.slCli.GetTeamInfoContext(ctx)
+			return err
+		})
 		if err != nil {
 			return "", err
 		}
@@ -338,9 +635,13 @@ func (h *slackEventHandler) buildSlackURL(ctx context.Context, fragment *slackUR
 	} else if fragment.Timestamp != "" && fragment.ThreadTS != "" {
 		// ok
 	} else {
-		msgs, _, _, err := h.slCli.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
-			ChannelID: fragment.ChannelID,
-			Timestamp: fragment.Timestamp,
+		var msgs []slack.Message
+		err := callAPI(ctx, "conversations.replies", func(ctx context.Context) (err error) {
+			msgs, _, _, err = h.slCli.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
+				ChannelID: fragment.ChannelID,
+				Timestamp: fragment.Timestamp,
+			})
+			return err
 		})
 		if err != nil {
 			return "", err
@@ -2,7 +2,15 @@ package slack_event
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/vvakame/se2gha/togha"
 )
 
 func Test_slackEventHandler_buildSlackURL(t *testing.T) {
@@ -90,3 +98,127 @@ func Test_slackEventHandler_buildSlackURL(t *testing.T) {
 		})
 	}
 }
+
+func Test_normalizeReaction(t *testing.T) {
+	tests := []struct {
+		reaction string
+		want     string
+	}{
+		{"create-issue", "create-issue"},
+		{"+1::skin-tone-2", "+1"},
+		{"wave::skin-tone-6", "wave"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reaction, func(t *testing.T) {
+			if got := normalizeReaction(tt.reaction); got != tt.want {
+				t.Errorf("normalizeReaction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type deferringDispatcher struct {
+	deferred []togha.DispatchRequest
+}
+
+func (dsp *deferringDispatcher) Dispatch(ctx context.Context, req togha.DispatchRequest) (*togha.DispatchResult, error) {
+	return nil, errors.New("deferrable events must not be dispatched")
+}
+
+func (dsp *deferringDispatcher) Defer(ctx context.Context, req togha.DispatchRequest) (*togha.DispatchResult, error) {
+	dsp.deferred = append(dsp.deferred, req)
+	eventType, _ := req.EventType()
+
+	return &togha.DispatchResult{EventType: eventType, Queued: true}, nil
+}
+
+func Test_slackEventHandler_serveEvent_deferred(t *testing.T) {
+	ctx := context.Background()
+
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, strings.TrimPrefix(r.URL.Path, "/"))
+		switch r.URL.Path {
+		case "/conversations.replies":
+			_, _ = w.Write([]byte(`{"ok":true,"messages":[{"type":"message","user":"U2","text":"it is broken","ts":"1600000000.000100"}]}`))
+		case "/users.profile.get":
+			_, _ = w.Write([]byte(`{"ok":true,"profile":{"display_name":"vvakame"}}`))
+		case "/team.info":
+			_, _ = w.Write([]byte(`{"ok":true,"team":{"id":"T1","name":"vvakame"}}`))
+		default:
+			_, _ = w.Write([]byte(`{"ok":false,"error":"unknown_method"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	api := slack.New("token", slack.OptionAPIURL(srv.URL+"/"))
+
+	body := []byte(`{"type":"event_callback","team_id":"T1","event_id":"Ev01","event":{"type":"reaction_added","user":"U1","reaction":"create-issue","item":{"type":"message","channel":"C1","ts":"1600000000.000100"}}}`)
+	dsp := &deferringDispatcher{}
+	h := &slackEventHandler{slCli: api, dsp: dsp}
+	w := httptest.NewRecorder()
+	h.serveEvent(ctx, w, make(http.Header), body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+	}
+	if len(methods) != 0 {
+		t.Errorf("Slack APIs are called before enqueue: %v", methods)
+	}
+	if len(dsp.deferred) != 1 {
+		t.Fatalf("unexpected deferred: %d", len(dsp.deferred))
+	}
+
+	stored, err := togha.NewStoredRequest(dsp.deferred[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &outboxHandler{h: &slackEventHandler{slCli: api}}
+	req, err := r.Resolve(ctx, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := req.(togha.IdempotencyKeyer).IdempotencyKey(); v != "slack:Ev01" {
+		t.Errorf("unexpected key: %s", v)
+	}
+	ghe := req.(*DispatchGitHubEventRequest)
+	if v := ghe.ReactionAdded; v.UserName != "vvakame" || v.Text != "it is broken" || v.Link != "https://vvakame.slack.com/archives/C1/p1600000000000100" {
+		t.Errorf("unexpected reaction_added: %+v", v)
+	}
+}
+
+func Test_slackEventHandler_serveEvent_trackedFeedback(t *testing.T) {
+	body := []byte(`{"type":"event_callback","team_id":"T1","event_id":"Ev01","event":{"type":"reaction_added","user":"U1","reaction":"create-issue","item":{"type":"message","channel":"C1","ts":"1600000000.000100"}}}`)
+
+	tests := []struct {
+		name   string
+		refuse bool
+	}{
+		{"tracked", false},
+		{"shutting down", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsp := &deferringDispatcher{}
+			tracker := newTestTracker(tt.refuse)
+			h := &slackEventHandler{dsp: dsp, tracker: tracker}
+
+			w := httptest.NewRecorder()
+			h.serveEvent(context.Background(), w, make(http.Header), body)
+			// the event is dispatched even if the feedback is refused.
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+			}
+			if len(dsp.deferred) != 1 {
+				t.Fatalf("unexpected deferred: %d", len(dsp.deferred))
+			}
+			if tt.refuse {
+				return
+			}
+			<-tracker.tracked
+			select {
+			case <-tracker.released:
+			case <-time.After(5 * time.Second):
+				t.Fatal("feedback is not released")
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package slack_event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/togha"
+	"github.com/vvakame/se2gha/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// responseTimeout bounds the dispatch after acknowledging. response_url is valid for 30 minutes.
+const responseTimeout = 5 * time.Minute
+
+// DispatchSlashCommandRequest is dispatched as "slack-command-<verb>" for "/gha <verb> <args>".
+type DispatchSlashCommandRequest struct {
+	SlashCommand *SlashCommandDispatch `json:"slack_command"`
+
+	body        []byte
+	responseURL string
+}
+
+type SlashCommandDispatch struct {
+	Command     string `json:"command"`
+	Verb        string `json:"verb"`
+	Args        string `json:"args"`
+	TeamID      string `json:"team_id"`
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name"`
+	TriggerID   string `json:"trigger_id"`
+}
+
+var _ togha.SourceDescriber = (*DispatchSlashCommandRequest)(nil)
+var _ togha.IdempotencyKeyer = (*DispatchSlashCommandRequest)(nil)
+var _ togha.SourceBodyer = (*DispatchSlashCommandRequest)(nil)
+
+func (req *DispatchSlashCommandRequest) EventType() (string, error) {
+	return fmt.Sprintf("slack-command-%s", req.SlashCommand.Verb), nil
+}
+
+func (req *DispatchSlashCommandRequest) Payload() (json.RawMessage, error) {
+	return json.Marshal(req)
+}
+
+func (req *DispatchSlashCommandRequest) Source() string {
+	return "slack"
+}
+
+func (req *DispatchSlashCommandRequest) SourceAttributes() map[string]string {
+	return map[string]string{
+		"team":    req.SlashCommand.TeamID,
+		"event":   "command",
+		"channel": req.SlashCommand.ChannelID,
+		"command": req.SlashCommand.Command,
+		"verb":    req.SlashCommand.Verb,
+	}
+}
+
+// IdempotencyKey is the trigger_id. Slack issues a new one for each invocation.
+func (req *DispatchSlashCommandRequest) IdempotencyKey() string {
+	if req.SlashCommand.TriggerID == "" {
+		return ""
+	}
+
+	return "slack-command:" + req.SlashCommand.TriggerID
+}
+
+func (req *DispatchSlashCommandRequest) SourceBody() []byte {
+	return req.body
+}
+
+// parseSlashCommand splits text into verb and args. e.g. "deploy production" for "/gha deploy production"
+func parseSlashCommand(body []byte) (*slack.SlashCommand, *DispatchSlashCommandRequest, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd := &slack.SlashCommand{
+		TeamID:      form.Get("team_id"),
+		ChannelID:   form.Get("channel_id"),
+		ChannelName: form.Get("channel_name"),
+		UserID:      form.Get("user_id"),
+		UserName:    form.Get("user_name"),
+		Command:     form.Get("command"),
+		Text:        form.Get("text"),
+		ResponseURL: form.Get("response_url"),
+		TriggerID:   form.Get("trigger_id"),
+	}
+
+	verb, args, _ := strings.Cut(strings.TrimSpace(cmd.Text), " ")
+	if verb == "" {
+		return cmd, nil, nil
+	}
+
+	return cmd, &DispatchSlashCommandRequest{
+		SlashCommand: &SlashCommandDispatch{
+			Command:     cmd.Command,
+			Verb:        strings.ToLower(verb),
+			Args:        strings.TrimSpace(args),
+			TeamID:      cmd.TeamID,
+			ChannelID:   cmd.ChannelID,
+			ChannelName: cmd.ChannelName,
+			UserID:      cmd.UserID,
+			UserName:    cmd.UserName,
+			TriggerID:   cmd.TriggerID,
+		},
+		body:        body,
+		responseURL: cmd.ResponseURL,
+	}, nil
+}
+
+// commandHandler acknowledges the slash command immediately, and posts the dispatch result to response_url later.
+func (h *slackEventHandler) commandHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+	defer r.Body.Close()
+
+	_, span := tracing.Start(ctx, "slack.verify_signature")
+	s, err := h.checkSignature(ctx, r.Header, b)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.ObserveSignatureFailure("slack")
+		w.WriteHeader(s)
+		_, _ = w.Write([]byte(err.Error()))
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+
+	h.serveCommand(ctx, w, b)
+}
+
+// serveCommand handles the verified body of a slash command. it is shared by HTTP and Socket Mode.
+func (h *slackEventHandler) serveCommand(ctx context.Context, w http.ResponseWriter, b []byte) {
+	cmd, req, err := parseSlashCommand(b)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		log.Warnf(ctx, "%s", err)
+		return
+	}
+	if req == nil {
+		writeEphemeral(ctx, w, fmt.Sprintf("usage: %s <verb> [args]", cmd.Command))
+		return
+	}
+
+	eventType, _ := req.EventType()
+	metrics.ObserveSourceEvent("slack", eventType)
+	log.Info(ctx, "slash command received", "command", cmd.Command, "verb", req.SlashCommand.Verb, "user", cmd.UserID, "channel", cmd.ChannelID)
+
+	if !source.Go(h.tracker, func() { h.dispatchAndRespond(detach(ctx), eventType, req) }) {
+		// shutting down. the user can run the command again.
+		writeEphemeral(ctx, w, fmt.Sprintf("`%s` is not dispatched, se2gha is shutting down. try again later", eventType))
+		return
+	}
+
+	writeEphemeral(ctx, w, fmt.Sprintf("dispatching `%s`...", eventType))
+}
+
+// detach returns a context which survives the acknowledgement. it keeps the trace only.
+func detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+var _ togha.ReplyTarget = (*DispatchSlashCommandRequest)(nil)
+var _ togha.ReplyTarget = (*DispatchGitHubEventRequest)(nil)
+
+// ReplyRef is response_url of the command. the outbox posts the final result to it.
+func (req *DispatchSlashCommandRequest) ReplyRef() map[string]string {
+	return replyRef(req.responseURL)
+}
+
+// ReplyRef is response_url of interactions and view submissions. nil for Events API.
+func (req *DispatchGitHubEventRequest) ReplyRef() map[string]string {
+	return replyRef(req.responseURL)
+}
+
+func replyRef(responseURL string) map[string]string {
+	if responseURL == "" {
+		return nil
+	}
+
+	return map[string]string{"response_url": responseURL}
+}
+
+// dispatchAndRespond dispatches req after acknowledging, and posts the result to response_url of req as an ephemeral message.
+// the source message gets feedback too. a queued event gets its final result from outboxHandler later.
+func (h *slackEventHandler) dispatchAndRespond(ctx context.Context, eventType string, req togha.DispatchRequest) {
+	ctx, cancel := context.WithTimeout(ctx, responseTimeout)
+	defer cancel()
+
+	res, err := h.dsp.Dispatch(ctx, req)
+	if err != nil {
+		log.Warnf(ctx, "dispatch after acknowledgement failed: %s", err.Error())
+	}
+	h.sendFeedback(ctx, req, eventType, res, err)
+	if target, ok := req.(togha.ReplyTarget); ok {
+		if responseURL := target.ReplyRef()["response_url"]; responseURL != "" {
+			h.respond(ctx, responseURL, dispatchResultText(eventType, res, err))
+		}
+	}
+}
+
+// respond posts text to responseURL as an ephemeral message.
+func (h *slackEventHandler) respond(ctx context.Context, responseURL string, text string) {
+	err := callAPI(ctx, "response_url", func(ctx context.Context) error {
+		return slack.PostWebhookContext(ctx, responseURL, &slack.WebhookMessage{
+			ResponseType: slack.ResponseTypeEphemeral,
+			Text:         text,
+		})
+	})
+	if err != nil {
+		log.Warnf(ctx, "post to response_url failed: %s", err.Error())
+	}
+}
+
+func dispatchResultText(eventType string, res *togha.DispatchResult, err error) string {
+	switch {
+	case err != nil && (res == nil || len(res.Receivers) == 0):
+		return fmt.Sprintf("`%s` failed: %s", eventType, err.Error())
+	case res == nil:
+		return fmt.Sprintf("`%s` dispatched", eventType)
+	case res.Queued:
+		return fmt.Sprintf("`%s` queued, the result is posted later", res.EventType)
+	case len(res.Receivers) == 0:
+		return fmt.Sprintf("`%s` matched no repositories", res.EventType)
+	}
+
+	lines := []string{fmt.Sprintf("`%s` dispatched", res.EventType)}
+	for _, rr := range res.Receivers {
+		status := "ok"
+		if !rr.Succeeded() {
+			status = "failed: " + rr.Error
+		} else if rr.Duplicate {
+			status = "already dispatched"
+		}
+		lines = append(lines, fmt.Sprintf("• %s: %s", rr.Repo, status))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func writeEphemeral(ctx context.Context, w http.ResponseWriter, text string) {
+	writeJSON(ctx, w, &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         text,
+	})
+}
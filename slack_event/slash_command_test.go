@@ -0,0 +1,203 @@
+package slack_event
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/vvakame/se2gha/togha"
+)
+
+type recordingDispatcher struct {
+	reqs chan togha.DispatchRequest
+}
+
+func (dsp *recordingDispatcher) Dispatch(ctx context.Context, req togha.DispatchRequest) (*togha.DispatchResult, error) {
+	dsp.reqs <- req
+	eventType, _ := req.EventType()
+	return &togha.DispatchResult{
+		EventType: eventType,
+		Receivers: []*togha.ReceiverResult{{Repo: "vvakame/se2gha"}},
+	}, nil
+}
+
+// testTracker records background work. it refuses work if refuse is true.
+type testTracker struct {
+	refuse   bool
+	tracked  chan struct{}
+	released chan struct{}
+}
+
+func (t *testTracker) Track() (func(), bool) {
+	if t.refuse {
+		return nil, false
+	}
+	t.tracked <- struct{}{}
+
+	return func() { t.released <- struct{}{} }, true
+}
+
+func newTestTracker(refuse bool) *testTracker {
+	return &testTracker{refuse: refuse, tracked: make(chan struct{}, 1), released: make(chan struct{}, 1)}
+}
+
+func newSignedRequest(t *testing.T, path string, secret string, body string) *http.Request {
+	t.Helper()
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte("v0:" + ts + ":" + body))
+
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(hash.Sum(nil)))
+
+	return r
+}
+
+func Test_slackEventHandler_commandHandler(t *testing.T) {
+	posted := make(chan *slack.WebhookMessage, 1)
+	responseSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := &slack.WebhookMessage{}
+		if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+			t.Error(err)
+		}
+		posted <- msg
+	}))
+	defer responseSrv.Close()
+
+	dsp := &recordingDispatcher{reqs: make(chan togha.DispatchRequest, 1)}
+	h := &slackEventHandler{dsp: dsp, signingSecret: "secret"}
+
+	tests := []struct {
+		name     string
+		text     string
+		secret   string
+		status   int
+		ack      string
+		dispatch string
+	}{
+		{"dispatch", "Deploy production now", "secret", http.StatusOK, "dispatching `slack-command-deploy`...", "slack-command-deploy"},
+		{"usage", "", "secret", http.StatusOK, "usage: /gha <verb> [args]", ""},
+		{"invalid signature", "deploy", "wrong", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"command":      {"/gha"},
+				"text":         {tt.text},
+				"team_id":      {"T1"},
+				"channel_id":   {"C1"},
+				"user_id":      {"U1"},
+				"trigger_id":   {"123.456"},
+				"response_url": {responseSrv.URL},
+			}
+			w := httptest.NewRecorder()
+			h.commandHandler(w, newSignedRequest(t, "/slack/commands", tt.secret, form.Encode()))
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+			}
+			if tt.ack != "" {
+				msg := &slack.Msg{}
+				if err := json.Unmarshal(w.Body.Bytes(), msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.ResponseType != slack.ResponseTypeEphemeral || msg.Text != tt.ack {
+					t.Errorf("unexpected ack: %s", w.Body.String())
+				}
+			}
+			if tt.dispatch == "" {
+				return
+			}
+
+			req := (<-dsp.reqs).(*DispatchSlashCommandRequest)
+			if v, _ := req.EventType(); v != tt.dispatch {
+				t.Errorf("unexpected event type: %s", v)
+			}
+			if v := req.SlashCommand.Args; v != "production now" {
+				t.Errorf("unexpected args: %s", v)
+			}
+			if v := req.IdempotencyKey(); v != "slack-command:123.456" {
+				t.Errorf("unexpected key: %s", v)
+			}
+			// the outbox keeps response_url to post the final result, receivers never see it.
+			stored, err := togha.NewStoredRequest(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := stored.ReplyRef()["response_url"]; v != responseSrv.URL {
+				t.Errorf("unexpected response_url: %s", v)
+			}
+			if strings.Contains(string(stored.ClientPayload), responseSrv.URL) {
+				t.Errorf("response_url is in the payload: %s", stored.ClientPayload)
+			}
+
+			select {
+			case msg := <-posted:
+				if !strings.Contains(msg.Text, "vvakame/se2gha: ok") {
+					t.Errorf("unexpected result: %s", msg.Text)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("result is not posted")
+			}
+		})
+	}
+}
+
+func Test_slackEventHandler_commandHandler_tracked(t *testing.T) {
+	form := url.Values{
+		"command":    {"/gha"},
+		"text":       {"deploy production"},
+		"team_id":    {"T1"},
+		"channel_id": {"C1"},
+		"user_id":    {"U1"},
+		"trigger_id": {"123.456"},
+	}
+
+	t.Run("tracked", func(t *testing.T) {
+		dsp := &recordingDispatcher{reqs: make(chan togha.DispatchRequest, 1)}
+		tracker := newTestTracker(false)
+		h := &slackEventHandler{dsp: dsp, signingSecret: "secret", tracker: tracker}
+
+		w := httptest.NewRecorder()
+		h.commandHandler(w, newSignedRequest(t, "/slack/commands", "secret", form.Encode()))
+		select {
+		case <-tracker.tracked:
+		default:
+			t.Fatal("dispatch is not tracked")
+		}
+		<-dsp.reqs
+		select {
+		case <-tracker.released:
+		case <-time.After(5 * time.Second):
+			t.Fatal("dispatch is not released")
+		}
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		dsp := &recordingDispatcher{reqs: make(chan togha.DispatchRequest, 1)}
+		h := &slackEventHandler{dsp: dsp, signingSecret: "secret", tracker: newTestTracker(true)}
+
+		w := httptest.NewRecorder()
+		h.commandHandler(w, newSignedRequest(t, "/slack/commands", "secret", form.Encode()))
+		if !strings.Contains(w.Body.String(), "shutting down") {
+			t.Errorf("unexpected ack: %s", w.Body.String())
+		}
+		select {
+		case <-dsp.reqs:
+			t.Error("refused command is dispatched")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package slack_event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/source"
+	"github.com/vvakame/se2gha/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transport is how se2gha receives requests from Slack.
+type Transport string
+
+const (
+	// TransportHTTP receives requests on public HTTP endpoints signed by the signing secret.
+	TransportHTTP Transport = "http"
+	// TransportSocketMode receives requests over WebSocket connections opened by se2gha. no public URL is required.
+	TransportSocketMode Transport = "socket_mode"
+)
+
+const (
+	defaultSocketConnections = 1
+	// maxSocketConnections is the limit of Slack per app.
+	maxSocketConnections = 10
+
+	socketReconnectMinDelay = time.Second
+	socketReconnectMaxDelay = 5 * time.Minute
+
+	// socketAckTimeout is the time Slack waits for the acknowledgement before the redelivery.
+	socketAckTimeout = 3 * time.Second
+)
+
+// ParseTransport parses http or socket_mode. empty means http.
+func ParseTransport(s string) (Transport, error) {
+	switch transport := Transport(strings.ToLower(strings.TrimSpace(s))); transport {
+	case "":
+		return TransportHTTP, nil
+	case TransportHTTP, TransportSocketMode:
+		return transport, nil
+	default:
+		return TransportHTTP, fmt.Errorf("transport must be http or socket_mode: %s", s)
+	}
+}
+
+type socketModeConfig struct {
+	appToken    string
+	connections int
+}
+
+// socketModeFromSettings reads app_token and socket_connections setting, or SLACK_APP_TOKEN and SLACK_SOCKET_CONNECTIONS.
+func socketModeFromSettings(settings source.Settings) (*socketModeConfig, error) {
+	appToken := settings.Get("app_token", "SLACK_APP_TOKEN")
+	if appToken == "" {
+		return nil, errors.New("app_token setting or SLACK_APP_TOKEN environment variable is required for socket_mode")
+	}
+	if !strings.HasPrefix(appToken, "xapp-") {
+		return nil, errors.New("app_token must be an app-level token. it starts with xapp-")
+	}
+
+	connections := defaultSocketConnections
+	if v := settings.Get("socket_connections", "SLACK_SOCKET_CONNECTIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || maxSocketConnections < n {
+			return nil, fmt.Errorf("socket_connections must be 1 to %d: %s", maxSocketConnections, v)
+		}
+		connections = n
+	}
+
+	return &socketModeConfig{
+		appToken:    appToken,
+		connections: connections,
+	}, nil
+}
+
+// startSocketMode opens connections in background. they are closed when ctx is done.
+// Slack distributes requests across the connections, so requests keep flowing while one reconnects.
+func (h *slackEventHandler) startSocketMode(ctx context.Context, accessToken string, cfg *socketModeConfig) {
+	api := slack.New(accessToken, slack.OptionAppLevelToken(cfg.appToken))
+	for i := 0; i < cfg.connections; i++ {
+		go h.runSocketMode(log.With(ctx, "connection", i), api)
+	}
+}
+
+// runSocketMode keeps a connection until ctx is done. it reconnects with backoff when the connection gives up.
+func (h *slackEventHandler) runSocketMode(ctx context.Context, api *slack.Client) {
+	delay := socketReconnectMinDelay
+	for {
+		start := time.Now()
+		err := h.serveSocketMode(ctx, socketmode.New(api))
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > socketReconnectMaxDelay {
+			delay = socketReconnectMinDelay
+		}
+		log.Warnf(ctx, "slack socket mode connection stopped, reconnect in %s: %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > socketReconnectMaxDelay {
+			delay = socketReconnectMaxDelay
+		}
+	}
+}
+
+// serveSocketMode runs smc and handles its events until smc stops.
+// smc reconnects by itself on disconnect requests from Slack, and returns on errors it can not recover.
+func (h *slackEventHandler) serveSocketMode(ctx context.Context, smc *socketmode.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- smc.RunContext(ctx)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case evt := <-smc.Events:
+			switch evt.Type {
+			case socketmode.EventTypeConnecting:
+				log.Debugf(ctx, "slack socket mode connecting")
+			case socketmode.EventTypeConnected:
+				log.Info(ctx, "slack socket mode connected")
+			case socketmode.EventTypeConnectionError, socketmode.EventTypeInvalidAuth:
+				log.Warnf(ctx, "slack socket mode connection failed: %v", evt.Data)
+			case socketmode.EventTypeErrorBadMessage, socketmode.EventTypeErrorWriteFailed, socketmode.EventTypeIncomingError:
+				log.Warnf(ctx, "slack socket mode error: %+v", evt.Data)
+			case socketmode.EventTypeEventsAPI, socketmode.EventTypeSlashCommand, socketmode.EventTypeInteractive:
+				req := evt.Request
+				if req == nil {
+					continue
+				}
+				ack := func(payload json.RawMessage) {
+					if ctx.Err() != nil {
+						// acknowledgements can not be sent after the connection is closed.
+						return
+					}
+					if payload == nil {
+						smc.Ack(*req)
+						return
+					}
+					smc.Ack(*req, payload)
+				}
+				if !source.Go(h.tracker, func() { h.handleSocketRequest(ctx, ack, req) }) {
+					// shutting down. Slack redelivers it to another connection.
+					log.Warnf(ctx, "slack socket mode request is left unacknowledged: %s", req.Type)
+				}
+			default:
+				log.Debugf(ctx, "slack socket mode event: %s", evt.Type)
+			}
+		}
+	}
+}
+
+// handleSocketRequest serves req and acknowledges the envelope by ack with the response.
+// only transient errors are left unacknowledged to be redelivered.
+// Slack redelivers envelopes not acknowledged in 3 seconds, so events without the outbox may be redelivered.
+func (h *slackEventHandler) handleSocketRequest(ctx context.Context, ack func(payload json.RawMessage), req *socketmode.Request) {
+	start := time.Now()
+	ok, payload := h.serveSocketRequest(detach(ctx), req)
+	if !ok {
+		return
+	}
+	if d := time.Since(start); socketAckTimeout < d {
+		log.Warnf(ctx, "slack socket mode request is acknowledged after %s, Slack may redeliver it. use OUTBOX_DIR to acknowledge events soon: %s", d, req.EnvelopeID)
+	}
+	ack(payload)
+}
+
+// serveSocketRequest converts req to the body of HTTP request, and serves it by the handler of the HTTP transport.
+// it returns whether the envelope should be acknowledged, and the response body as the payload of the acknowledgement.
+// 4xx responses are acknowledged without payload, and 5xx responses are not acknowledged.
+func (h *slackEventHandler) serveSocketRequest(ctx context.Context, req *socketmode.Request) (bool, json.RawMessage) {
+	ctx, span := tracing.Start(ctx, "slack.socket_mode", trace.WithSpanKind(trace.SpanKindServer))
+	span.SetAttributes(attribute.String("se2gha.socket_mode.type", req.Type))
+	defer span.End()
+
+	w := &socketResponseWriter{header: make(http.Header)}
+	switch req.Type {
+	case socketmode.RequestTypeEventsAPI:
+		header := make(http.Header)
+		if req.RetryAttempt != 0 {
+			header.Set("X-Slack-Retry-Num", strconv.Itoa(req.RetryAttempt))
+			header.Set("X-Slack-Retry-Reason", req.RetryReason)
+		}
+		h.serveEvent(ctx, w, header, req.Payload)
+
+	case socketmode.RequestTypeSlashCommands:
+		body, err := slashCommandForm(req.Payload)
+		if err != nil {
+			// redeliveries never succeed.
+			log.Warnf(ctx, "invalid slash command: %s", err.Error())
+			return true, nil
+		}
+		h.serveCommand(ctx, w, body)
+
+	case socketmode.RequestTypeInteractive:
+		body := url.Values{"payload": {string(req.Payload)}}.Encode()
+		h.serveInteraction(ctx, w, []byte(body))
+
+	default:
+		log.Warnf(ctx, "unsupported socket mode request: %s", req.Type)
+		return true, nil
+	}
+
+	if w.status == 0 {
+		// same as net/http, the handler which writes nothing responds 200.
+		w.status = http.StatusOK
+	}
+	if 400 <= w.status && w.status < 500 {
+		// the request is invalid. redeliveries never succeed.
+		log.Warnf(ctx, "slack socket mode request is rejected: %d, %s", w.status, w.body.String())
+		return true, nil
+	}
+	if w.status < 200 || 300 <= w.status {
+		return false, nil
+	}
+	if w.body.Len() == 0 || !json.Valid(w.body.Bytes()) {
+		return true, nil
+	}
+
+	return true, w.body.Bytes()
+}
+
+// slashCommandForm converts the payload to the form of HTTP request. values are strings in both.
+func slashCommandForm(payload json.RawMessage) ([]byte, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+
+	form := make(url.Values, len(fields))
+	for key, v := range fields {
+		if s, ok := v.(string); ok {
+			form.Set(key, s)
+		} else if v != nil {
+			form.Set(key, fmt.Sprint(v))
+		}
+	}
+
+	return []byte(form.Encode()), nil
+}
+
+// socketResponseWriter records the response of the handler, it becomes the payload of the acknowledgement.
+type socketResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *socketResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *socketResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.body.Write(b)
+}
+
+func (w *socketResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
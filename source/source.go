@@ -0,0 +1,314 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vvakame/se2gha/health"
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/togha"
+)
+
+// Source receives events from an external service and dispatches them to GitHub.
+type Source interface {
+	// Name is an identifier of the source. e.g. slack
+	Name() string
+	// DefaultPath is a mount path prefix used when it is not configured. e.g. /slack
+	DefaultPath() string
+	// Mount registers handlers under prefix.
+	// it should return error if required credentials are missing.
+	// ctx is canceled when the handlers are replaced by reload or shut down. background connections must stop with it.
+	// background work which outlives requests should run by Go with TrackerFromContext(ctx).
+	Mount(ctx context.Context, mux *http.ServeMux, prefix string, dsp togha.EventDispatcher, settings Settings) error
+}
+
+// Tracker counts background work of sources, e.g. dispatches after acknowledgements.
+// the server waits for the work before it closes the dispatcher.
+type Tracker interface {
+	// Track returns false if the work must not start, e.g. the handlers are already drained.
+	// release must be called when the work is done.
+	Track() (release func(), ok bool)
+}
+
+type trackerKey struct{}
+
+// WithTracker returns ctx for Mount. sources take the tracker by TrackerFromContext.
+func WithTracker(ctx context.Context, t Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+// TrackerFromContext returns the tracker of ctx. nil if none.
+func TrackerFromContext(ctx context.Context) Tracker {
+	t, _ := ctx.Value(trackerKey{}).(Tracker)
+	return t
+}
+
+// Go runs f in a goroutine tracked by t. t may be nil, then f is not tracked.
+// it returns false without running f if t refuses it.
+func Go(t Tracker, f func()) bool {
+	if t == nil {
+		go f()
+		return true
+	}
+
+	release, ok := t.Track()
+	if !ok {
+		return false
+	}
+	go func() {
+		defer release()
+		f()
+	}()
+
+	return true
+}
+
+// Configurable is implemented by Source which accepts settings.
+type Configurable interface {
+	// SettingKeys returns all keys the source understands.
+	SettingKeys() []string
+}
+
+// Settings are source specific values like credentials.
+type Settings map[string]string
+
+// Get returns the value for key, or falls back to the environment variable.
+func (s Settings) Get(key, envKey string) string {
+	if v := s[key]; v != "" {
+		return v
+	}
+	if envKey == "" {
+		return ""
+	}
+
+	return os.Getenv(envKey)
+}
+
+var (
+	mu      sync.RWMutex
+	sources = make(map[string]Source)
+)
+
+// Register makes a source available. it is expected to be called from init function of the source package.
+func Register(s Source) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s == nil {
+		panic("source: Register source is nil")
+	}
+	name := s.Name()
+	if _, dup := sources[name]; dup {
+		panic("source: Register called twice for source " + name)
+	}
+	sources[name] = s
+}
+
+// Sources returns registered sources sorted by name.
+func Sources() []Source {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]Source, 0, len(sources))
+	for _, s := range sources {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+
+	return list
+}
+
+// Lookup returns the registered source.
+func Lookup(name string) (Source, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	s, ok := sources[name]
+	return s, ok
+}
+
+type Config struct {
+	// Enabled lists source names to mount. all registered sources are mounted if nil.
+	Enabled []string
+	// Paths overrides mount path prefix per source name.
+	Paths map[string]string
+	// Settings are passed to each source. sources fall back to environment variables.
+	Settings map[string]Settings
+}
+
+// ConfigFromEnv reads SOURCES and SOURCE_${NAME}_PATH environment variables.
+func ConfigFromEnv() *Config {
+	cfg := &Config{
+		Paths: make(map[string]string),
+	}
+	if v := strings.TrimSpace(os.Getenv("SOURCES")); v != "" {
+		cfg.Enabled = []string{}
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			cfg.Enabled = append(cfg.Enabled, name)
+		}
+	}
+	for _, s := range Sources() {
+		if v := os.Getenv(fmt.Sprintf("SOURCE_%s_PATH", strings.ToUpper(s.Name()))); v != "" {
+			cfg.Paths[s.Name()] = v
+		}
+	}
+
+	return cfg
+}
+
+// MountAll mounts enabled sources.
+// a source which fails to mount, e.g. by missing credentials, is skipped and does not affect other sources.
+// it returns error if no source is mounted.
+func MountAll(ctx context.Context, mux *http.ServeMux, dsp togha.EventDispatcher, cfg *Config) ([]string, error) {
+	if cfg == nil {
+		cfg = ConfigFromEnv()
+	}
+
+	var enabled []Source
+	if cfg.Enabled == nil {
+		enabled = Sources()
+	} else {
+		for _, name := range cfg.Enabled {
+			s, ok := Lookup(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown source: %s", name)
+			}
+			enabled = append(enabled, s)
+		}
+	}
+
+	var mounted []string
+	for _, s := range enabled {
+		prefix := s.DefaultPath()
+		if v, ok := cfg.Paths[s.Name()]; ok {
+			prefix = v
+		}
+		prefix = "/" + strings.Trim(prefix, "/")
+		if prefix == "/" {
+			prefix = ""
+		}
+
+		err := s.Mount(ctx, mux, prefix, dsp, cfg.Settings[s.Name()])
+		if err != nil {
+			log.Warnf(ctx, "source %s is disabled: %s", s.Name(), err.Error())
+			continue
+		}
+		log.Infof(ctx, "source %s is mounted on %s/", s.Name(), prefix)
+		mounted = append(mounted, s.Name())
+	}
+
+	if len(mounted) == 0 {
+		return nil, errors.New("no source is available")
+	}
+
+	return mounted, nil
+}
+
+// HealthChecker is an optional interface of Source which reports readiness of its upstream.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context, settings Settings) []*health.Check
+}
+
+// Checker returns health.Checker of mounted sources. names are returned by MountAll.
+func Checker(names []string, cfg *Config) health.Checker {
+	if cfg == nil {
+		cfg = ConfigFromEnv()
+	}
+
+	return health.CheckerFunc(func(ctx context.Context) []*health.Check {
+		var checks []*health.Check
+		for _, name := range names {
+			s, ok := Lookup(name)
+			if !ok {
+				continue
+			}
+			if hc, ok := s.(HealthChecker); ok {
+				checks = append(checks, hc.CheckHealth(ctx, cfg.Settings[name])...)
+			}
+		}
+
+		return checks
+	})
+}
+
+// CallbackResponderProvider is an optional interface of Source which acts on callbacks from workflows.
+type CallbackResponderProvider interface {
+	// CallbackResponder returns nil if the settings lack credentials for callbacks.
+	CallbackResponder(settings Settings) (togha.CallbackResponder, error)
+}
+
+// CallbackResponders returns togha.CallbackResponder of mounted sources by name. names are returned by MountAll.
+func CallbackResponders(names []string, cfg *Config) (map[string]togha.CallbackResponder, error) {
+	if cfg == nil {
+		cfg = ConfigFromEnv()
+	}
+
+	responders := make(map[string]togha.CallbackResponder)
+	for _, name := range names {
+		s, ok := Lookup(name)
+		if !ok {
+			continue
+		}
+		p, ok := s.(CallbackResponderProvider)
+		if !ok {
+			continue
+		}
+		responder, err := p.CallbackResponder(cfg.Settings[name])
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		if responder != nil {
+			responders[name] = responder
+		}
+	}
+
+	return responders, nil
+}
+
+// ResolverProvider is an optional interface of Source which defers its API calls to the outbox worker.
+// the source calls togha.Deferrer.Defer if the dispatcher implements it, and the worker builds the event by the resolver.
+// the resolver may implement togha.DeliveryObserver, then it is told final results of queued events of the source.
+type ResolverProvider interface {
+	// Resolver returns nil if the settings lack credentials for the API.
+	Resolver(settings Settings) (togha.Resolver, error)
+}
+
+// Resolvers returns togha.Resolver of mounted sources by name. names are returned by MountAll.
+func Resolvers(names []string, cfg *Config) (map[string]togha.Resolver, error) {
+	if cfg == nil {
+		cfg = ConfigFromEnv()
+	}
+
+	resolvers := make(map[string]togha.Resolver)
+	for _, name := range names {
+		s, ok := Lookup(name)
+		if !ok {
+			continue
+		}
+		p, ok := s.(ResolverProvider)
+		if !ok {
+			continue
+		}
+		resolver, err := p.Resolver(cfg.Settings[name])
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		if resolver != nil {
+			resolvers[name] = resolver
+		}
+	}
+
+	return resolvers, nil
+}
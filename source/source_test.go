@@ -0,0 +1,119 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vvakame/se2gha/togha"
+)
+
+type testSource struct {
+	name string
+	err  error
+}
+
+func (s *testSource) Name() string {
+	return s.name
+}
+
+func (s *testSource) DefaultPath() string {
+	return "/" + s.name
+}
+
+func (s *testSource) Mount(ctx context.Context, mux *http.ServeMux, prefix string, dsp togha.EventDispatcher, settings Settings) error {
+	if s.err != nil {
+		return s.err
+	}
+	mux.HandleFunc(prefix+"/events/action", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return nil
+}
+
+func TestMountAll(t *testing.T) {
+	Register(&testSource{name: "ok"})
+	Register(&testSource{name: "nocred", err: errors.New("TOKEN environment variable is required")})
+
+	ctx := context.Background()
+
+	t.Run("missing credentials fail only the source", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mounted, err := MountAll(ctx, mux, nil, &Config{
+			Paths: map[string]string{"ok": "/custom/"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mounted) != 1 || mounted[0] != "ok" {
+			t.Errorf("unexpected mounted: %v", mounted)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/custom/events/action", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status: %d", w.Code)
+		}
+	})
+
+	t.Run("no source available", func(t *testing.T) {
+		_, err := MountAll(ctx, http.NewServeMux(), nil, &Config{Enabled: []string{"nocred"}})
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		_, err := MountAll(ctx, http.NewServeMux(), nil, &Config{Enabled: []string{"unknown"}})
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+type testTracker struct {
+	inflight int
+	refuse   bool
+}
+
+func (t *testTracker) Track() (func(), bool) {
+	if t.refuse {
+		return nil, false
+	}
+	t.inflight++
+
+	return func() { t.inflight-- }, true
+}
+
+func TestGo(t *testing.T) {
+	tracker := &testTracker{}
+	ctx := WithTracker(context.Background(), tracker)
+
+	done := make(chan struct{})
+	started := make(chan struct{})
+	if !Go(TrackerFromContext(ctx), func() {
+		close(started)
+		<-done
+	}) {
+		t.Fatal("work is refused")
+	}
+	<-started
+	if tracker.inflight != 1 {
+		t.Errorf("unexpected inflight: %d", tracker.inflight)
+	}
+	close(done)
+
+	tracker.refuse = true
+	if Go(tracker, func() { t.Error("refused work must not run") }) {
+		t.Error("work should be refused")
+	}
+
+	ran := make(chan struct{})
+	if !Go(nil, func() { close(ran) }) {
+		t.Error("work without tracker should run")
+	}
+	<-ran
+}
@@ -0,0 +1,331 @@
+package togha
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultCallbackTTL is how long callback tokens are valid. workflows may wait in queue for a while.
+const DefaultCallbackTTL = 24 * time.Hour
+
+// minCallbackSecretLength is the length of HMAC-SHA256 key.
+const minCallbackSecretLength = 32
+
+// maxCallbackBodySize bounds the callback request body.
+const maxCallbackBodySize = 64 * 1024
+
+var (
+	// ErrInvalidCallbackToken is returned for tokens which are malformed, tampered or expired.
+	ErrInvalidCallbackToken = errors.New("invalid callback token")
+	// ErrUnsupportedCallback is returned by CallbackResponder which does not support the action.
+	ErrUnsupportedCallback = errors.New("unsupported callback action")
+	// ErrInvalidCallbackRequest is returned by CallbackResponder if the request lacks required fields.
+	ErrInvalidCallbackRequest = errors.New("invalid callback request")
+)
+
+// CallbackTarget is implemented by DispatchRequest which workflows can report back to.
+type CallbackTarget interface {
+	// CallbackRef locates the source. e.g. channel and ts for slack. nil if the event can not be reported back.
+	CallbackRef() map[string]string
+}
+
+// CallbackClaims are signed into a callback token. they can not be changed by workflows.
+type CallbackClaims struct {
+	// DeliveryID identifies the dispatch. it is also in client_payload.se2gha.delivery_id .
+	DeliveryID string            `json:"did"`
+	Source     string            `json:"src"`
+	Receiver   string            `json:"rcv"`
+	EventType  string            `json:"evt"`
+	Ref        map[string]string `json:"ref"`
+	ExpiresAt  int64             `json:"exp"`
+}
+
+// CallbackSigner issues and verifies callback tokens.
+// a token is "<base64url claims>.<base64url HMAC-SHA256 of claims>".
+type CallbackSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewCallbackSigner requires secret of 32 bytes or more. DefaultCallbackTTL is used if ttl is zero.
+func NewCallbackSigner(secret string, ttl time.Duration) (*CallbackSigner, error) {
+	if len(secret) < minCallbackSecretLength {
+		return nil, fmt.Errorf("callback secret must be %d bytes or more", minCallbackSecretLength)
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("callback ttl must be positive: %s", ttl)
+	}
+	if ttl == 0 {
+		ttl = DefaultCallbackTTL
+	}
+
+	return &CallbackSigner{
+		secret: []byte(secret),
+		ttl:    ttl,
+		now:    time.Now,
+	}, nil
+}
+
+// Sign returns a token of claims. ExpiresAt is set by the signer.
+func (s *CallbackSigner) Sign(claims *CallbackClaims) (string, error) {
+	claims.ExpiresAt = s.now().Add(s.ttl).Unix()
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload)), nil
+}
+
+// Verify returns claims of token. it returns ErrInvalidCallbackToken if the token is tampered or expired.
+func (s *CallbackSigner) Verify(token string) (*CallbackClaims, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCallbackToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(payload)) {
+		return nil, ErrInvalidCallbackToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCallbackToken
+	}
+	claims := &CallbackClaims{}
+	if err := json.Unmarshal(b, claims); err != nil {
+		return nil, ErrInvalidCallbackToken
+	}
+	if s.now().Unix() > claims.ExpiresAt {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidCallbackToken)
+	}
+
+	return claims, nil
+}
+
+func (s *CallbackSigner) mac(payload string) []byte {
+	hash := hmac.New(sha256.New, s.secret)
+	hash.Write([]byte(payload))
+
+	return hash.Sum(nil)
+}
+
+// CallbackConfig enables callback tokens in client_payload.
+type CallbackConfig struct {
+	Signer *CallbackSigner
+	// URL is the callback endpoint which workflows call. e.g. https://se2gha.example.com/callback
+	URL string
+}
+
+// CallbackConfigFromEnv reads CALLBACK_SECRET, CALLBACK_URL and CALLBACK_TTL. returns nil if CALLBACK_SECRET is empty.
+func CallbackConfigFromEnv() (*CallbackConfig, error) {
+	secret := os.Getenv("CALLBACK_SECRET")
+	if secret == "" {
+		return nil, nil
+	}
+
+	var ttl time.Duration
+	if v := os.Getenv("CALLBACK_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CALLBACK_TTL: %w", err)
+		}
+		ttl = d
+	}
+	signer, err := NewCallbackSigner(secret, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("CALLBACK_SECRET: %w", err)
+	}
+	callbackURL := os.Getenv("CALLBACK_URL")
+	if callbackURL == "" {
+		return nil, errors.New("CALLBACK_URL is required with CALLBACK_SECRET")
+	}
+
+	return &CallbackConfig{
+		Signer: signer,
+		URL:    callbackURL,
+	}, nil
+}
+
+// newDeliveryID returns a random ID of a dispatch.
+func newDeliveryID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// delivery is a callback reference of one dispatch. nil if callbacks are disabled or the event has no source to report back.
+type delivery struct {
+	id     string
+	source string
+	ref    map[string]string
+}
+
+func (dsp *dispatchCommon) newDelivery(ctx context.Context, req DispatchRequest) *delivery {
+	if dsp.callback == nil {
+		return nil
+	}
+	target, ok := req.(CallbackTarget)
+	if !ok {
+		return nil
+	}
+	ref := target.CallbackRef()
+	if len(ref) == 0 {
+		return nil
+	}
+	id, err := newDeliveryID()
+	if err != nil {
+		log.Warnf(ctx, "delivery id generation failed: %s", err.Error())
+		return nil
+	}
+
+	dl := &delivery{id: id, ref: ref}
+	if sd, ok := req.(SourceDescriber); ok {
+		dl.source = sd.Source()
+	}
+
+	return dl
+}
+
+// addCallback adds a token for the receiver to md. the token is signed per receiver.
+func (dsp *dispatchCommon) addCallback(ctx context.Context, md *Metadata, dl *delivery, receiver string, eventType string) {
+	if dl == nil {
+		return
+	}
+
+	token, err := dsp.callback.Signer.Sign(&CallbackClaims{
+		DeliveryID: dl.id,
+		Source:     dl.source,
+		Receiver:   receiver,
+		EventType:  eventType,
+		Ref:        dl.ref,
+	})
+	if err != nil {
+		log.Warnf(ctx, "callback token signing failed: %s", err.Error())
+		return
+	}
+	md.DeliveryID = dl.id
+	md.CallbackURL = dsp.callback.URL
+	md.CallbackToken = token
+}
+
+// CallbackRequest is posted by workflows with the callback token.
+type CallbackRequest struct {
+	// Action is reply, update or react for slack, comment for kintone. reply is accepted by all sources.
+	Action string `json:"action"`
+	Text   string `json:"text,omitempty"`
+	// Reaction is an emoji name for react. e.g. white_check_mark
+	Reaction string `json:"reaction,omitempty"`
+	// TS is a message to update. the source message is updated if empty. sources may limit it to messages of the delivery.
+	TS string `json:"ts,omitempty"`
+}
+
+// CallbackResult is returned to workflows.
+type CallbackResult struct {
+	DeliveryID string `json:"delivery_id"`
+	// ID is the posted item. e.g. ts of the reply for slack, comment ID for kintone.
+	ID string `json:"id,omitempty"`
+}
+
+// CallbackResponder acts on the source of claims.
+type CallbackResponder interface {
+	Respond(ctx context.Context, claims *CallbackClaims, req *CallbackRequest) (*CallbackResult, error)
+}
+
+// CallbackHandler serves "POST" with "Authorization: Bearer <token>" and CallbackRequest as JSON.
+// responders are keyed by source name.
+func CallbackHandler(signer *CallbackSigner, responders map[string]CallbackResponder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, err := signer.Verify(token)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(err.Error()))
+			log.Warnf(ctx, "callback rejected: %s", err.Error())
+			return
+		}
+
+		b, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBodySize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		defer r.Body.Close()
+		req := &CallbackRequest{}
+		if err := json.Unmarshal(b, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		responder, ok := responders[claims.Source]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(fmt.Sprintf("source %s does not accept callbacks", claims.Source)))
+			return
+		}
+
+		ctx, span := tracing.Start(ctx, "callback.respond")
+		span.SetAttributes(
+			attribute.String("se2gha.delivery_id", claims.DeliveryID),
+			attribute.String("se2gha.source", claims.Source),
+			attribute.String("se2gha.receiver", claims.Receiver),
+			attribute.String("se2gha.callback_action", req.Action),
+		)
+		res, err := responder.Respond(ctx, claims, req)
+		tracing.End(span, err)
+		if errors.Is(err, ErrUnsupportedCallback) || errors.Is(err, ErrInvalidCallbackRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(err.Error()))
+			log.Warnf(ctx, "callback %s from %s failed: %s", req.Action, claims.Receiver, err.Error())
+			return
+		}
+		log.Info(ctx, "callback handled", "delivery_id", claims.DeliveryID, "source", claims.Source, "receiver", claims.Receiver, "action", req.Action)
+
+		if res == nil {
+			res = &CallbackResult{}
+		}
+		res.DeliveryID = claims.DeliveryID
+		b, err = json.Marshal(res)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+	})
+}
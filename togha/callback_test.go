@@ -0,0 +1,254 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testCallbackSecret = "0123456789abcdef0123456789abcdef"
+
+type testCallbackRequest struct {
+	testSourceRequest
+	ref map[string]string
+}
+
+func (req *testCallbackRequest) CallbackRef() map[string]string {
+	return req.ref
+}
+
+type testCallbackResponder struct {
+	claims *CallbackClaims
+	req    *CallbackRequest
+	err    error
+}
+
+func (r *testCallbackResponder) Respond(ctx context.Context, claims *CallbackClaims, req *CallbackRequest) (*CallbackResult, error) {
+	r.claims = claims
+	r.req = req
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return &CallbackResult{ID: "1600000000.000300"}, nil
+}
+
+func TestNewCallbackSigner(t *testing.T) {
+	if _, err := NewCallbackSigner("short", 0); err == nil {
+		t.Error("short secret must be rejected")
+	}
+	if _, err := NewCallbackSigner(testCallbackSecret, -time.Second); err == nil {
+		t.Error("negative ttl must be rejected")
+	}
+	signer, err := NewCallbackSigner(testCallbackSecret, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.ttl != DefaultCallbackTTL {
+		t.Errorf("unexpected ttl: %s", signer.ttl)
+	}
+}
+
+func TestCallbackSigner_Verify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	signer, err := NewCallbackSigner(testCallbackSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.now = func() time.Time { return now }
+
+	token, err := signer.Sign(&CallbackClaims{
+		DeliveryID: "0011223344556677",
+		Source:     "slack",
+		Receiver:   "vvakame/se2gha",
+		EventType:  "slack-event-reaction_added-create-issue",
+		Ref:        map[string]string{"channel": "C1", "ts": "1600000000.000200"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCallbackSigner(strings.Repeat("x", 32), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	tampered := strings.Replace(payload, payload[:4], "AAAA", 1) + "." + signature
+
+	tests := []struct {
+		name    string
+		signer  *CallbackSigner
+		token   string
+		elapsed time.Duration
+		wantErr bool
+	}{
+		{"valid", signer, token, 0, false},
+		{"before expiry", signer, token, time.Hour, false},
+		{"expired", signer, token, time.Hour + time.Second, true},
+		{"tampered", signer, tampered, 0, true},
+		{"other secret", other, token, 0, true},
+		{"no signature", signer, payload, 0, true},
+		{"empty", signer, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.signer.now = func() time.Time { return now.Add(tt.elapsed) }
+
+			claims, err := tt.signer.Verify(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCallbackToken) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Receiver != "vvakame/se2gha" || claims.Ref["ts"] != "1600000000.000200" {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestCallbackHandler(t *testing.T) {
+	signer, err := NewCallbackSigner(testCallbackSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(source string) string {
+		token, err := signer.Sign(&CallbackClaims{DeliveryID: "0011223344556677", Source: source, Receiver: "vvakame/se2gha"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		err    error
+		want   int
+	}{
+		{"ok", http.MethodPost, sign("slack"), `{"action":"reply","text":"done"}`, nil, http.StatusOK},
+		{"get", http.MethodGet, sign("slack"), ``, nil, http.StatusMethodNotAllowed},
+		{"no token", http.MethodPost, "", `{"action":"reply","text":"done"}`, nil, http.StatusUnauthorized},
+		{"invalid token", http.MethodPost, "invalid", `{"action":"reply","text":"done"}`, nil, http.StatusUnauthorized},
+		{"invalid body", http.MethodPost, sign("slack"), `{`, nil, http.StatusBadRequest},
+		{"unknown source", http.MethodPost, sign("kintone"), `{"action":"comment","text":"done"}`, nil, http.StatusNotFound},
+		{"unsupported", http.MethodPost, sign("slack"), `{"action":"delete"}`, ErrUnsupportedCallback, http.StatusBadRequest},
+		{"source failure", http.MethodPost, sign("slack"), `{"action":"reply","text":"done"}`, errors.New("channel_not_found"), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responder := &testCallbackResponder{err: tt.err}
+			h := CallbackHandler(signer, map[string]CallbackResponder{"slack": responder})
+
+			r := httptest.NewRequest(tt.method, "/callback", strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("unexpected status: %d, %s", w.Code, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			if responder.req.Text != "done" || responder.claims.Receiver != "vvakame/se2gha" {
+				t.Errorf("unexpected request: %+v, %+v", responder.req, responder.claims)
+			}
+			res := &CallbackResult{}
+			if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+				t.Fatal(err)
+			}
+			if res.DeliveryID != "0011223344556677" || res.ID != "1600000000.000300" {
+				t.Errorf("unexpected result: %+v", res)
+			}
+		})
+	}
+}
+
+func Test_gitHubEventDispatcher_Dispatch_callback(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	payloads := make(map[string]*Metadata)
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		var body struct {
+			ClientPayload map[string]json.RawMessage `json:"client_payload"`
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Error(err)
+		}
+		md := &Metadata{}
+		if v, ok := body.ClientPayload[MetadataKey]; ok {
+			if err := json.Unmarshal(v, md); err != nil {
+				t.Error(err)
+			}
+		}
+		mu.Lock()
+		payloads[r.URL.Path] = md
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	signer, err := NewCallbackSigner(testCallbackSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient: client,
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha"},
+			{Owner: "vvakame", Name: "other"},
+		},
+		Callback: &CallbackConfig{Signer: signer, URL: "https://se2gha.example.com/callback"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &testCallbackRequest{
+		testSourceRequest: testSourceRequest{
+			testDispatchRequest: testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{}`)},
+			source:              "slack",
+		},
+		ref: map[string]string{"channel": "C1", "ts": "1600000000.000200"},
+	}
+	if _, err := dsp.Dispatch(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	md := payloads["/repos/vvakame/se2gha/dispatches"]
+	if md == nil || md.CallbackURL != "https://se2gha.example.com/callback" || md.DeliveryID == "" {
+		t.Fatalf("unexpected metadata: %+v", md)
+	}
+	claims, err := signer.Verify(md.CallbackToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.DeliveryID != md.DeliveryID || claims.Source != "slack" || claims.Receiver != "vvakame/se2gha" || claims.EventType != "test-event" || claims.Ref["channel"] != "C1" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	other := payloads["/repos/vvakame/other/dispatches"]
+	if other == nil || other.DeliveryID != md.DeliveryID || other.CallbackToken == md.CallbackToken {
+		t.Errorf("tokens must be signed per receiver with the same delivery: %+v", other)
+	}
+}
@@ -0,0 +1,228 @@
+package togha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vvakame/se2gha/log"
+)
+
+// SourceBodyer provides the original request body from the event source.
+// it is kept in dead letters for investigation.
+type SourceBodyer interface {
+	SourceBody() []byte
+}
+
+// DeadLetter is an event which could not be delivered to some receivers.
+type DeadLetter struct {
+	ID       string         `json:"id"`
+	FailedAt time.Time      `json:"failed_at"`
+	Request  *StoredRequest `json:"request"`
+	// Receivers did not get the event. it is empty if the event was rejected before routing.
+	Receivers []string          `json:"receivers,omitempty"`
+	Failures  []*ReceiverResult `json:"failures,omitempty"`
+	Error     string            `json:"error"`
+	Replays   int               `json:"replays,omitempty"`
+}
+
+// DeadLetterStore persists dead letters as files in a directory.
+type DeadLetterStore struct {
+	spool *fileSpool
+}
+
+func NewDeadLetterStore(dir string) (*DeadLetterStore, error) {
+	spool, err := newFileSpool(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterStore{spool: spool}, nil
+}
+
+// Add saves req with failed receivers in res.
+func (s *DeadLetterStore) Add(req DispatchRequest, res *DispatchResult, err error) (*DeadLetter, error) {
+	stored, sErr := NewStoredRequest(req)
+	if sErr != nil {
+		return nil, sErr
+	}
+	// outbox retry states are meaningless for dead letters.
+	stored.Attempts = 0
+	stored.NextAttemptAt = time.Time{}
+	stored.LastError = ""
+	stored.DeliveredTo = nil
+
+	now := time.Now()
+	id, sErr := newSpoolID(now)
+	if sErr != nil {
+		return nil, sErr
+	}
+	dl := &DeadLetter{
+		ID:       id,
+		FailedAt: now,
+		Request:  stored,
+	}
+	dl.setFailures(res, err)
+
+	if err := s.spool.Put(dl.ID, dl); err != nil {
+		return nil, err
+	}
+
+	return dl, nil
+}
+
+// save is Add which only logs errors. s may be nil.
+func (s *DeadLetterStore) save(ctx context.Context, req DispatchRequest, res *DispatchResult, err error) {
+	if s == nil {
+		return
+	}
+
+	dl, sErr := s.Add(req, res, err)
+	if sErr != nil {
+		log.Errorf(ctx, "dead letter save failed: %s", sErr.Error())
+		return
+	}
+	log.Infof(ctx, "dead letter saved: %s, %s", dl.ID, dl.Request.Type)
+}
+
+func (dl *DeadLetter) setFailures(res *DispatchResult, err error) {
+	dl.Receivers = nil
+	dl.Failures = res.Failed()
+	for _, rr := range dl.Failures {
+		dl.Receivers = append(dl.Receivers, rr.Repo)
+	}
+	if err != nil {
+		dl.Error = err.Error()
+	}
+}
+
+// Len returns the number of dead letters.
+func (s *DeadLetterStore) Len() (int, error) {
+	ids, err := s.spool.List()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// List returns dead letters in order of failure.
+func (s *DeadLetterStore) List() ([]*DeadLetter, error) {
+	ids, err := s.spool.List()
+	if err != nil {
+		return nil, err
+	}
+
+	dls := make([]*DeadLetter, 0, len(ids))
+	for _, id := range ids {
+		dl, err := s.Get(id)
+		if errors.Is(err, os.ErrNotExist) {
+			// removed concurrently.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		dls = append(dls, dl)
+	}
+
+	return dls, nil
+}
+
+// Get returns the dead letter. the error satisfies errors.Is(err, os.ErrNotExist) if not found.
+func (s *DeadLetterStore) Get(id string) (*DeadLetter, error) {
+	dl := &DeadLetter{}
+	if err := s.spool.Get(id, dl); err != nil {
+		return nil, err
+	}
+
+	return dl, nil
+}
+
+func (s *DeadLetterStore) Remove(id string) error {
+	return s.spool.Remove(id)
+}
+
+// Purge removes all dead letters and returns how many are removed.
+func (s *DeadLetterStore) Purge() (int, error) {
+	ids, err := s.spool.List()
+	if err != nil {
+		return 0, err
+	}
+
+	for idx, id := range ids {
+		if err := s.spool.Remove(id); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return idx, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+// Replay dispatches the dead letter again to failed receivers, or to receivers in to if specified.
+// receivers in to are "owner/name" format, same as GHA_REPOS.
+// the dead letter is removed on success, and updated with new failures otherwise.
+func (s *DeadLetterStore) Replay(ctx context.Context, id string, dsp EventDispatcher, to []string) (*DispatchResult, error) {
+	dl, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if dl.Request.Unresolved {
+		// the source failed to build the event. only its source body is kept.
+		return nil, fmt.Errorf("dead letter %s has no event to replay", id)
+	}
+
+	targets := to
+	if len(targets) == 0 {
+		targets = dl.Receivers
+	}
+	res, err := dsp.Dispatch(ctx, &replayRequest{StoredRequest: dl.Request, targets: targets})
+	if err == nil {
+		if err := s.Remove(id); err != nil {
+			return res, err
+		}
+		return res, nil
+	}
+
+	dl.Replays++
+	dl.FailedAt = time.Now()
+	if len(to) == 0 {
+		dl.setFailures(res, err)
+	} else {
+		dl.Error = err.Error()
+	}
+	if err := s.spool.Put(dl.ID, dl); err != nil {
+		log.Warnf(ctx, "dead letter update failed: %s, %s", dl.ID, err.Error())
+	}
+
+	return res, err
+}
+
+type replayRequest struct {
+	*StoredRequest
+	targets []string
+}
+
+func (req *replayRequest) targetReceivers() []string {
+	return req.targets
+}
+
+// NewDeadLetterEventDispatcher saves events which dsp failed to deliver into store.
+func NewDeadLetterEventDispatcher(dsp EventDispatcher, store *DeadLetterStore) EventDispatcher {
+	return &deadLetterEventDispatcher{dsp: dsp, store: store}
+}
+
+type deadLetterEventDispatcher struct {
+	dsp   EventDispatcher
+	store *DeadLetterStore
+}
+
+func (dsp *deadLetterEventDispatcher) Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
+	res, err := dsp.dsp.Dispatch(ctx, req)
+	if err != nil && (res == nil || !res.Queued) {
+		dsp.store.save(ctx, req, res, err)
+	}
+
+	return res, err
+}
@@ -0,0 +1,172 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testBodyRequest struct {
+	testDispatchRequest
+	body string
+}
+
+func (req *testBodyRequest) SourceBody() []byte {
+	return []byte(req.body)
+}
+
+func TestDeadLetterStore_replay(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewDeadLetterStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	down := map[string]bool{"/repos/vvakame/missing/dispatches": true}
+	var received []string
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down[r.URL.Path] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		received = append(received, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	inner, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient: client,
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha"},
+			{Owner: "vvakame", Name: "missing"},
+		},
+		Retry: &RetryConfig{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsp := NewDeadLetterEventDispatcher(inner, store)
+
+	_, err = dsp.Dispatch(ctx, &testBodyRequest{
+		testDispatchRequest: testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{"a":1}`)},
+		body:                `{"original":true}`,
+	})
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	dls, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 {
+		t.Fatalf("unexpected dead letters: %d", len(dls))
+	}
+	dl := dls[0]
+	if v := strings.Join(dl.Receivers, ","); v != "vvakame/missing" {
+		t.Errorf("unexpected receivers: %s", v)
+	}
+	if v := dl.Request.Body; v != `{"original":true}` {
+		t.Errorf("unexpected body: %s", v)
+	}
+	if v := string(dl.Request.ClientPayload); v != `{"a":1}` {
+		t.Errorf("unexpected payload: %s", v)
+	}
+	if !strings.Contains(dl.Error, "vvakame/missing") {
+		t.Errorf("unexpected error: %s", dl.Error)
+	}
+
+	// still failing. the dead letter is kept with replay count.
+	_, err = store.Replay(ctx, dl.ID, inner, nil)
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	dl, err = store.Get(dl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Replays != 1 {
+		t.Errorf("unexpected replays: %d", dl.Replays)
+	}
+
+	// GitHub Enterprise Server receivers are not guessed by the name.
+	received = nil
+	_, err = store.Replay(ctx, dl.ID, inner, []string{"github.example.com/vvakame/other"})
+	if err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(received) != 0 {
+		t.Errorf("unexpected received: %v", received)
+	}
+
+	// replay to another receiver which is not configured.
+	_, err = store.Replay(ctx, dl.ID, inner, []string{"vvakame/other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.Join(received, ","); v != "/repos/vvakame/other/dispatches" {
+		t.Errorf("unexpected received: %s", v)
+	}
+	_, err = store.Get(dl.ID)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("dead letter should be removed: %v", err)
+	}
+}
+
+func TestOutboxEventDispatcher_deadLetter(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewDeadLetterStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:          t.TempDir(),
+		Dispatcher:   &recordingDispatcher{err: errors.New("github is down")},
+		PollInterval: 10 * time.Millisecond,
+		MaxAttempts:  1,
+		DeadLetters:  store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+
+	_, err = outbox.Dispatch(ctx, &testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		dls, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dls) == 1 {
+			if v := dls[0].Error; v != "github is down" {
+				t.Errorf("unexpected error: %s", v)
+			}
+			break
+		}
+		if i > 100 {
+			t.Fatal("dead letter is not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	n, err := store.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("unexpected purged: %d", n)
+	}
+}
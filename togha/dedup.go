@@ -0,0 +1,104 @@
+package togha
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyer is implemented by DispatchRequest which has a unique ID per logical event.
+// redeliveries of the same event must return the same key.
+type IdempotencyKeyer interface {
+	IdempotencyKey() string
+}
+
+// DeliveredReceivers is implemented by DispatchRequest which remembers receivers that already got the event.
+// they are skipped even without DedupStore, e.g. after restart.
+type DeliveredReceivers interface {
+	// Delivered reports whether receiver in "owner/name" format, same as ReceiverResult.Repo, got the event.
+	Delivered(receiver string) bool
+}
+
+// DedupStore remembers dispatched keys for a while.
+type DedupStore interface {
+	// Reserve marks key as in-flight or done. it returns false if key is already reserved.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Release removes the mark so the key can be dispatched again.
+	Release(ctx context.Context, key string) error
+}
+
+// DefaultDedupTTL covers Slack retries (up to about 1 hour) with margin.
+const DefaultDedupTTL = 24 * time.Hour
+
+// dedupTTLFromEnv reads GHA_DEDUP_TTL. "0" disables deduplication.
+func dedupTTLFromEnv() (time.Duration, error) {
+	ttl := DefaultDedupTTL
+	if v := os.Getenv("GHA_DEDUP_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid GHA_DEDUP_TTL: %w", err)
+		}
+		ttl = d
+	}
+
+	return ttl, nil
+}
+
+// MemoryDedupStore is a DedupStore in process memory.
+type MemoryDedupStore struct {
+	mu        sync.Mutex
+	expiresAt map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{
+		expiresAt: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+func (s *MemoryDedupStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if expiresAt, ok := s.expiresAt[key]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	s.expiresAt[key] = now.Add(ttl)
+
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.expiresAt, key)
+
+	return nil
+}
+
+// sweep drops expired keys at most once per minute.
+func (s *MemoryDedupStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, expiresAt := range s.expiresAt {
+		if !now.Before(expiresAt) {
+			delete(s.expiresAt, key)
+		}
+	}
+}
+
+func dedupKey(idempotencyKey string, receiver *ReceiverRepo) string {
+	return idempotencyKey + "|" + receiver.String()
+}
@@ -0,0 +1,206 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vvakame/se2gha/log"
+)
+
+type DryRunConfig struct {
+	ReceiverRepos []*ReceiverRepo
+	RoutingRules  []*RoutingRule
+	Normalize     *NormalizeConfig
+	// Dedup, DedupTTL and DisableDedup are same as EventDispatcherConfig. pass a store which production does not use.
+	Dedup        DedupStore
+	DedupTTL     time.Duration
+	DisableDedup bool
+	// Callback adds callback tokens like production. the token is redacted in records.
+	Callback        *CallbackConfig
+	DisableCallback bool
+
+	// RecordFile appends each would-be dispatch as JSON line if specified.
+	RecordFile string
+	// MaxRecords is the number of recent records served by ServeHTTP. default is 100.
+	MaxRecords int
+}
+
+// DryRunRecord is a dispatch which would have been sent to GitHub.
+type DryRunRecord struct {
+	Time      time.Time              `json:"time"`
+	Receiver  string                 `json:"receiver"`
+	Target    TargetKind             `json:"target"`
+	EventType string                 `json:"event_type"`
+	Payload   json.RawMessage        `json:"client_payload,omitempty"`
+	Inputs    map[string]interface{} `json:"inputs,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// dryRunCallbackToken replaces callback tokens in records. a token is a credential for the callback endpoint.
+const dryRunCallbackToken = "redacted"
+
+// DryRunEventDispatcher never calls GitHub.
+// it logs would-be events and keeps recent ones for debugging.
+// records have the same client_payload as production except the callback token.
+type DryRunEventDispatcher struct {
+	dispatchCommon
+	maxRecords int
+
+	mu      sync.Mutex
+	file    *os.File
+	records []*DryRunRecord
+}
+
+func NewDryRunEventDispatcher(ctx context.Context, cfg *DryRunConfig) (*DryRunEventDispatcher, error) {
+	if cfg == nil {
+		cfg = &DryRunConfig{}
+	}
+	if cfg.RecordFile == "" {
+		cfg.RecordFile = os.Getenv("GHA_DRY_RUN_FILE")
+	}
+	if cfg.MaxRecords <= 0 {
+		cfg.MaxRecords = 100
+	}
+
+	planner, err := newDispatchPlanner(cfg.ReceiverRepos, cfg.RoutingRules, cfg.Normalize)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Dedup, cfg.DedupTTL, err = dedupConfig(cfg.Dedup, cfg.DedupTTL, cfg.DisableDedup)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Callback, err = callbackConfig(cfg.Callback, cfg.DisableCallback)
+	if err != nil {
+		return nil, err
+	}
+
+	dsp := &DryRunEventDispatcher{
+		dispatchCommon: dispatchCommon{
+			planner:  planner,
+			dedup:    cfg.Dedup,
+			dedupTTL: cfg.DedupTTL,
+			callback: cfg.Callback,
+		},
+		maxRecords: cfg.MaxRecords,
+	}
+	if cfg.RecordFile != "" {
+		f, err := os.OpenFile(cfg.RecordFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		dsp.file = f
+	}
+
+	return dsp, nil
+}
+
+func (dsp *DryRunEventDispatcher) Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
+	eventType, payload, receivers, err := dsp.planner.plan(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &DispatchResult{
+		EventType: eventType,
+	}
+	if len(receivers) == 0 {
+		log.Infof(ctx, "[dry-run] no receivers matched: %s", eventType)
+		return res, nil
+	}
+
+	now := time.Now()
+	// records keep the order of receivers.
+	dsp.each(ctx, req, res, receivers, func(rr *ReceiverResult, send func()) {
+		send()
+	}, func(rr *ReceiverResult, dl *delivery) {
+		receiver := rr.Receiver
+		record := &DryRunRecord{
+			Time:      now,
+			Receiver:  receiver.String(),
+			Target:    receiver.Target,
+			EventType: eventType,
+		}
+		if record.Target == "" {
+			record.Target = TargetRepositoryDispatch
+		}
+
+		md := dsp.metadata(ctx, dl, rr.Repo, eventType)
+		if md.CallbackToken != "" {
+			md.CallbackToken = dryRunCallbackToken
+		}
+		var err error
+		record.Payload, record.Inputs, err = receiverBody(receiver, eventType, payload, md)
+		if err != nil {
+			record.Error = err.Error()
+		}
+		rr.finish(now, err)
+
+		log.Infof(ctx, "[dry-run] dispatch %s to %s: %s", eventType, receiver.String(), string(payload))
+		dsp.record(ctx, record)
+	})
+
+	return res, res.Err()
+}
+
+func (dsp *DryRunEventDispatcher) record(ctx context.Context, record *DryRunRecord) {
+	dsp.mu.Lock()
+	defer dsp.mu.Unlock()
+
+	dsp.records = append(dsp.records, record)
+	if over := len(dsp.records) - dsp.maxRecords; over > 0 {
+		dsp.records = append([]*DryRunRecord(nil), dsp.records[over:]...)
+	}
+
+	if dsp.file == nil {
+		return
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Warnf(ctx, "[dry-run] marshal record failed: %s", err.Error())
+		return
+	}
+	b = append(b, '\n')
+	if _, err := dsp.file.Write(b); err != nil {
+		log.Warnf(ctx, "[dry-run] write record failed: %s", err.Error())
+	}
+}
+
+// Records returns recent records in dispatched order.
+func (dsp *DryRunEventDispatcher) Records() []*DryRunRecord {
+	dsp.mu.Lock()
+	defer dsp.mu.Unlock()
+
+	return append([]*DryRunRecord(nil), dsp.records...)
+}
+
+// ServeHTTP serves recent records as JSON.
+func (dsp *DryRunEventDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(dsp.Records())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+func (dsp *DryRunEventDispatcher) Close() error {
+	dsp.mu.Lock()
+	defer dsp.mu.Unlock()
+
+	if dsp.file == nil {
+		return nil
+	}
+	err := dsp.file.Close()
+	dsp.file = nil
+
+	return err
+}
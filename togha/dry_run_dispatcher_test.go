@@ -0,0 +1,170 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRunEventDispatcher(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	dsp, err := NewDryRunEventDispatcher(ctx, &DryRunConfig{
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha"},
+			{Owner: "vvakame", Name: "se2gha", Target: TargetWorkflowDispatch, Workflow: "test.yml", Ref: "master"},
+		},
+		RoutingRules: []*RoutingRule{},
+		Normalize:    &NormalizeConfig{},
+		RecordFile:   filepath.Join(dir, "dry-run.jsonl"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dsp.Close()
+
+	res, err := dsp.Dispatch(ctx, &testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{"title":"hi"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := len(res.Receivers); v != 2 {
+		t.Fatalf("unexpected receivers len: %d", v)
+	}
+
+	records := dsp.Records()
+	if v := len(records); v != 2 {
+		t.Fatalf("unexpected records len: %d", v)
+	}
+	if v := records[1].Inputs["title"]; v != "hi" {
+		t.Errorf("unexpected inputs: %v", records[1].Inputs)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "dry-run.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.Count(string(b), "\n"); v != 2 {
+		t.Errorf("unexpected record file lines: %d", v)
+	}
+}
+
+type testKeyedCallbackRequest struct {
+	testCallbackRequest
+	key string
+}
+
+func (req *testKeyedCallbackRequest) IdempotencyKey() string {
+	return req.key
+}
+
+func TestDryRunEventDispatcher_sameAsDispatch(t *testing.T) {
+	ctx := context.Background()
+
+	var sent json.RawMessage
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		var body struct {
+			ClientPayload json.RawMessage `json:"client_payload"`
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Error(err)
+		}
+		sent = body.ClientPayload
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	signer, err := NewCallbackSigner(testCallbackSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := &CallbackConfig{Signer: signer, URL: "https://se2gha.example.com/callback"}
+	receivers := []*ReceiverRepo{{Owner: "vvakame", Name: "se2gha"}}
+
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient:  client,
+		ReceiverRepos: receivers,
+		RoutingRules:  []*RoutingRule{},
+		Normalize:     &NormalizeConfig{},
+		Callback:      callback,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryRun, err := NewDryRunEventDispatcher(ctx, &DryRunConfig{
+		ReceiverRepos: receivers,
+		RoutingRules:  []*RoutingRule{},
+		Normalize:     &NormalizeConfig{},
+		Callback:      callback,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dryRun.Close()
+
+	newReq := func() DispatchRequest {
+		return &testKeyedCallbackRequest{
+			testCallbackRequest: testCallbackRequest{
+				testSourceRequest: testSourceRequest{
+					testDispatchRequest: testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{"title":"hi"}`)},
+					source:              "slack",
+				},
+				ref: map[string]string{"channel": "C1", "ts": "1600000000.000200"},
+			},
+			key: "slack:Ev01",
+		}
+	}
+	if _, err := dsp.Dispatch(ctx, newReq()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dryRun.Dispatch(ctx, newReq()); err != nil {
+		t.Fatal(err)
+	}
+	records := dryRun.Records()
+	if len(records) != 1 {
+		t.Fatalf("unexpected records len: %d", len(records))
+	}
+
+	// delivery IDs are random per dispatch. the token is redacted in records.
+	normalize := func(payload json.RawMessage, token string) string {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			t.Fatal(err)
+		}
+		md := &Metadata{}
+		if err := json.Unmarshal(fields[MetadataKey], md); err != nil {
+			t.Fatal(err)
+		}
+		if md.DeliveryID == "" || md.CallbackToken == "" || (token != "" && md.CallbackToken != token) {
+			t.Errorf("unexpected metadata: %+v", md)
+		}
+		md.DeliveryID = ""
+		md.CallbackToken = ""
+		fields[MetadataKey], _ = json.Marshal(md)
+		b, _ := json.Marshal(fields)
+		return string(b)
+	}
+	if got, want := normalize(records[0].Payload, dryRunCallbackToken), normalize(sent, ""); got != want {
+		t.Errorf("dry-run payload %s, want %s", got, want)
+	}
+
+	// the same event is skipped like production.
+	res, err := dryRun.Dispatch(ctx, newReq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Receivers[0].Duplicate {
+		t.Errorf("duplicate is not skipped: %+v", res.Receivers[0])
+	}
+	if v := len(dryRun.Records()); v != 1 {
+		t.Errorf("unexpected records len: %d", v)
+	}
+}
@@ -0,0 +1,415 @@
+package togha
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"golang.org/x/oauth2"
+)
+
+// GitHubClientProvider returns a GitHub API client which is authorized for the receiver.
+type GitHubClientProvider interface {
+	Client(ctx context.Context, receiver *ReceiverRepo) (*github.Client, error)
+}
+
+// StaticGitHubClientProvider uses same client for every receiver.
+type StaticGitHubClientProvider struct {
+	GitHubClient *github.Client
+}
+
+func (p *StaticGitHubClientProvider) Client(ctx context.Context, receiver *ReceiverRepo) (*github.Client, error) {
+	return p.GitHubClient, nil
+}
+
+// NewTokenGitHubClientProvider uses a static token like Personal Access Token for every receiver.
+func NewTokenGitHubClientProvider(ctx context.Context, token string) GitHubClientProvider {
+	p, _ := NewEnterpriseTokenGitHubClientProvider(ctx, token, "", "")
+	return p
+}
+
+// NewEnterpriseTokenGitHubClientProvider is NewTokenGitHubClientProvider for GitHub Enterprise Server.
+// github.com is used if baseURL is empty.
+func NewEnterpriseTokenGitHubClientProvider(ctx context.Context, token, baseURL, uploadURL string) (GitHubClientProvider, error) {
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	tc := oauth2.NewClient(ctx, ts)
+
+	client, err := newGitHubClient(tc, baseURL, uploadURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenGitHubClientProvider{client: client}, nil
+}
+
+type tokenGitHubClientProvider struct {
+	client *github.Client
+}
+
+func (p *tokenGitHubClientProvider) Client(ctx context.Context, receiver *ReceiverRepo) (*github.Client, error) {
+	if err := checkHost(p.client, receiver); err != nil {
+		return nil, err
+	}
+
+	return p.client, nil
+}
+
+// newGitHubClient builds a client for github.com, or for GitHub Enterprise Server if baseURL is specified.
+// uploadURL is derived from baseURL if empty.
+func newGitHubClient(httpClient *http.Client, baseURL, uploadURL string) (*github.Client, error) {
+	if baseURL == "" {
+		if uploadURL != "" {
+			return nil, errors.New("upload URL requires base URL")
+		}
+		return github.NewClient(httpClient), nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("base URL must be absolute http(s) URL: %s", baseURL)
+	}
+	if uploadURL == "" {
+		uploadURL = (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+	}
+
+	return github.NewEnterpriseClient(baseURL, uploadURL, httpClient)
+}
+
+// checkHost rejects a receiver on another GitHub host, so a credential is never sent to wrong host.
+// a receiver without BaseURL accepts any credential.
+func checkHost(client *github.Client, receiver *ReceiverRepo) error {
+	host := receiver.host()
+	if host == "" || host == client.BaseURL.Host {
+		return nil
+	}
+
+	return fmt.Errorf("credential for %s can not be used for %s", client.BaseURL.Host, receiver.String())
+}
+
+// MultiGitHubClientProvider selects a provider by ReceiverRepo.Credential.
+type MultiGitHubClientProvider struct {
+	Providers map[string]GitHubClientProvider
+	// Default is a credential name used when ReceiverRepo.Credential is empty.
+	Default string
+}
+
+func (p *MultiGitHubClientProvider) Client(ctx context.Context, receiver *ReceiverRepo) (*github.Client, error) {
+	name := receiver.Credential
+	if name == "" {
+		name = p.Default
+	}
+	provider, ok := p.Providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown credential %q for %s", name, receiver.String())
+	}
+
+	return provider.Client(ctx, receiver)
+}
+
+// GitHubAppConfig is a credential of GitHub App.
+type GitHubAppConfig struct {
+	AppID int64
+	// PrivateKey is a PEM encoded RSA private key of the App.
+	PrivateKey []byte
+	// BaseURL is an API endpoint of GitHub Enterprise Server. e.g. https://github.example.com/api/v3/
+	// github.com is used if empty.
+	BaseURL   string
+	UploadURL string
+	// HTTPClient is used for underlying transport. http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+	// newClient builds API client. for testing.
+	newClient func(httpClient *http.Client) *github.Client
+}
+
+// gitHubAppClientProvider exchanges App JWT for installation tokens.
+// an installation is resolved per receiver owner, so one App can dispatch into several orgs.
+type gitHubAppClientProvider struct {
+	cfg *GitHubAppConfig
+	key *rsa.PrivateKey
+
+	appCli *github.Client
+
+	mu            sync.Mutex
+	jwt           string
+	jwtExpiry     time.Time
+	clientByOwner map[string]*github.Client
+}
+
+const (
+	appJWTLifetime = 9 * time.Minute
+	// installation token lives 1 hour. refresh it a bit earlier.
+	installationTokenEarlyExpiry = 5 * time.Minute
+)
+
+func NewGitHubAppClientProvider(cfg *GitHubAppConfig) (GitHubClientProvider, error) {
+	if cfg == nil {
+		return nil, errors.New("cfg is required")
+	}
+	if cfg.AppID == 0 {
+		return nil, errors.New("AppID is required")
+	}
+	key, err := parseRSAPrivateKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if _, err := newGitHubClient(nil, cfg.BaseURL, cfg.UploadURL); err != nil {
+		return nil, err
+	}
+	if cfg.newClient == nil {
+		cfg.newClient = func(httpClient *http.Client) *github.Client {
+			// already validated above.
+			client, _ := newGitHubClient(httpClient, cfg.BaseURL, cfg.UploadURL)
+			return client
+		}
+	}
+
+	p := &gitHubAppClientProvider{
+		cfg:           cfg,
+		key:           key,
+		clientByOwner: make(map[string]*github.Client),
+	}
+	p.appCli = cfg.newClient(&http.Client{
+		Transport: &appJWTTransport{provider: p, base: cfg.HTTPClient.Transport},
+	})
+
+	return p, nil
+}
+
+func gitHubAppConfigFromEnv() (*GitHubAppConfig, error) {
+	ghaAppID := os.Getenv("GHA_APP_ID")
+	if ghaAppID == "" {
+		return nil, nil
+	}
+	appID, err := strconv.ParseInt(ghaAppID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GHA_APP_ID: %w", err)
+	}
+
+	privateKey := []byte(os.Getenv("GHA_APP_PRIVATE_KEY"))
+	if v := os.Getenv("GHA_APP_PRIVATE_KEY_FILE"); v != "" {
+		if len(privateKey) != 0 {
+			return nil, errors.New("GHA_APP_PRIVATE_KEY and GHA_APP_PRIVATE_KEY_FILE are exclusive")
+		}
+		privateKey, err = os.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(privateKey) == 0 {
+		return nil, errors.New("GHA_APP_PRIVATE_KEY or GHA_APP_PRIVATE_KEY_FILE environment variable is required")
+	}
+
+	return &GitHubAppConfig{
+		AppID:      appID,
+		PrivateKey: privateKey,
+		BaseURL:    os.Getenv("GHA_API_URL"),
+		UploadURL:  os.Getenv("GHA_UPLOAD_URL"),
+	}, nil
+}
+
+func parseRSAPrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected private key type: %T", key)
+	}
+
+	return rsaKey, nil
+}
+
+// appJWT returns a cached JWT which authenticates as the App itself.
+func (p *gitHubAppClientProvider) appJWT(now time.Time) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.jwt != "" && now.Add(time.Minute).Before(p.jwtExpiry) {
+		return p.jwt, nil
+	}
+
+	// iat is 60 seconds in the past to allow for clock drift.
+	iat := now.Add(-60 * time.Second)
+	exp := now.Add(appJWTLifetime)
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": iat.Unix(),
+		"exp": exp.Unix(),
+		"iss": strconv.FormatInt(p.cfg.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	p.jwt = signingInput + "." + enc.EncodeToString(sig)
+	p.jwtExpiry = exp
+
+	return p.jwt, nil
+}
+
+func (p *gitHubAppClientProvider) Client(ctx context.Context, receiver *ReceiverRepo) (*github.Client, error) {
+	if err := checkHost(p.appCli, receiver); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	client, ok := p.clientByOwner[receiver.Owner]
+	p.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	installation, _, err := p.appCli.Apps.FindRepositoryInstallation(ctx, receiver.Owner, receiver.Name)
+	if err != nil {
+		return nil, fmt.Errorf("GitHub App installation for %s is not found: %w", receiver.String(), err)
+	}
+
+	ts := oauth2.ReuseTokenSource(nil, &installationTokenSource{
+		provider:       p,
+		installationID: installation.GetID(),
+	})
+	transport := &installationTransport{
+		provider: p,
+		owner:    receiver.Owner,
+		base:     &oauth2.Transport{Source: ts, Base: p.cfg.HTTPClient.Transport},
+	}
+	client = p.cfg.newClient(&http.Client{Transport: transport})
+	transport.client = client
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cached, ok := p.clientByOwner[receiver.Owner]; ok {
+		return cached, nil
+	}
+	p.clientByOwner[receiver.Owner] = client
+
+	return client, nil
+}
+
+// forget drops the cached client of owner, so next Client finds the installation again.
+// the cache may hold a newer client already, it is kept.
+func (p *gitHubAppClientProvider) forget(owner string, client *github.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.clientByOwner[owner] == client {
+		delete(p.clientByOwner, owner)
+	}
+}
+
+// installationTransport forgets the client when the installation is not usable anymore.
+// e.g. the App is uninstalled, reinstalled with a new installation ID, or lost access to the repository.
+type installationTransport struct {
+	provider *gitHubAppClientProvider
+	owner    string
+	client   *github.Client
+	base     http.RoundTripper
+}
+
+func (t *installationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		// the installation token is not issued.
+		var errResp *github.ErrorResponse
+		if errors.As(err, &errResp) && isAuthFailure(errResp.Response) {
+			t.provider.forget(t.owner, t.client)
+		}
+		return nil, err
+	}
+	if isAuthFailure(resp) {
+		t.provider.forget(t.owner, t.client)
+	}
+
+	return resp, nil
+}
+
+func isAuthFailure(resp *http.Response) bool {
+	return resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotFound)
+}
+
+// installationTokenSource issues a new installation access token each time.
+// wrap it by oauth2.ReuseTokenSource for caching.
+type installationTokenSource struct {
+	provider       *gitHubAppClientProvider
+	installationID int64
+}
+
+func (ts *installationTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	token, _, err := ts.provider.appCli.Apps.CreateInstallationToken(ctx, ts.installationID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		Expiry:      token.GetExpiresAt().Add(-installationTokenEarlyExpiry),
+	}, nil
+}
+
+type appJWTTransport struct {
+	provider *gitHubAppClientProvider
+	base     http.RoundTripper
+}
+
+func (t *appJWTTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jwt, err := t.provider.appJWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(req)
+}
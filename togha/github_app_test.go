@@ -0,0 +1,272 @@
+package togha
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+)
+
+func Test_gitHubAppClientProvider(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var tokenIssued int32
+	verifyJWT := func(r *http.Request) error {
+		ss := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		if len(ss) != 3 {
+			return fmt.Errorf("unexpected authorization: %s", r.Header.Get("Authorization"))
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(ss[2])
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(ss[0] + "." + ss[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+			return err
+		}
+		b, err := base64.RawURLEncoding.DecodeString(ss[1])
+		if err != nil {
+			return err
+		}
+		claims := map[string]interface{}{}
+		if err := json.Unmarshal(b, &claims); err != nil {
+			return err
+		}
+		if v := claims["iss"]; v != "42" {
+			return fmt.Errorf("unexpected iss: %v", v)
+		}
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/vvakame/se2gha/installation", func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyJWT(r); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": 7}`))
+	})
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyJWT(r); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&tokenIssued, 1)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "ghs_installation",
+			"expires_at": time.Now().Add(time.Hour),
+		})
+	})
+	mux.HandleFunc("/repos/vvakame/se2gha/dispatches", func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("Authorization"); v != "Bearer ghs_installation" {
+			t.Errorf("unexpected authorization: %s", v)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	baseURL, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewGitHubAppClientProvider(&GitHubAppConfig{
+		AppID:      42,
+		PrivateKey: pemKey,
+		newClient: func(httpClient *http.Client) *github.Client {
+			client := github.NewClient(httpClient)
+			client.BaseURL = baseURL
+			return client
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := &ReceiverRepo{Owner: "vvakame", Name: "se2gha"}
+	for i := 0; i < 2; i++ {
+		client, err := provider.Client(ctx, receiver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = client.Repositories.Dispatch(ctx, receiver.Owner, receiver.Name, github.DispatchRequestOptions{EventType: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if v := atomic.LoadInt32(&tokenIssued); v != 1 {
+		t.Errorf("installation token should be cached, issued: %d", v)
+	}
+}
+
+func Test_gitHubAppClientProvider_reinstalled(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	// installation is the current installation ID. reinstalling the App changes it.
+	var installation, lookups int32 = 7, 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/vvakame/se2gha/installation", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		_, _ = fmt.Fprintf(w, `{"id": %d}`, atomic.LoadInt32(&installation))
+	})
+	mux.HandleFunc("/app/installations/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fmt.Sprintf("/app/installations/%d/access_tokens", atomic.LoadInt32(&installation)) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      fmt.Sprintf("ghs_%d", atomic.LoadInt32(&installation)),
+			"expires_at": time.Now().Add(time.Hour),
+		})
+	})
+	mux.HandleFunc("/repos/vvakame/se2gha/dispatches", func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("Authorization"); v != fmt.Sprintf("Bearer ghs_%d", atomic.LoadInt32(&installation)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	baseURL, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewGitHubAppClientProvider(&GitHubAppConfig{
+		AppID:      42,
+		PrivateKey: pemKey,
+		newClient: func(httpClient *http.Client) *github.Client {
+			client := github.NewClient(httpClient)
+			client.BaseURL = baseURL
+			return client
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := &ReceiverRepo{Owner: "vvakame", Name: "se2gha"}
+	dispatch := func(client *github.Client) error {
+		_, _, err := client.Repositories.Dispatch(ctx, receiver.Owner, receiver.Name, github.DispatchRequestOptions{EventType: "test"})
+		return err
+	}
+	steps := []struct {
+		name         string
+		installation int32
+		wantErr      bool
+	}{
+		// the installation is gone before its token is issued.
+		{"token not issued", 8, true},
+		{"new installation", 8, false},
+		// the issued token is revoked by reinstalling.
+		{"token revoked", 9, true},
+		{"reinstalled", 9, false},
+	}
+	for _, step := range steps {
+		client, err := provider.Client(ctx, receiver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&installation, step.installation)
+		if err := dispatch(client); (err != nil) != step.wantErr {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+	}
+
+	if v := atomic.LoadInt32(&lookups); v != 3 {
+		t.Errorf("unexpected installation lookups: %d", v)
+	}
+}
+
+func TestNewEnterpriseTokenGitHubClientProvider(t *testing.T) {
+	ctx := context.Background()
+
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	provider, err := NewEnterpriseTokenGitHubClientProvider(ctx, "ghe-token", srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClients: &MultiGitHubClientProvider{
+			Providers: map[string]GitHubClientProvider{
+				"ghe":    provider,
+				"dotcom": NewTokenGitHubClientProvider(ctx, "dotcom-token"),
+			},
+			Default: "dotcom",
+		},
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "ghe", Credential: "ghe", BaseURL: srv.URL},
+			// must not send GHE token to github.com.
+			{Owner: "vvakame", Name: "wrong", Credential: "ghe", BaseURL: "https://api.github.com/"},
+		},
+		Retry:        &RetryConfig{MaxAttempts: 1},
+		DisableDedup: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := dsp.Dispatch(ctx, &testDispatchRequest{eventType: "test"})
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	if gotPath != "/api/v3/repos/vvakame/ghe/dispatches" {
+		t.Errorf("unexpected path: %s", gotPath)
+	}
+	if gotAuth != "Bearer ghe-token" {
+		t.Errorf("unexpected authorization: %s", gotAuth)
+	}
+	if v := res.Receivers[0].Repo; v != u.Host+"/vvakame/ghe" {
+		t.Errorf("unexpected receiver: %s", v)
+	}
+	if !res.Receivers[0].Succeeded() {
+		t.Errorf("unexpected error: %v", res.Receivers[0].Err)
+	}
+	if v := res.Receivers[1].Err; v == nil || !strings.Contains(v.Error(), "can not be used for api.github.com/vvakame/wrong") {
+		t.Errorf("unexpected error: %v", v)
+	}
+}
@@ -5,22 +5,89 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"golang.org/x/oauth2"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/v50/github"
+	"github.com/vvakame/se2gha/health"
 	"github.com/vvakame/se2gha/log"
-	"golang.org/x/sync/errgroup"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type EventDispatcher interface {
-	Dispatch(ctx context.Context, req DispatchRequest) error
+	Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error)
 }
 
+// TargetKind is a GitHub API which receives the event.
+type TargetKind string
+
+const (
+	TargetRepositoryDispatch TargetKind = "repository_dispatch"
+	TargetWorkflowDispatch   TargetKind = "workflow_dispatch"
+)
+
 type ReceiverRepo struct {
 	Owner string
 	Name  string
+
+	// Target is TargetRepositoryDispatch if empty.
+	Target TargetKind
+	// Workflow is a workflow file name. e.g. issue-from-slack.yml . for TargetWorkflowDispatch.
+	Workflow string
+	// Ref is a branch or tag to run the workflow. for TargetWorkflowDispatch.
+	Ref string
+	// Inputs limits payload fields passed as workflow inputs. all top-level fields are passed if empty.
+	Inputs []string
+
+	// Credential is a name of credential for MultiGitHubClientProvider. default credential is used if empty.
+	Credential string
+
+	// BaseURL is an API endpoint of GitHub Enterprise Server. e.g. https://github.example.com/api/v3/
+	// if empty, the host of the credential is used.
+	BaseURL   string
+	UploadURL string
+}
+
+// String returns "owner/name" for repository_dispatch, "owner/name:workflow@ref" for workflow_dispatch.
+// it is prefixed by the host if BaseURL is specified. e.g. "github.example.com/owner/name"
+func (repo *ReceiverRepo) String() string {
+	s := fmt.Sprintf("%s/%s", repo.Owner, repo.Name)
+	if repo.Target == TargetWorkflowDispatch {
+		s = fmt.Sprintf("%s:%s@%s", s, repo.Workflow, repo.Ref)
+	}
+	if host := repo.host(); host != "" {
+		s = host + "/" + s
+	}
+
+	return s
+}
+
+// repoName is "owner/name" without the workflow, prefixed by the host like String.
+func (repo *ReceiverRepo) repoName() string {
+	s := fmt.Sprintf("%s/%s", repo.Owner, repo.Name)
+	if host := repo.host(); host != "" {
+		s = host + "/" + s
+	}
+
+	return s
+}
+
+func (repo *ReceiverRepo) host() string {
+	if repo.BaseURL == "" {
+		return ""
+	}
+	u, err := url.Parse(repo.BaseURL)
+	if err != nil {
+		return repo.BaseURL
+	}
+
+	return u.Host
 }
 
 type DispatchRequest interface {
@@ -29,74 +96,319 @@ type DispatchRequest interface {
 }
 
 type EventDispatcherConfig struct {
+	// GitHubClient is used for all receivers. ignored if GitHubClients is specified.
 	GitHubClient  *github.Client
+	GitHubClients GitHubClientProvider
 	ReceiverRepos []*ReceiverRepo
+	RoutingRules  []*RoutingRule
+	Retry         *RetryConfig
+	Normalize     *NormalizeConfig
+	// Dedup skips receivers which already got the event with same IdempotencyKeyer key.
+	// a new MemoryDedupStore is used if omitted. pass the same store to keep it across dispatchers.
+	Dedup DedupStore
+	// DedupTTL is read from GHA_DEDUP_TTL if zero.
+	DedupTTL     time.Duration
+	DisableDedup bool
+	// Limiter bounds API calls. it is built from environment variables if nil.
+	// pass the same Limiter to share the budget across dispatchers.
+	Limiter *Limiter
+	// Callback adds callback tokens to client_payload. it is read from environment variables if nil.
+	Callback        *CallbackConfig
+	DisableCallback bool
 }
 
 func NewEventDispatcher(ctx context.Context, cfg *EventDispatcherConfig) (EventDispatcher, error) {
 	if cfg == nil {
 		cfg = &EventDispatcherConfig{}
 	}
-	if cfg.GitHubClient == nil {
-		ghaRepoToken := os.Getenv("GHA_REPO_TOKEN")
-		if ghaRepoToken == "" {
-			return nil, errors.New("GHA_REPO_TOKEN environment variable is required")
+	if cfg.GitHubClients == nil && cfg.GitHubClient != nil {
+		cfg.GitHubClients = &StaticGitHubClientProvider{GitHubClient: cfg.GitHubClient}
+	}
+	if cfg.GitHubClients == nil {
+		appCfg, err := gitHubAppConfigFromEnv()
+		if err != nil {
+			return nil, err
 		}
+		ghaRepoToken := os.Getenv("GHA_REPO_TOKEN")
 
-		ts := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: ghaRepoToken},
-		)
-		tc := oauth2.NewClient(ctx, ts)
-		client := github.NewClient(tc)
+		switch {
+		case appCfg != nil && ghaRepoToken != "":
+			return nil, errors.New("GHA_REPO_TOKEN and GHA_APP_ID are exclusive")
+		case appCfg != nil:
+			provider, err := NewGitHubAppClientProvider(appCfg)
+			if err != nil {
+				return nil, err
+			}
+
+			cfg.GitHubClients = provider
+		case ghaRepoToken != "":
+			provider, err := NewEnterpriseTokenGitHubClientProvider(ctx, ghaRepoToken, os.Getenv("GHA_API_URL"), os.Getenv("GHA_UPLOAD_URL"))
+			if err != nil {
+				return nil, err
+			}
 
-		cfg.GitHubClient = client
+			cfg.GitHubClients = provider
+		default:
+			return nil, errors.New("GHA_REPO_TOKEN or GHA_APP_ID environment variable is required")
+		}
+	}
+	planner, err := newDispatchPlanner(cfg.ReceiverRepos, cfg.RoutingRules, cfg.Normalize)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.ReceiverRepos == nil {
-		ghaRepos := os.Getenv("GHA_REPOS")
-		if ghaRepos == "" {
-			return  nil, errors.New("GHA_REPOS environment variable is required")
+	if cfg.Retry == nil {
+		retry := DefaultRetryConfig
+		if v := os.Getenv("GHA_DISPATCH_DEADLINE"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid GHA_DISPATCH_DEADLINE: %w", err)
+			}
+			retry.Deadline = d
 		}
 
-		repos, err := ParseReceiverRepos(ghaRepos)
+		cfg.Retry = &retry
+	}
+	cfg.Dedup, cfg.DedupTTL, err = dedupConfig(cfg.Dedup, cfg.DedupTTL, cfg.DisableDedup)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Limiter == nil {
+		limitCfg, err := limitConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Limiter, err = NewLimiter(limitCfg)
 		if err != nil {
 			return nil, err
 		}
-
-		cfg.ReceiverRepos = repos
 	}
-	if len(cfg.ReceiverRepos) == 0 {
-		return nil, errors.New("ReceiverRepos requires over 1 item")
+
+	cfg.Callback, err = callbackConfig(cfg.Callback, cfg.DisableCallback)
+	if err != nil {
+		return nil, err
 	}
 
 	return &gitHubEventDispatcher{
-		ghCli:     cfg.GitHubClient,
-		receivers: cfg.ReceiverRepos,
+		dispatchCommon: dispatchCommon{
+			planner:  planner,
+			dedup:    cfg.Dedup,
+			dedupTTL: cfg.DedupTTL,
+			callback: cfg.Callback,
+		},
+		clients: cfg.GitHubClients,
+		retry:   cfg.Retry,
+		limiter: cfg.Limiter,
 	}, nil
 }
 
+// dedupConfig fills DedupStore and its TTL from environment variables. the store is nil if disabled.
+func dedupConfig(store DedupStore, ttl time.Duration, disable bool) (DedupStore, time.Duration, error) {
+	if ttl <= 0 && !disable {
+		var err error
+		ttl, err = dedupTTLFromEnv()
+		if err != nil {
+			return nil, 0, err
+		}
+		disable = ttl <= 0
+	}
+	if disable {
+		return nil, ttl, nil
+	}
+	if store == nil {
+		store = NewMemoryDedupStore()
+	}
+
+	return store, ttl, nil
+}
+
+// callbackConfig reads CallbackConfig from environment variables if cfg is nil. it is nil if disabled.
+func callbackConfig(cfg *CallbackConfig, disable bool) (*CallbackConfig, error) {
+	if disable {
+		return nil, nil
+	}
+	if cfg == nil {
+		return CallbackConfigFromEnv()
+	}
+
+	return cfg, nil
+}
+
 type gitHubEventDispatcher struct {
-	ghCli     *github.Client
-	receivers []*ReceiverRepo
+	dispatchCommon
+
+	clients GitHubClientProvider
+	retry   *RetryConfig
+	limiter *Limiter
+	health  health.Cache
+}
+
+// dispatchCommon is shared by gitHubEventDispatcher and DryRunEventDispatcher,
+// so dry-run skips and builds the same as production.
+type dispatchCommon struct {
+	planner  *dispatchPlanner
+	dedup    DedupStore
+	dedupTTL time.Duration
+	callback *CallbackConfig
 }
 
-func (dsp *gitHubEventDispatcher) Dispatch(ctx context.Context, req DispatchRequest) error {
-	eventType, err := req.EventType()
+func (dsp *gitHubEventDispatcher) Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
+	eventType, payload, receivers, err := dsp.planner.plan(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	payload, err := req.Payload()
+
+	log.Debugf(ctx, "github dispatch event: %s, %s", eventType, string(payload))
+
+	res := &DispatchResult{
+		EventType: eventType,
+	}
+
+	if len(receivers) == 0 {
+		log.Infof(ctx, "no receivers matched: %s", eventType)
+		return res, nil
+	}
+
+	var wg sync.WaitGroup
+	dsp.each(ctx, req, res, receivers, func(rr *ReceiverResult, send func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send()
+		}()
+	}, func(rr *ReceiverResult, dl *delivery) {
+		dsp.dispatchTo(ctx, rr, eventType, payload, dl)
+	})
+	wg.Wait()
+
+	return res, res.Err()
+}
+
+// each adds a result per receiver to res, and sends req to receivers which did not get it yet.
+// run decides how to call send, e.g. concurrently.
+func (dsp *dispatchCommon) each(ctx context.Context, req DispatchRequest, res *DispatchResult, receivers []*ReceiverRepo, run func(rr *ReceiverResult, send func()), send func(rr *ReceiverResult, dl *delivery)) {
+	var idempotencyKey string
+	if keyer, ok := req.(IdempotencyKeyer); ok && dsp.dedup != nil {
+		idempotencyKey = keyer.IdempotencyKey()
+	}
+	delivered, _ := req.(DeliveredReceivers)
+	dl := dsp.newDelivery(ctx, req)
+
+	for _, receiver := range receivers {
+		rr := newReceiverResult(receiver)
+		res.Receivers = append(res.Receivers, rr)
+		if delivered != nil && delivered.Delivered(rr.Repo) {
+			log.Infof(ctx, "skip delivered event to %s", rr.Repo)
+			rr.Duplicate = true
+			metrics.ObserveDispatch(rr.Repo, "duplicate", 0, 0)
+			continue
+		}
+
+		run(rr, func() {
+			dsp.sendOnce(ctx, rr, idempotencyKey, func() { send(rr, dl) })
+		})
+	}
+}
+
+// sendOnce skips the receiver if it already got the event.
+func (dsp *dispatchCommon) sendOnce(ctx context.Context, rr *ReceiverResult, idempotencyKey string, send func()) {
+	if idempotencyKey == "" {
+		send()
+		return
+	}
+
+	key := dedupKey(idempotencyKey, rr.Receiver)
+	ok, err := dsp.dedup.Reserve(ctx, key, dsp.dedupTTL)
 	if err != nil {
-		return err
+		// prefer duplicates over losing events.
+		log.Warnf(ctx, "dedup store failed: %s", err.Error())
+		send()
+		return
+	}
+	if !ok {
+		log.Infof(ctx, "skip duplicated event to %s: %s", rr.Repo, idempotencyKey)
+		rr.Duplicate = true
+		metrics.ObserveDispatch(rr.Repo, "duplicate", 0, 0)
+		return
 	}
 
-	log.Debugf(ctx, "github dispatch event: %s, %s", eventType, string(payload))
+	send()
+	if !rr.Succeeded() {
+		if err := dsp.dedup.Release(ctx, key); err != nil {
+			log.Warnf(ctx, "dedup store failed: %s", err.Error())
+		}
+	}
+}
+
+// metadata returns Metadata added to client_payload for the receiver, with a callback token if enabled.
+func (dsp *dispatchCommon) metadata(ctx context.Context, dl *delivery, receiver string, eventType string) *Metadata {
+	md := metadataFromContext(ctx)
+	dsp.addCallback(ctx, md, dl, receiver, eventType)
+
+	return md
+}
+
+// receiverBody builds what is sent to the receiver. client_payload with md for repository_dispatch, or inputs for workflow_dispatch.
+// DryRunEventDispatcher records the same.
+func receiverBody(receiver *ReceiverRepo, eventType string, payload json.RawMessage, md *Metadata) (json.RawMessage, map[string]interface{}, error) {
+	switch receiver.Target {
+	case "", TargetRepositoryDispatch:
+		return withMetadata(payload, md), nil, nil
+
+	case TargetWorkflowDispatch:
+		inputs, err := workflowInputs(payload, receiver.Inputs)
+		if err != nil {
+			// same payload always fails, retrying it is pointless.
+			return nil, nil, &ValidationError{EventType: eventType, Reason: err.Error()}
+		}
+		return nil, inputs, nil
+
+	default:
+		return nil, nil, &ValidationError{EventType: eventType, Reason: fmt.Sprintf("unknown target kind: %s", receiver.Target)}
+	}
+}
+
+func (dsp *gitHubEventDispatcher) dispatchTo(ctx context.Context, rr *ReceiverResult, eventType string, payload json.RawMessage, dl *delivery) {
+	receiver := rr.Receiver
+	log.Debugf(ctx, "dispatch event to %s", receiver.String())
+
+	ctx, span := tracing.Start(ctx, "github.dispatch", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("se2gha.receiver", rr.Repo),
+		attribute.String("se2gha.event_type", eventType),
+	))
+	start := time.Now()
+	defer func() {
+		outcome := "success"
+		if !rr.Succeeded() {
+			outcome = "failure"
+		}
+		metrics.ObserveDispatch(rr.Repo, outcome, rr.Latency, rr.Attempts)
+
+		span.SetAttributes(
+			attribute.Int("se2gha.attempts", rr.Attempts),
+			attribute.Int("http.status_code", rr.StatusCode),
+		)
+		tracing.End(span, rr.Err)
+	}()
+	ghCli, err := dsp.clients.Client(ctx, receiver)
+	if err != nil {
+		rr.finish(start, err)
+		log.Warnf(ctx, "dispatch event to %s failed: %s", receiver.String(), err.Error())
+		return
+	}
 
-	var eg errgroup.Group
-	for _, receiver := range dsp.receivers {
-		receiver := receiver
-		eg.Go(func() error {
-			log.Debugf(ctx, "dispatch event to %s/%s", receiver.Owner, receiver.Name)
-			_, _, err = dsp.ghCli.Repositories.Dispatch(
+	payload, inputs, err := receiverBody(receiver, eventType, payload, dsp.metadata(ctx, dl, rr.Repo, eventType))
+	if err != nil {
+		rr.finish(start, err)
+		log.Warnf(ctx, "dispatch event to %s failed: %s", receiver.String(), err.Error())
+		return
+	}
+
+	var call func(ctx context.Context) (*github.Response, error)
+	switch receiver.Target {
+	case "", TargetRepositoryDispatch:
+		call = func(ctx context.Context) (*github.Response, error) {
+			_, resp, err := ghCli.Repositories.Dispatch(
 				ctx,
 				receiver.Owner,
 				receiver.Name,
@@ -105,16 +417,54 @@ func (dsp *gitHubEventDispatcher) Dispatch(ctx context.Context, req DispatchRequ
 					ClientPayload: &payload,
 				},
 			)
-			return err
-		})
+			return resp, err
+		}
+
+	case TargetWorkflowDispatch:
+		call = func(ctx context.Context) (*github.Response, error) {
+			return ghCli.Actions.CreateWorkflowDispatchEventByFileName(
+				ctx,
+				receiver.Owner,
+				receiver.Name,
+				receiver.Workflow,
+				github.CreateWorkflowDispatchEventRequest{
+					Ref:    receiver.Ref,
+					Inputs: inputs,
+				},
+			)
+		}
 	}
-	if err := eg.Wait(); err != nil {
+
+	err = dsp.retry.retry(ctx, func(ctx context.Context) error {
+		release, err := dsp.limiter.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		rr.Attempts++
+		resp, err := call(ctx)
+		if resp != nil {
+			rr.StatusCode = resp.StatusCode
+		}
 		return err
-	}
+	})
+	rr.finish(start, err)
 
-	return nil
+	if err != nil {
+		log.Warn(ctx, "dispatch event failed",
+			"receiver", receiver.String(),
+			"event_type", eventType,
+			"status", rr.StatusCode,
+			"attempts", rr.Attempts,
+			"latency_ms", rr.LatencyMS,
+			"error", err.Error(),
+		)
+	}
 }
 
+// ParseReceiverRepos parses comma separated list.
+// each item is "owner/name" for repository_dispatch, or "owner/name:workflow@ref" for workflow_dispatch.
 func ParseReceiverRepos(reposStr string) ([]*ReceiverRepo, error) {
 	reposStr = strings.TrimSpace(reposStr)
 	ss1 := strings.Split(reposStr, ",")
@@ -125,15 +475,28 @@ func ParseReceiverRepos(reposStr string) ([]*ReceiverRepo, error) {
 		if s == "" {
 			continue
 		}
-		ss2 := strings.SplitN(s, "/", 2)
-		if len(ss2) != 2 {
+
+		repoStr, workflowStr, isWorkflow := strings.Cut(s, ":")
+		ss2 := strings.SplitN(repoStr, "/", 2)
+		if len(ss2) != 2 || ss2[0] == "" || ss2[1] == "" || strings.Contains(ss2[1], "/") {
 			return nil, fmt.Errorf("invalid GHA_REPOS syntax: %s", s)
 		}
-
-		repos = append(repos, &ReceiverRepo{
+		repo := &ReceiverRepo{
 			Owner: ss2[0],
 			Name:  ss2[1],
-		})
+		}
+
+		if isWorkflow {
+			workflow, ref, ok := strings.Cut(workflowStr, "@")
+			if !ok || workflow == "" || ref == "" {
+				return nil, fmt.Errorf("invalid GHA_REPOS syntax, workflow_dispatch requires workflow@ref: %s", s)
+			}
+			repo.Target = TargetWorkflowDispatch
+			repo.Workflow = workflow
+			repo.Ref = ref
+		}
+
+		repos = append(repos, repo)
 	}
 
 	if len(repos) == 0 {
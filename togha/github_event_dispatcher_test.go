@@ -0,0 +1,204 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+)
+
+func newTestGitHubClient(t *testing.T, h http.Handler) *github.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(srv.Client())
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = u
+
+	return client
+}
+
+func Test_gitHubEventDispatcher_Dispatch_partialFailure(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/vvakame/missing/") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient: client,
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha"},
+			{Owner: "vvakame", Name: "missing"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := dsp.Dispatch(ctx, &testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{}`)})
+	var dErr *DispatchError
+	if !errors.As(err, &dErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !dErr.Partial() {
+		t.Errorf("expected partial failure")
+	}
+	var errResp *github.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response.StatusCode != http.StatusNotFound {
+		t.Errorf("receiver error is not reachable: %v", err)
+	}
+	if !strings.Contains(err.Error(), "vvakame/missing") {
+		t.Errorf("error should name failed repo: %s", err.Error())
+	}
+
+	if v := len(res.Receivers); v != 2 {
+		t.Fatalf("unexpected receivers len: %d", v)
+	}
+	if rr := res.Receivers[0]; !rr.Succeeded() || rr.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected result: %+v", rr)
+	}
+	if rr := res.Receivers[1]; rr.Succeeded() || rr.StatusCode != http.StatusNotFound || rr.Attempts != 1 {
+		t.Errorf("unexpected result: %+v", rr)
+	}
+}
+
+func TestParseReceiverRepos(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "repository_dispatch",
+			s:    "vvakame/se2gha, vvakame/review.js",
+			want: []string{"vvakame/se2gha", "vvakame/review.js"},
+		},
+		{
+			name: "workflow_dispatch",
+			s:    "vvakame/se2gha:issue-from-slack.yml@master,vvakame/se2gha",
+			want: []string{"vvakame/se2gha:issue-from-slack.yml@master", "vvakame/se2gha"},
+		},
+		{
+			name:    "workflow_dispatch without ref",
+			s:       "vvakame/se2gha:issue-from-slack.yml",
+			wantErr: true,
+		},
+		{
+			name:    "no owner",
+			s:       "se2gha",
+			wantErr: true,
+		},
+		{
+			name:    "with host",
+			s:       "github.example.com/vvakame/se2gha",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseReceiverRepos(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseReceiverRepos() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseReceiverRepos() got = %v, want %v", got, tt.want)
+			}
+			for idx, repo := range got {
+				if v := repo.String(); v != tt.want[idx] {
+					t.Errorf("ParseReceiverRepos() got[%d] = %v, want %v", idx, v, tt.want[idx])
+				}
+			}
+		})
+	}
+}
+
+type testKeyedRequest struct {
+	testDispatchRequest
+	key string
+}
+
+func (req *testKeyedRequest) IdempotencyKey() string {
+	return req.key
+}
+
+func Test_gitHubEventDispatcher_Dispatch_dedup(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	failing := true
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[r.URL.Path]++
+		if failing && r.URL.Path == "/repos/vvakame/flaky/dispatches" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Validation Failed"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient: client,
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha"},
+			{Owner: "vvakame", Name: "flaky"},
+		},
+		Dedup: NewMemoryDedupStore(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &testKeyedRequest{
+		testDispatchRequest: testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{}`)},
+		key:                 "slack:Ev01",
+	}
+	if _, err := dsp.Dispatch(ctx, req); err == nil {
+		t.Fatal("expected error")
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+
+	res, err := dsp.Dispatch(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Receivers[0].Duplicate || res.Receivers[1].Duplicate {
+		t.Errorf("unexpected duplicate flags: %+v, %+v", res.Receivers[0], res.Receivers[1])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if v := calls["/repos/vvakame/se2gha/dispatches"]; v != 1 {
+		t.Errorf("succeeded receiver must get the event once, got %d", v)
+	}
+	if v := calls["/repos/vvakame/flaky/dispatches"]; v != 2 {
+		t.Errorf("failed receiver must get the event again, got %d", v)
+	}
+}
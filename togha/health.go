@@ -0,0 +1,53 @@
+package togha
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/vvakame/se2gha/health"
+)
+
+var _ health.Checker = (*gitHubEventDispatcher)(nil)
+
+// CheckHealth verifies each receiver repository is visible with its credential.
+// results are cached, so probes do not burn the API rate limit.
+func (dsp *gitHubEventDispatcher) CheckHealth(ctx context.Context) []*health.Check {
+	receivers := dsp.planner.router.receivers
+	checks := make([]*health.Check, len(receivers))
+
+	var wg sync.WaitGroup
+	for idx, receiver := range receivers {
+		idx, receiver := idx, receiver
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks[idx] = dsp.health.Do(ctx, fmt.Sprintf("github:%s", receiver.String()), func(ctx context.Context) error {
+				return dsp.checkReceiver(ctx, receiver)
+			})
+		}()
+	}
+	wg.Wait()
+
+	return checks
+}
+
+func (dsp *gitHubEventDispatcher) checkReceiver(ctx context.Context, receiver *ReceiverRepo) error {
+	ghCli, err := dsp.clients.Client(ctx, receiver)
+	if err != nil {
+		return err
+	}
+
+	release, err := dsp.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, _, err = ghCli.Repositories.Get(ctx, receiver.Owner, receiver.Name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package togha
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEventDispatcher_CheckHealth(t *testing.T) {
+	ctx := context.Background()
+
+	var calls int32
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/repos/vvakame/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"full_name":"vvakame/se2gha"}`))
+	}))
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient: client,
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha"},
+			{Owner: "vvakame", Name: "missing"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		checks := dsp.(*gitHubEventDispatcher).CheckHealth(ctx)
+		if len(checks) != 2 {
+			t.Fatalf("unexpected checks: %d", len(checks))
+		}
+		if v := checks[0]; v.Name != "github:vvakame/se2gha" || !v.OK {
+			t.Errorf("unexpected check: %+v", v)
+		}
+		if v := checks[1]; v.Name != "github:vvakame/missing" || v.OK || v.Error == "" {
+			t.Errorf("unexpected check: %+v", v)
+		}
+	}
+	if v := atomic.LoadInt32(&calls); v != 2 {
+		t.Errorf("results should be cached: %d calls", v)
+	}
+}
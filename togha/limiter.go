@@ -0,0 +1,159 @@
+package togha
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/vvakame/se2gha/log"
+	"golang.org/x/time/rate"
+)
+
+// LimitConfig bounds GitHub API calls across all dispatches.
+type LimitConfig struct {
+	// MaxConcurrency is the upper bound of simultaneous API calls. 0 means unlimited.
+	MaxConcurrency int
+	// RatePerSecond is the sustained rate of API calls. 0 means unlimited.
+	RatePerSecond float64
+	// Burst is the number of API calls allowed at once within RatePerSecond. 1 if zero.
+	Burst int
+}
+
+// DefaultLimitConfig is used when EventDispatcherConfig.Limiter is nil and no environment variables are set.
+var DefaultLimitConfig = LimitConfig{
+	MaxConcurrency: 10,
+}
+
+// Limiter is a worker pool with a token bucket. share one Limiter by dispatchers to share the budget.
+// calls over the limit wait in queue instead of failing, until their context is done.
+type Limiter struct {
+	sem  chan struct{}
+	rate *rate.Limiter
+
+	waiting  int64
+	inFlight int64
+}
+
+func NewLimiter(cfg *LimitConfig) (*Limiter, error) {
+	if cfg == nil {
+		cfg = &DefaultLimitConfig
+	}
+	if cfg.MaxConcurrency < 0 {
+		return nil, fmt.Errorf("invalid max concurrency: %d", cfg.MaxConcurrency)
+	}
+	if cfg.RatePerSecond < 0 {
+		return nil, fmt.Errorf("invalid rate: %f", cfg.RatePerSecond)
+	}
+	if cfg.Burst < 0 {
+		return nil, fmt.Errorf("invalid burst: %d", cfg.Burst)
+	}
+
+	l := &Limiter{}
+	if cfg.MaxConcurrency > 0 {
+		l.sem = make(chan struct{}, cfg.MaxConcurrency)
+	}
+	if cfg.RatePerSecond > 0 {
+		burst := cfg.Burst
+		if burst == 0 {
+			burst = 1
+		}
+		l.rate = rate.NewLimiter(rate.Limit(cfg.RatePerSecond), burst)
+	}
+
+	return l, nil
+}
+
+func limitConfigFromEnv() (*LimitConfig, error) {
+	cfg := DefaultLimitConfig
+	if v := os.Getenv("GHA_MAX_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GHA_MAX_CONCURRENCY: %w", err)
+		}
+		cfg.MaxConcurrency = n
+	}
+	if v := os.Getenv("GHA_RATE_LIMIT"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GHA_RATE_LIMIT: %w", err)
+		}
+		cfg.RatePerSecond = f
+	}
+	if v := os.Getenv("GHA_RATE_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GHA_RATE_BURST: %w", err)
+		}
+		cfg.Burst = n
+	}
+
+	return &cfg, nil
+}
+
+// acquire waits for a worker and a token. release must be called after the API call.
+func (l *Limiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil || (l.sem == nil && l.rate == nil) {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	depth := atomic.AddInt64(&l.waiting, 1)
+	defer atomic.AddInt64(&l.waiting, -1)
+
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	releaseSem := func() {
+		if l.sem != nil {
+			<-l.sem
+		}
+	}
+
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			releaseSem()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			// the wait exceeds the deadline of ctx.
+			return nil, fmt.Errorf("%w: %s", context.DeadlineExceeded, err.Error())
+		}
+	}
+
+	if wait := time.Since(start); wait > 100*time.Millisecond {
+		log.Debugf(ctx, "waited %s for GitHub API budget, queue depth %d", wait, depth)
+	}
+
+	atomic.AddInt64(&l.inFlight, 1)
+	release = func() {
+		atomic.AddInt64(&l.inFlight, -1)
+		releaseSem()
+	}
+
+	return release, nil
+}
+
+// QueueDepth returns the number of API calls waiting for a worker or a token.
+func (l *Limiter) QueueDepth() int {
+	if l == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt64(&l.waiting))
+}
+
+// InFlight returns the number of API calls in progress.
+func (l *Limiter) InFlight() int {
+	if l == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt64(&l.inFlight))
+}
@@ -0,0 +1,101 @@
+package togha
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter_concurrency(t *testing.T) {
+	ctx := context.Background()
+
+	limiter, err := NewLimiter(&LimitConfig{MaxConcurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var current, max, maxQueue int
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		if v := limiter.QueueDepth(); v > maxQueue {
+			maxQueue = v
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		current--
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var receivers []*ReceiverRepo
+	for i := 0; i < 8; i++ {
+		receivers = append(receivers, &ReceiverRepo{Owner: "vvakame", Name: fmt.Sprintf("repo%d", i)})
+	}
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient:  client,
+		ReceiverRepos: receivers,
+		Limiter:       limiter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := dsp.Dispatch(ctx, &testDispatchRequest{eventType: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := len(res.Receivers); v != 8 {
+		t.Errorf("unexpected receivers len: %d", v)
+	}
+	if max != 2 {
+		t.Errorf("unexpected max concurrency: %d", max)
+	}
+	if maxQueue == 0 {
+		t.Errorf("queue depth is never reported")
+	}
+	if v := limiter.QueueDepth(); v != 0 {
+		t.Errorf("unexpected queue depth after dispatch: %d", v)
+	}
+	if v := limiter.InFlight(); v != 0 {
+		t.Errorf("unexpected in-flight after dispatch: %d", v)
+	}
+}
+
+func TestLimiter_rate(t *testing.T) {
+	limiter, err := NewLimiter(&LimitConfig{RatePerSecond: 50, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		release, err := limiter.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	// 1st call uses the burst, others wait 20ms each.
+	if v := time.Since(start); v < 70*time.Millisecond {
+		t.Errorf("too fast: %s", v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, _ = limiter.acquire(context.Background())
+	_, err = limiter.acquire(ctx)
+	if err == nil {
+		t.Error("unexpected success over deadline")
+	}
+}
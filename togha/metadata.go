@@ -0,0 +1,68 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/vvakame/se2gha/tracing"
+)
+
+// MetadataKey is a top-level key of repository_dispatch client_payload which se2gha adds.
+// workflows read it as github.event.client_payload.se2gha .
+const MetadataKey = "se2gha"
+
+// Metadata describes the dispatch itself, not the event.
+type Metadata struct {
+	// TraceID correlates the workflow run with the trace of the inbound event.
+	TraceID string `json:"trace_id,omitempty"`
+	// TraceParent is W3C traceparent of the dispatch span. workflows can continue the trace with it.
+	TraceParent string `json:"traceparent,omitempty"`
+	// DeliveryID identifies the dispatch. it is set with callbacks.
+	DeliveryID string `json:"delivery_id,omitempty"`
+	// CallbackURL and CallbackToken let workflows report back to the source. see CallbackHandler.
+	CallbackURL   string `json:"callback_url,omitempty"`
+	CallbackToken string `json:"callback_token,omitempty"`
+}
+
+func metadataFromContext(ctx context.Context) *Metadata {
+	return &Metadata{
+		TraceID:     tracing.TraceID(ctx),
+		TraceParent: tracing.TraceParent(ctx),
+	}
+}
+
+func (md *Metadata) empty() bool {
+	return md == nil || *md == Metadata{}
+}
+
+// withMetadata adds md to payload under MetadataKey.
+// payload is returned as is if it has no room for another key, or already has MetadataKey.
+func withMetadata(payload json.RawMessage, md *Metadata) json.RawMessage {
+	if md.empty() {
+		return payload
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return payload
+	}
+	if _, ok := fields[MetadataKey]; ok || len(fields) >= maxClientPayloadKeys {
+		return payload
+	}
+
+	b, err := json.Marshal(md)
+	if err != nil {
+		return payload
+	}
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+	}
+	fields[MetadataKey] = b
+
+	b, err = json.Marshal(fields)
+	if err != nil {
+		return payload
+	}
+
+	return b
+}
@@ -0,0 +1,39 @@
+package togha
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func Test_withMetadata(t *testing.T) {
+	md := &Metadata{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"}
+	full := map[string]int{}
+	for i := 0; i < maxClientPayloadKeys; i++ {
+		full[fmt.Sprintf("k%d", i)] = i
+	}
+	fullJSON, err := json.Marshal(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		md      *Metadata
+		want    string
+	}{
+		{"added", `{"a":1}`, md, `{"a":1,"se2gha":{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}}`},
+		{"empty payload", `{}`, md, `{"se2gha":{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}}`},
+		{"no trace", `{"a":1}`, &Metadata{}, `{"a":1}`},
+		{"already exists", `{"se2gha":"mine"}`, md, `{"se2gha":"mine"}`},
+		{"no room", string(fullJSON), md, string(fullJSON)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(withMetadata(json.RawMessage(tt.payload), tt.md)); got != tt.want {
+				t.Errorf("withMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package togha
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxEventTypeLength is the upper limit of repository_dispatch event_type.
+	maxEventTypeLength = 100
+	// maxClientPayloadKeys is the upper limit of top-level keys in repository_dispatch client_payload.
+	maxClientPayloadKeys = 10
+	// wrappedPayloadKey holds the original payload when PayloadOverflowWrap is applied.
+	wrappedPayloadKey = "payload"
+)
+
+// PayloadOverflowPolicy decides what to do with client_payload which has too many top-level keys.
+type PayloadOverflowPolicy string
+
+const (
+	// PayloadOverflowReject fails the dispatch.
+	PayloadOverflowReject PayloadOverflowPolicy = "reject"
+	// PayloadOverflowWrap moves the whole payload under "payload" key.
+	PayloadOverflowWrap PayloadOverflowPolicy = "wrap"
+)
+
+// NormalizeConfig controls validation and normalization before dispatch.
+type NormalizeConfig struct {
+	// EventTypePrefix is prepended to every event type. e.g. "se2gha-"
+	EventTypePrefix string
+	// PayloadOverflow is PayloadOverflowReject if empty.
+	PayloadOverflow PayloadOverflowPolicy
+}
+
+// ValidationError means the event never be accepted by GitHub. retrying it is pointless.
+type ValidationError struct {
+	EventType string
+	Reason    string
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("invalid event %s: %s", err.EventType, err.Reason)
+}
+
+func normalizeConfigFromEnv() (*NormalizeConfig, error) {
+	cfg := &NormalizeConfig{
+		EventTypePrefix: os.Getenv("GHA_EVENT_TYPE_PREFIX"),
+		PayloadOverflow: PayloadOverflowPolicy(os.Getenv("GHA_PAYLOAD_OVERFLOW")),
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate checks EventTypePrefix and PayloadOverflow.
+func (cfg *NormalizeConfig) Validate() error {
+	if v := cfg.EventTypePrefix; v != "" {
+		if SanitizeEventType(v) != v {
+			return fmt.Errorf("event type prefix contains invalid characters: %s", v)
+		}
+		if len(v) > maxEventTypeLength/2 {
+			return fmt.Errorf("event type prefix is too long: %s", v)
+		}
+	}
+	switch cfg.PayloadOverflow {
+	case "", PayloadOverflowReject, PayloadOverflowWrap:
+	default:
+		return fmt.Errorf("unknown payload overflow policy: %s", cfg.PayloadOverflow)
+	}
+
+	return nil
+}
+
+// SanitizeEventType escapes characters which are awkward in workflow files.
+// the escape is reversible, so different event types never get the same name.
+//   - [A-Za-z0-9_-] are kept
+//   - other ASCII characters, including ".", become "." + 2 hex digits. e.g. "+1" → ".2b1"
+//   - non-ASCII characters become ".u" + 4 hex digits, or ".U" + 6 hex digits out of BMP. e.g. "あ" → ".u3042"
+//   - bytes of invalid UTF-8 become "." + 2 hex digits. they are 80 or more, so never mixed up with ASCII
+func SanitizeEventType(s string) string {
+	var buf strings.Builder
+	for len(s) != 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == '-':
+			buf.WriteRune(r)
+		case r < utf8.RuneSelf:
+			fmt.Fprintf(&buf, ".%02x", r)
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&buf, ".%02x", s[0])
+		case r <= 0xffff:
+			fmt.Fprintf(&buf, ".u%04x", r)
+		default:
+			fmt.Fprintf(&buf, ".U%06x", r)
+		}
+		s = s[size:]
+	}
+
+	return buf.String()
+}
+
+// eventType applies prefix and shortens it to fit within maxEventTypeLength.
+// a shortened name ends with a hash of the full name, so different names never collide.
+func (cfg *NormalizeConfig) eventType(sanitized string) string {
+	s := cfg.EventTypePrefix + sanitized
+	if len(s) <= maxEventTypeLength {
+		return s
+	}
+
+	sum := sha256.Sum256([]byte(s))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+
+	return s[:maxEventTypeLength-len(suffix)] + suffix
+}
+
+// payload checks client_payload limits and applies PayloadOverflow policy.
+func (cfg *NormalizeConfig) payload(eventType string, payload json.RawMessage) (json.RawMessage, error) {
+	if len(payload) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(payload, &fields)
+	if err != nil {
+		return nil, &ValidationError{EventType: eventType, Reason: fmt.Sprintf("client_payload must be JSON object: %s", err.Error())}
+	}
+	if len(fields) <= maxClientPayloadKeys {
+		return payload, nil
+	}
+
+	switch cfg.PayloadOverflow {
+	case PayloadOverflowWrap:
+		b, err := json.Marshal(map[string]json.RawMessage{wrappedPayloadKey: payload})
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+
+	default:
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, &ValidationError{
+			EventType: eventType,
+			Reason:    fmt.Sprintf("client_payload has %d top-level keys, max is %d: %s", len(fields), maxClientPayloadKeys, strings.Join(keys, ", ")),
+		}
+	}
+}
+
+func isValidationError(err error) bool {
+	var vErr *ValidationError
+	return errors.As(err, &vErr)
+}
+
+// isPermanentError reports whether retrying err is pointless.
+// DispatchError is permanent only when every failed receiver is, otherwise retries may reach the rest.
+func isPermanentError(err error) bool {
+	var dErr *DispatchError
+	if errors.As(err, &dErr) {
+		for _, rr := range dErr.Failures {
+			if !isValidationError(rr.Err) {
+				return false
+			}
+		}
+		return len(dErr.Failures) != 0
+	}
+
+	return isValidationError(err)
+}
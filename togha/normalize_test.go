@@ -0,0 +1,125 @@
+package togha
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeEventType(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"slack-event-reaction_added-create-issue", "slack-event-reaction_added-create-issue"},
+		{"slack-event-reaction_added-+1", "slack-event-reaction_added-.2b1"},
+		{"slack-event-reaction_added--1", "slack-event-reaction_added--1"},
+		{"slack-event-reaction_added-wave::skin-tone-2", "slack-event-reaction_added-wave.3a.3askin-tone-2"},
+		{"slack-event-reaction_added-あ", "slack-event-reaction_added-.u3042"},
+		{"slack-event-reaction_added-😀", "slack-event-reaction_added-.U01f600"},
+		{"slack-event-block_actions-v1.2", "slack-event-block_actions-v1.2e2"},
+		{"invalid-\xff", "invalid-.ff"},
+		{"kintone-event-ADD_RECORD", "kintone-event-ADD_RECORD"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.s, func(t *testing.T) {
+			t.Parallel()
+
+			if got := SanitizeEventType(tt.s); got != tt.want {
+				t.Errorf("SanitizeEventType() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeEventType_distinct(t *testing.T) {
+	// each group used to be sanitized into one name.
+	groups := [][]string{
+		{"+1", "plus1", ".2b1"},
+		{"a:b", "a-b", "a.b", "a/b"},
+		{"wave::skin-tone-2", "wave--skin-tone-2", "wave:-skin-tone-2", "wave-:skin-tone-2"},
+		{"あ", "u3042", ".u3042"},
+		{"\xff", "\ufffd", ".ff"},
+	}
+	for _, group := range groups {
+		seen := map[string]string{}
+		for _, s := range group {
+			got := SanitizeEventType(s)
+			if prev, ok := seen[got]; ok {
+				t.Errorf("%q and %q are both sanitized into %q", prev, s, got)
+			}
+			seen[got] = s
+		}
+	}
+}
+
+func TestNormalizeConfig_eventType(t *testing.T) {
+	cfg := &NormalizeConfig{EventTypePrefix: "se2gha-"}
+
+	if got := cfg.eventType("slack-event-foo"); got != "se2gha-slack-event-foo" {
+		t.Errorf("unexpected: %s", got)
+	}
+
+	long1 := cfg.eventType(strings.Repeat("a", 120) + "1")
+	long2 := cfg.eventType(strings.Repeat("a", 120) + "2")
+	if len(long1) != maxEventTypeLength {
+		t.Errorf("unexpected length: %d", len(long1))
+	}
+	if long1 == long2 {
+		t.Errorf("shortened names must not collide: %s", long1)
+	}
+	if long1 != cfg.eventType(strings.Repeat("a", 120)+"1") {
+		t.Errorf("shortening must be deterministic")
+	}
+}
+
+func TestNormalizeConfig_payload(t *testing.T) {
+	var overflow = json.RawMessage(`{"a":1,"b":1,"c":1,"d":1,"e":1,"f":1,"g":1,"h":1,"i":1,"j":1,"k":1}`)
+
+	_, err := (&NormalizeConfig{}).payload("test", overflow)
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := (&NormalizeConfig{PayloadOverflow: PayloadOverflowWrap}).payload("test", overflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(got, &wrapped); err != nil {
+		t.Fatal(err)
+	}
+	if len(wrapped) != 1 || string(wrapped[wrappedPayloadKey]) != string(overflow) {
+		t.Errorf("unexpected wrapped payload: %s", string(got))
+	}
+
+	_, err = (&NormalizeConfig{}).payload("test", json.RawMessage(`[]`))
+	if !errors.As(err, &vErr) {
+		t.Errorf("non object payload must be rejected: %v", err)
+	}
+}
+
+func Test_isPermanentError(t *testing.T) {
+	invalid := &ValidationError{EventType: "test-event", Reason: "unknown target kind: check_run"}
+	down := errors.New("github is down")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"validation", invalid, true},
+		{"transient", down, false},
+		{"every receiver invalid", &DispatchError{Failures: []*ReceiverResult{{Err: invalid}, {Err: invalid}}, Total: 2}, true},
+		{"some receivers transient", &DispatchError{Failures: []*ReceiverResult{{Err: invalid}, {Err: down}}, Total: 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := isPermanentError(tt.err); v != tt.want {
+				t.Errorf("unexpected: %v", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,547 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vvakame/se2gha/log"
+	"github.com/vvakame/se2gha/metrics"
+	"github.com/vvakame/se2gha/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ DispatchRequest = (*StoredRequest)(nil)
+var _ SourceDescriber = (*StoredRequest)(nil)
+var _ IdempotencyKeyer = (*StoredRequest)(nil)
+var _ SourceBodyer = (*StoredRequest)(nil)
+var _ CallbackTarget = (*StoredRequest)(nil)
+var _ ReplyTarget = (*StoredRequest)(nil)
+var _ DeliveredReceivers = (*StoredRequest)(nil)
+var _ Deferrer = (*OutboxEventDispatcher)(nil)
+
+// StoredRequest is a serializable snapshot of DispatchRequest.
+type StoredRequest struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"event_type"`
+	ClientPayload json.RawMessage `json:"client_payload"`
+	EnqueuedAt    time.Time       `json:"enqueued_at"`
+
+	SourceName string            `json:"source,omitempty"`
+	Attributes map[string]string `json:"source_attributes,omitempty"`
+	Key        string            `json:"idempotency_key,omitempty"`
+	Body       string            `json:"source_body,omitempty"`
+	Ref        map[string]string `json:"callback_ref,omitempty"`
+	Reply      map[string]string `json:"reply_ref,omitempty"`
+	// Unresolved is true while the event is not built yet. see OutboxEventDispatcher.Defer.
+	Unresolved bool `json:"unresolved,omitempty"`
+	// TraceParent is W3C traceparent of the request which enqueued the event. deliveries continue the trace.
+	TraceParent string `json:"traceparent,omitempty"`
+
+	// DeliveredTo is receivers which already got the event. retries skip them.
+	DeliveredTo   []string  `json:"delivered_to,omitempty"`
+	Attempts      int       `json:"attempts,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+func NewStoredRequest(req DispatchRequest) (*StoredRequest, error) {
+	if req, ok := req.(*StoredRequest); ok {
+		copied := *req
+		return &copied, nil
+	}
+
+	payload, err := req.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	return newStoredRequest(req, payload)
+}
+
+func newStoredRequest(req DispatchRequest, payload json.RawMessage) (*StoredRequest, error) {
+	eventType, err := req.EventType()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	id, err := newSpoolID(now)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &StoredRequest{
+		ID:            id,
+		Type:          eventType,
+		ClientPayload: payload,
+		EnqueuedAt:    now,
+	}
+	if sd, ok := req.(SourceDescriber); ok {
+		stored.SourceName = sd.Source()
+		stored.Attributes = sd.SourceAttributes()
+	}
+	if keyer, ok := req.(IdempotencyKeyer); ok {
+		stored.Key = keyer.IdempotencyKey()
+	}
+	if bodyer, ok := req.(SourceBodyer); ok {
+		stored.Body = string(bodyer.SourceBody())
+	}
+	if target, ok := req.(CallbackTarget); ok {
+		stored.Ref = target.CallbackRef()
+	}
+	if target, ok := req.(ReplyTarget); ok {
+		stored.Reply = target.ReplyRef()
+	}
+
+	return stored, nil
+}
+
+func (req *StoredRequest) EventType() (string, error) {
+	return req.Type, nil
+}
+
+func (req *StoredRequest) Payload() (json.RawMessage, error) {
+	return req.ClientPayload, nil
+}
+
+func (req *StoredRequest) Source() string {
+	return req.SourceName
+}
+
+func (req *StoredRequest) SourceAttributes() map[string]string {
+	return req.Attributes
+}
+
+// IdempotencyKey falls back to ID, so retries of the event are deduplicated even if the source has no key.
+func (req *StoredRequest) IdempotencyKey() string {
+	if req.Key == "" {
+		return "outbox:" + req.ID
+	}
+
+	return req.Key
+}
+
+func (req *StoredRequest) SourceBody() []byte {
+	return []byte(req.Body)
+}
+
+func (req *StoredRequest) CallbackRef() map[string]string {
+	return req.Ref
+}
+
+func (req *StoredRequest) ReplyRef() map[string]string {
+	return req.Reply
+}
+
+func (req *StoredRequest) Delivered(receiver string) bool {
+	for _, v := range req.DeliveredTo {
+		if v == receiver {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordDelivered adds receivers which succeeded in res to DeliveredTo.
+func (req *StoredRequest) recordDelivered(res *DispatchResult) {
+	if res == nil {
+		return
+	}
+	for _, rr := range res.Receivers {
+		if rr.Succeeded() && !req.Delivered(rr.Repo) {
+			req.DeliveredTo = append(req.DeliveredTo, rr.Repo)
+		}
+	}
+}
+
+// Resolver builds the event of a request which is deferred by the source. see OutboxEventDispatcher.Defer.
+type Resolver interface {
+	// Resolve returns nil if the event should not be dispatched.
+	Resolve(ctx context.Context, req *StoredRequest) (DispatchRequest, error)
+}
+
+// ReplyTarget is implemented by DispatchRequest which tells the result to the user who sent it.
+// e.g. response_url of slack. it is kept in the outbox for DeliveryObserver, and never sent to receivers.
+type ReplyTarget interface {
+	ReplyRef() map[string]string
+}
+
+// DeliveryObserver is told the final result of each event, when it is delivered or given up.
+type DeliveryObserver interface {
+	Delivered(ctx context.Context, req *StoredRequest, res *DispatchResult, err error)
+}
+
+// Deferrer is implemented by EventDispatcher which can build events in background.
+type Deferrer interface {
+	// Defer enqueues req before its payload is built. Payload of req is not called.
+	Defer(ctx context.Context, req DispatchRequest) (*DispatchResult, error)
+}
+
+// DefaultOutboxMaxAttempts gives up an event after about 2 days with default intervals.
+const DefaultOutboxMaxAttempts = 300
+
+type OutboxConfig struct {
+	// Dir is a directory to persist queued events.
+	Dir string
+	// Dispatcher delivers queued events to GitHub.
+	Dispatcher EventDispatcher
+	// PollInterval is how often the worker scans Dir for events waiting for retry.
+	PollInterval time.Duration
+	// RetryInterval is the wait before a failed event is delivered again. it doubles on each failure.
+	RetryInterval time.Duration
+	// MaxRetryInterval caps RetryInterval.
+	MaxRetryInterval time.Duration
+	// MaxAttempts is the upper bound of deliveries. default is DefaultOutboxMaxAttempts, negative means unlimited.
+	MaxAttempts int
+	// Concurrency is how many events are delivered at once, so an event waiting for retries does not block others.
+	// default is DefaultLimitConfig.MaxConcurrency. GitHub API calls are still bounded by the Limiter of Dispatcher.
+	Concurrency int
+	// DeadLetters keeps events which are given up. optional.
+	DeadLetters *DeadLetterStore
+	// Resolver builds events enqueued by Defer. Defer fails without it.
+	Resolver Resolver
+	// Observer is told final results, e.g. to give feedback in the source. optional.
+	Observer DeliveryObserver
+}
+
+// OutboxEventDispatcher persists events and returns immediately.
+// a background worker delivers them to the wrapped EventDispatcher.
+type OutboxEventDispatcher struct {
+	cfg    *OutboxConfig
+	spool  *fileSpool
+	notify chan struct{}
+
+	// sem bounds deliveries by Concurrency. delivering has IDs in delivery, the worker skips them on next scan.
+	sem        chan struct{}
+	mu         sync.Mutex
+	delivering map[string]bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewOutboxEventDispatcher(ctx context.Context, cfg *OutboxConfig) (*OutboxEventDispatcher, error) {
+	if cfg == nil {
+		return nil, errors.New("cfg is required")
+	}
+	if cfg.Dispatcher == nil {
+		return nil, errors.New("Dispatcher is required")
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 10 * time.Second
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 10 * time.Second
+	}
+	if cfg.MaxRetryInterval <= 0 {
+		cfg.MaxRetryInterval = 10 * time.Minute
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = DefaultOutboxMaxAttempts
+	}
+	if cfg.Concurrency < 0 {
+		return nil, fmt.Errorf("invalid concurrency: %d", cfg.Concurrency)
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = DefaultLimitConfig.MaxConcurrency
+	}
+
+	spool, err := newFileSpool(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	dsp := &OutboxEventDispatcher{
+		cfg:        cfg,
+		spool:      spool,
+		notify:     make(chan struct{}, 1),
+		sem:        make(chan struct{}, cfg.Concurrency),
+		delivering: make(map[string]bool),
+		cancel:     cancel,
+	}
+
+	dsp.wg.Add(1)
+	go func() {
+		defer dsp.wg.Done()
+		dsp.run(ctx)
+	}()
+
+	return dsp, nil
+}
+
+func (dsp *OutboxEventDispatcher) Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
+	stored, err := NewStoredRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return dsp.enqueue(ctx, stored)
+}
+
+// Defer enqueues req with its source body, and Resolver builds the event in the worker.
+// sources can acknowledge events without waiting for their own APIs.
+func (dsp *OutboxEventDispatcher) Defer(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
+	if dsp.cfg.Resolver == nil {
+		return nil, errors.New("outbox has no Resolver")
+	}
+
+	stored, err := newStoredRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if stored.SourceName == "" || stored.Body == "" {
+		return nil, errors.New("deferred request must have source and source body")
+	}
+	stored.Unresolved = true
+
+	return dsp.enqueue(ctx, stored)
+}
+
+func (dsp *OutboxEventDispatcher) enqueue(ctx context.Context, stored *StoredRequest) (*DispatchResult, error) {
+	if stored.TraceParent == "" {
+		stored.TraceParent = tracing.TraceParent(ctx)
+	}
+
+	err := dsp.spool.Put(stored.ID, stored)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf(ctx, "event enqueued: %s, %s", stored.ID, stored.Type)
+
+	select {
+	case dsp.notify <- struct{}{}:
+	default:
+	}
+
+	return &DispatchResult{
+		EventType: stored.Type,
+		Queued:    true,
+	}, nil
+}
+
+// Len returns the number of queued events including ones waiting for retry.
+func (dsp *OutboxEventDispatcher) Len() (int, error) {
+	ids, err := dsp.spool.List()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// Close stops the background worker and waits for deliveries. queued events are kept and delivered after next start.
+func (dsp *OutboxEventDispatcher) Close() error {
+	dsp.cancel()
+	dsp.wg.Wait()
+
+	return nil
+}
+
+func (dsp *OutboxEventDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(dsp.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		dsp.deliverAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-dsp.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (dsp *OutboxEventDispatcher) deliverAll(ctx context.Context) {
+	ids, err := dsp.spool.List()
+	if err != nil {
+		log.Errorf(ctx, "outbox list failed: %s", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		if dsp.isDelivering(id) {
+			continue
+		}
+
+		req := &StoredRequest{}
+		err := dsp.spool.Get(id, req)
+		if errors.Is(err, os.ErrNotExist) {
+			// delivered after List.
+			continue
+		} else if err != nil {
+			log.Warnf(ctx, "outbox read failed: %s, %s", id, err.Error())
+			continue
+		}
+		if now.Before(req.NextAttemptAt) {
+			continue
+		}
+
+		select {
+		case dsp.sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		id := id
+		dsp.mu.Lock()
+		dsp.delivering[id] = true
+		dsp.mu.Unlock()
+
+		dsp.wg.Add(1)
+		go func() {
+			defer dsp.wg.Done()
+			defer func() {
+				dsp.mu.Lock()
+				delete(dsp.delivering, id)
+				dsp.mu.Unlock()
+				<-dsp.sem
+			}()
+			dsp.deliver(ctx, req)
+		}()
+	}
+}
+
+func (dsp *OutboxEventDispatcher) isDelivering(id string) bool {
+	dsp.mu.Lock()
+	defer dsp.mu.Unlock()
+
+	return dsp.delivering[id]
+}
+
+func (dsp *OutboxEventDispatcher) deliver(ctx context.Context, req *StoredRequest) {
+	ctx, span := tracing.Start(tracing.WithTraceParent(ctx, req.TraceParent), "outbox.deliver", trace.WithAttributes(
+		attribute.String("se2gha.outbox.id", req.ID),
+		attribute.Int("se2gha.outbox.attempts", req.Attempts+1),
+	))
+	if req.Unresolved {
+		resolved, err := dsp.resolve(ctx, req)
+		if err != nil {
+			tracing.End(span, err)
+			dsp.failed(ctx, req, nil, err)
+			return
+		}
+		if resolved == nil {
+			span.End()
+			log.Debugf(ctx, "outbox resolved nothing to dispatch: %s, %s", req.ID, req.Type)
+			metrics.ObserveOutboxDelivery("dropped")
+			if err := dsp.spool.Remove(req.ID); err != nil {
+				log.Warnf(ctx, "outbox remove failed: %s, %s", req.ID, err.Error())
+			}
+			return
+		}
+		req = resolved
+	}
+
+	// receivers already succeeded are skipped by DeliveredTo on retry.
+	res, err := dsp.cfg.Dispatcher.Dispatch(ctx, req)
+	tracing.End(span, err)
+	if err == nil {
+		log.Debugf(ctx, "outbox delivered: %s, %s", req.ID, req.Type)
+		metrics.ObserveOutboxDelivery("delivered")
+		dsp.observe(ctx, req, res, nil)
+		if err := dsp.spool.Remove(req.ID); err != nil {
+			log.Warnf(ctx, "outbox remove failed: %s, %s", req.ID, err.Error())
+		}
+		return
+	}
+	dsp.failed(ctx, req, res, err)
+}
+
+func (dsp *OutboxEventDispatcher) observe(ctx context.Context, req *StoredRequest, res *DispatchResult, err error) {
+	if dsp.cfg.Observer == nil {
+		return
+	}
+
+	dsp.cfg.Observer.Delivered(ctx, req, res, err)
+}
+
+// resolve builds the event of req by Resolver, and persists it so retries do not resolve it again.
+func (dsp *OutboxEventDispatcher) resolve(ctx context.Context, req *StoredRequest) (*StoredRequest, error) {
+	if dsp.cfg.Resolver == nil {
+		return nil, errors.New("outbox has no Resolver")
+	}
+	r, err := dsp.cfg.Resolver.Resolve(ctx, req)
+	if err != nil || r == nil {
+		return nil, err
+	}
+
+	resolved, err := NewStoredRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	resolved.ID = req.ID
+	resolved.EnqueuedAt = req.EnqueuedAt
+	resolved.TraceParent = req.TraceParent
+	resolved.Attempts = req.Attempts
+	resolved.Unresolved = false
+	if err := dsp.spool.Put(resolved.ID, resolved); err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+// failed schedules a retry of req, or gives it up.
+func (dsp *OutboxEventDispatcher) failed(ctx context.Context, req *StoredRequest, res *DispatchResult, err error) {
+	if ctx.Err() != nil {
+		// shutting down. try again after restart, without sending the event to receivers which already got it.
+		// the attempt is not counted, it is not a failure of the event.
+		req.recordDelivered(res)
+		if err := dsp.spool.Put(req.ID, req); err != nil {
+			log.Warnf(ctx, "outbox update failed: %s, %s", req.ID, err.Error())
+		}
+		return
+	}
+	if isPermanentError(err) {
+		log.Warnf(ctx, "outbox dropped invalid event: %s, %s", req.ID, err.Error())
+		metrics.ObserveOutboxDelivery("dropped")
+		dsp.observe(ctx, req, res, err)
+		dsp.cfg.DeadLetters.save(ctx, req, res, err)
+		if err := dsp.spool.Remove(req.ID); err != nil {
+			log.Warnf(ctx, "outbox remove failed: %s, %s", req.ID, err.Error())
+		}
+		return
+	}
+
+	req.recordDelivered(res)
+	req.Attempts++
+	req.LastError = err.Error()
+	if dsp.cfg.MaxAttempts > 0 && req.Attempts >= dsp.cfg.MaxAttempts {
+		log.Errorf(ctx, "outbox gave up: %s, %s, %s", req.ID, req.Type, err.Error())
+		metrics.ObserveOutboxDelivery("gave_up")
+		dsp.observe(ctx, req, res, err)
+		dsp.cfg.DeadLetters.save(ctx, req, res, err)
+		if err := dsp.spool.Remove(req.ID); err != nil {
+			log.Warnf(ctx, "outbox remove failed: %s, %s", req.ID, err.Error())
+		}
+		return
+	}
+
+	interval := dsp.cfg.RetryInterval
+	for i := 1; i < req.Attempts && interval < dsp.cfg.MaxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > dsp.cfg.MaxRetryInterval {
+		interval = dsp.cfg.MaxRetryInterval
+	}
+	req.NextAttemptAt = time.Now().Add(interval)
+	log.Warnf(ctx, "outbox delivery failed, retry after %s: %s, %s, %s", interval, req.ID, req.Type, err.Error())
+	metrics.ObserveOutboxDelivery("retry")
+
+	if err := dsp.spool.Put(req.ID, req); err != nil {
+		log.Warnf(ctx, "outbox update failed: %s, %s", req.ID, err.Error())
+	}
+}
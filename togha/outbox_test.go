@@ -0,0 +1,445 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testDispatchRequest struct {
+	eventType string
+	payload   json.RawMessage
+}
+
+func (req *testDispatchRequest) EventType() (string, error) {
+	return req.eventType, nil
+}
+
+func (req *testDispatchRequest) Payload() (json.RawMessage, error) {
+	return req.payload, nil
+}
+
+type recordingDispatcher struct {
+	mu       sync.Mutex
+	err      error
+	received []string
+	done     chan struct{}
+}
+
+func (dsp *recordingDispatcher) Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
+	dsp.mu.Lock()
+	defer dsp.mu.Unlock()
+
+	if dsp.err != nil {
+		return nil, dsp.err
+	}
+	eventType, _ := req.EventType()
+	dsp.received = append(dsp.received, eventType)
+	if dsp.done != nil {
+		dsp.done <- struct{}{}
+	}
+
+	return &DispatchResult{EventType: eventType}, nil
+}
+
+func TestOutboxEventDispatcher_survivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	failing := &recordingDispatcher{err: errors.New("github is down")}
+	outbox, err := NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:           dir,
+		Dispatcher:    failing,
+		RetryInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = outbox.Dispatch(ctx, &testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = outbox.Close()
+
+	ok := &recordingDispatcher{done: make(chan struct{}, 1)}
+	outbox, err = NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:        dir,
+		Dispatcher: ok,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+
+	// NextAttemptAt is in the future, wake the worker up by hand after rewinding it.
+	ids, err := outbox.spool.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("unexpected queue length: %d", len(ids))
+	}
+	req := &StoredRequest{}
+	if err := outbox.spool.Get(ids[0], req); err != nil {
+		t.Fatal(err)
+	}
+	req.NextAttemptAt = time.Time{}
+	if err := outbox.spool.Put(req.ID, req); err != nil {
+		t.Fatal(err)
+	}
+	outbox.notify <- struct{}{}
+
+	select {
+	case <-ok.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued event is not delivered")
+	}
+	if v := ok.received; len(v) != 1 || v[0] != "test-event" {
+		t.Errorf("unexpected received: %v", v)
+	}
+}
+
+func TestOutboxEventDispatcher_skipsDelivered(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	down := true
+	var received []string
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down && r.URL.Path == "/repos/vvakame/flaky/dispatches" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received = append(received, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// a new dispatcher per start, so the in-memory dedup store is lost like a restart.
+	newDispatcher := func() EventDispatcher {
+		dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+			GitHubClient: client,
+			ReceiverRepos: []*ReceiverRepo{
+				{Owner: "vvakame", Name: "se2gha"},
+				{Owner: "vvakame", Name: "flaky"},
+			},
+			Retry: &RetryConfig{MaxAttempts: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dsp
+	}
+	waitSpool := func(outbox *OutboxEventDispatcher, f func(ids []string) bool) {
+		for i := 0; ; i++ {
+			ids, err := outbox.spool.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f(ids) {
+				return
+			}
+			if i > 100 {
+				t.Fatal("timeout")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	outbox, err := NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:           dir,
+		Dispatcher:    newDispatcher(),
+		RetryInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = outbox.Dispatch(ctx, &testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &StoredRequest{}
+	waitSpool(outbox, func(ids []string) bool {
+		return len(ids) == 1 && outbox.spool.Get(ids[0], req) == nil && req.Attempts == 1
+	})
+	_ = outbox.Close()
+	if v := strings.Join(req.DeliveredTo, ","); v != "vvakame/se2gha" {
+		t.Errorf("unexpected delivered: %s", v)
+	}
+
+	mu.Lock()
+	down = false
+	received = nil
+	mu.Unlock()
+
+	outbox, err = NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:        dir,
+		Dispatcher: newDispatcher(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+
+	req.NextAttemptAt = time.Time{}
+	if err := outbox.spool.Put(req.ID, req); err != nil {
+		t.Fatal(err)
+	}
+	outbox.notify <- struct{}{}
+	waitSpool(outbox, func(ids []string) bool {
+		return len(ids) == 0
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if v := strings.Join(received, ","); v != "/repos/vvakame/flaky/dispatches" {
+		t.Errorf("unexpected received: %s", v)
+	}
+}
+
+// stuckDispatcher delivers "stuck" event to one receiver, and waits for the shutdown before the other.
+type stuckDispatcher struct {
+	stuck     chan struct{}
+	delivered chan string
+}
+
+func (dsp *stuckDispatcher) Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
+	eventType, _ := req.EventType()
+	if eventType != "stuck" {
+		dsp.delivered <- eventType
+		return &DispatchResult{EventType: eventType}, nil
+	}
+
+	dsp.stuck <- struct{}{}
+	<-ctx.Done()
+	res := &DispatchResult{
+		EventType: eventType,
+		Receivers: []*ReceiverResult{{Repo: "vvakame/se2gha"}, {Repo: "vvakame/slow", Err: ctx.Err()}},
+	}
+	return res, res.Err()
+}
+
+func TestOutboxEventDispatcher_stuckEvent(t *testing.T) {
+	ctx := context.Background()
+
+	dsp := &stuckDispatcher{stuck: make(chan struct{}, 1), delivered: make(chan string, 1)}
+	outbox, err := NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:        t.TempDir(),
+		Dispatcher: dsp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := outbox.Dispatch(ctx, &testDispatchRequest{eventType: "stuck", payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-dsp.stuck
+
+	// other events are delivered while the stuck event waits.
+	_, err = outbox.Dispatch(ctx, &testDispatchRequest{eventType: "next", payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-dsp.delivered:
+		if v != "next" {
+			t.Errorf("unexpected delivered: %s", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked by the stuck event")
+	}
+
+	// the stuck event is kept with receivers which already got it.
+	_ = outbox.Close()
+	ids, err := outbox.spool.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("unexpected queued: %d", len(ids))
+	}
+	req := &StoredRequest{}
+	if err := outbox.spool.Get(ids[0], req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Type != res.EventType || req.Attempts != 0 {
+		t.Errorf("unexpected request: %s, attempts %d", req.Type, req.Attempts)
+	}
+	if v := strings.Join(req.DeliveredTo, ","); v != "vvakame/se2gha" {
+		t.Errorf("unexpected delivered: %s", v)
+	}
+}
+
+func TestOutboxEventDispatcher_permanentError(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s", r.URL.Path)
+	}))
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient: client,
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha", Target: "check_run"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewDeadLetterStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:          t.TempDir(),
+		Dispatcher:   dsp,
+		PollInterval: 10 * time.Millisecond,
+		DeadLetters:  store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+
+	// the receiver never accepts the event. it is dead-lettered without retries.
+	_, err = outbox.Dispatch(ctx, &testDispatchRequest{eventType: "test-event", payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		dls, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dls) == 1 {
+			if v := dls[0].Error; !strings.Contains(v, "unknown target kind") {
+				t.Errorf("unexpected error: %s", v)
+			}
+			break
+		}
+		if i > 100 {
+			t.Fatal("dead letter is not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, err := outbox.Len(); err != nil || n != 0 {
+		t.Errorf("unexpected queue length: %d, %v", n, err)
+	}
+}
+
+type testResolver struct {
+	mu        sync.Mutex
+	calls     int
+	delivered []string
+}
+
+func (r *testResolver) Delivered(ctx context.Context, req *StoredRequest, res *DispatchResult, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.delivered = append(r.delivered, req.Type)
+}
+
+func (r *testResolver) Resolve(ctx context.Context, req *StoredRequest) (DispatchRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls++
+	if req.Body == "ignore" {
+		return nil, nil
+	}
+
+	return &testDispatchRequest{eventType: req.Type + "-resolved", payload: json.RawMessage(`{"body":"` + req.Body + `"}`)}, nil
+}
+
+func TestOutboxEventDispatcher_Defer(t *testing.T) {
+	ctx := context.Background()
+
+	resolver := &testResolver{}
+	dsp := &recordingDispatcher{done: make(chan struct{}, 1)}
+	outbox, err := NewOutboxEventDispatcher(ctx, &OutboxConfig{
+		Dir:          t.TempDir(),
+		Dispatcher:   dsp,
+		PollInterval: 10 * time.Millisecond,
+		Resolver:     resolver,
+		Observer:     resolver,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+
+	_, err = outbox.Defer(ctx, &testBodyRequest{
+		testDispatchRequest: testDispatchRequest{eventType: "ignored-event"},
+		body:                "ignore",
+	})
+	if err == nil {
+		t.Fatal("request without source should be rejected")
+	}
+
+	for _, body := range []string{"ignore", "raw"} {
+		res, err := outbox.Defer(ctx, &testDeferredRequest{
+			testBodyRequest: testBodyRequest{
+				testDispatchRequest: testDispatchRequest{eventType: "test-event"},
+				body:                body,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Queued {
+			t.Error("result should be queued")
+		}
+	}
+
+	select {
+	case <-dsp.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deferred event is not delivered")
+	}
+	if v := dsp.received; len(v) != 1 || v[0] != "test-event-resolved" {
+		t.Errorf("unexpected received: %v", v)
+	}
+	for i := 0; ; i++ {
+		if n, err := outbox.Len(); err != nil {
+			t.Fatal(err)
+		} else if n == 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("outbox is not drained")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	if resolver.calls != 2 {
+		t.Errorf("unexpected resolve calls: %d", resolver.calls)
+	}
+	if v := resolver.delivered; len(v) != 1 || v[0] != "test-event-resolved" {
+		t.Errorf("unexpected delivered: %v", v)
+	}
+}
+
+type testDeferredRequest struct {
+	testBodyRequest
+}
+
+func (req *testDeferredRequest) Source() string {
+	return "test"
+}
+
+func (req *testDeferredRequest) SourceAttributes() map[string]string {
+	return nil
+}
+
+func (req *testDeferredRequest) Payload() (json.RawMessage, error) {
+	return nil, errors.New("payload of deferred request must not be built")
+}
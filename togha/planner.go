@@ -0,0 +1,130 @@
+package togha
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// dispatchPlanner decides what is sent to which receivers.
+// it is shared by EventDispatcher implementations, so dry-run behaves same as production.
+type dispatchPlanner struct {
+	router    *Router
+	normalize *NormalizeConfig
+}
+
+// newDispatchPlanner builds planner. nil arguments are read from environment variables.
+func newDispatchPlanner(receivers []*ReceiverRepo, rules []*RoutingRule, normalize *NormalizeConfig) (*dispatchPlanner, error) {
+	if receivers == nil {
+		ghaRepos := os.Getenv("GHA_REPOS")
+		if ghaRepos == "" {
+			return nil, errors.New("GHA_REPOS environment variable is required")
+		}
+
+		repos, err := ParseReceiverRepos(ghaRepos)
+		if err != nil {
+			return nil, err
+		}
+
+		receivers = repos
+	}
+	if len(receivers) == 0 {
+		return nil, errors.New("ReceiverRepos requires over 1 item")
+	}
+	if rules == nil {
+		var err error
+		rules, err = routingRulesFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+	router, err := NewRouter(rules, receivers)
+	if err != nil {
+		return nil, err
+	}
+	if normalize == nil {
+		normalize, err = normalizeConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	} else if err := normalize.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &dispatchPlanner{
+		router:    router,
+		normalize: normalize,
+	}, nil
+}
+
+// plan returns normalized event type and payload, and receivers to send.
+func (p *dispatchPlanner) plan(req DispatchRequest) (string, json.RawMessage, []*ReceiverRepo, error) {
+	eventType, err := req.EventType()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	payload, err := req.Payload()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// routing rules are matched against sanitized event type without prefix.
+	sanitized := SanitizeEventType(eventType)
+	if sanitized == "" {
+		return "", nil, nil, &ValidationError{EventType: eventType, Reason: "event type is empty"}
+	}
+	payload, err = p.normalize.payload(sanitized, payload)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var receivers []*ReceiverRepo
+	if t, ok := req.(receiverTargeter); ok && len(t.targetReceivers()) != 0 {
+		receivers, err = p.resolveReceivers(t.targetReceivers())
+		if err != nil {
+			return "", nil, nil, err
+		}
+	} else {
+		receivers = p.router.Route(sanitized, req)
+	}
+
+	return p.normalize.eventType(sanitized), payload, receivers, nil
+}
+
+// receiverTargeter bypasses routing rules. it is used to replay dead letters.
+type receiverTargeter interface {
+	targetReceivers() []string
+}
+
+// resolveReceivers finds receivers by ReceiverRepo.String().
+// a receiver which is not configured is parsed as ParseReceiverRepos format.
+// a GitHub Enterprise Server receiver must be configured, its endpoint and credential are not known by the name.
+func (p *dispatchPlanner) resolveReceivers(names []string) ([]*ReceiverRepo, error) {
+	receivers := make([]*ReceiverRepo, 0, len(names))
+	for _, name := range names {
+		var found *ReceiverRepo
+		for _, receiver := range p.router.receivers {
+			if receiver.String() == name {
+				found = receiver
+				break
+			}
+		}
+		if found == nil {
+			if repo, _, _ := strings.Cut(name, ":"); strings.Count(repo, "/") > 1 {
+				return nil, fmt.Errorf("receiver %s is not configured", name)
+			}
+			repos, err := ParseReceiverRepos(name)
+			if err != nil {
+				return nil, err
+			}
+			if len(repos) != 1 {
+				return nil, fmt.Errorf("invalid receiver: %s", name)
+			}
+			found = repos[0]
+		}
+		receivers = append(receivers, found)
+	}
+
+	return receivers, nil
+}
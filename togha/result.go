@@ -0,0 +1,163 @@
+package togha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vvakame/se2gha/log"
+)
+
+// DispatchResult describes what happened to each receiver.
+type DispatchResult struct {
+	EventType string            `json:"event_type"`
+	Queued    bool              `json:"queued,omitempty"`
+	Receivers []*ReceiverResult `json:"receivers"`
+}
+
+// ReceiverResult is a dispatch outcome for one receiver.
+type ReceiverResult struct {
+	Receiver   *ReceiverRepo `json:"-"`
+	Repo       string        `json:"repo"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Latency    time.Duration `json:"-"`
+	LatencyMS  int64         `json:"latency_ms"`
+	Attempts   int           `json:"attempts"`
+	// Duplicate is true when the receiver already got the event. it counts as succeeded.
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Err       error  `json:"-"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newReceiverResult(receiver *ReceiverRepo) *ReceiverResult {
+	return &ReceiverResult{
+		Receiver: receiver,
+		Repo:     receiver.String(),
+	}
+}
+
+func (rr *ReceiverResult) finish(start time.Time, err error) {
+	rr.Latency = time.Since(start)
+	rr.LatencyMS = rr.Latency.Milliseconds()
+	rr.Err = err
+	if err != nil {
+		rr.Error = err.Error()
+	}
+}
+
+func (rr *ReceiverResult) Succeeded() bool {
+	return rr.Err == nil
+}
+
+// Failed returns results of receivers which did not receive the event.
+func (res *DispatchResult) Failed() []*ReceiverResult {
+	if res == nil {
+		return nil
+	}
+
+	var failed []*ReceiverResult
+	for _, rr := range res.Receivers {
+		if !rr.Succeeded() {
+			failed = append(failed, rr)
+		}
+	}
+
+	return failed
+}
+
+// Err returns *DispatchError if some receivers failed.
+func (res *DispatchResult) Err() error {
+	failed := res.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return &DispatchError{
+		EventType: res.EventType,
+		Failures:  failed,
+		Total:     len(res.Receivers),
+	}
+}
+
+// DispatchError names every receiver which failed to receive the event.
+type DispatchError struct {
+	EventType string
+	Failures  []*ReceiverResult
+	Total     int
+}
+
+func (err *DispatchError) Error() string {
+	ss := make([]string, 0, len(err.Failures))
+	for _, rr := range err.Failures {
+		ss = append(ss, fmt.Sprintf("%s: %s", rr.Repo, rr.Err.Error()))
+	}
+
+	return fmt.Sprintf("dispatch %s failed on %d/%d repos: %s", err.EventType, len(err.Failures), err.Total, strings.Join(ss, "; "))
+}
+
+// Is reports whether some receiver failed with target.
+// go 1.19 errors does not traverse Unwrap() []error, so DispatchError walks the failures by itself.
+func (err *DispatchError) Is(target error) bool {
+	for _, rr := range err.Failures {
+		if errors.Is(rr.Err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first receiver error which matches target.
+func (err *DispatchError) As(target interface{}) bool {
+	for _, rr := range err.Failures {
+		if errors.As(rr.Err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Partial reports whether some receivers succeeded.
+func (err *DispatchError) Partial() bool {
+	return len(err.Failures) < err.Total
+}
+
+// WriteDispatchResult writes a response for the event source.
+// it responds 207 Multi-Status with per-receiver details when only some receivers failed.
+func WriteDispatchResult(ctx context.Context, w http.ResponseWriter, res *DispatchResult, err error) {
+	if err == nil {
+		if res != nil && !res.Queued {
+			log.Debugf(ctx, "dispatched %s to %d repos", res.EventType, len(res.Receivers))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	log.Warnf(ctx, "%s", err)
+
+	if res == nil || len(res.Receivers) == 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	b, jsonErr := json.Marshal(res)
+	if jsonErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	status := http.StatusInternalServerError
+	if dErr, ok := err.(*DispatchError); ok && dErr.Partial() {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,162 @@
+package togha
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/vvakame/se2gha/log"
+)
+
+// RetryConfig controls how a failed GitHub API call is retried.
+type RetryConfig struct {
+	// MaxAttempts is the upper bound of API calls per receiver, including the first one.
+	MaxAttempts int
+	// InitialInterval is the base wait before the 2nd attempt. it doubles on each retry.
+	InitialInterval time.Duration
+	// MaxInterval caps the backoff interval.
+	MaxInterval time.Duration
+	// Deadline is the total time budget per receiver. retrying stops when the next wait exceeds it.
+	Deadline time.Duration
+}
+
+// DefaultRetryConfig is used when EventDispatcherConfig.Retry is nil.
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts:     5,
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     10 * time.Second,
+	Deadline:        30 * time.Second,
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// retry calls f until it succeeds, returns a non retryable error or the budget runs out.
+func (cfg *RetryConfig) retry(ctx context.Context, f func(ctx context.Context) error) error {
+	if cfg.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Deadline)
+		defer cancel()
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f(ctx)
+		if err == nil {
+			return nil
+		}
+		if cfg.MaxAttempts > 0 && attempt >= cfg.MaxAttempts {
+			return err
+		}
+
+		wait, ok := cfg.retryDelay(err, attempt, time.Now())
+		if !ok {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			log.Debugf(ctx, "give up retry, next wait %s exceeds deadline", wait)
+			return err
+		}
+
+		log.Debugf(ctx, "retry after %s (attempt %d): %s", wait, attempt, err.Error())
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// retryDelay returns how long we should wait before the next attempt.
+// ok is false when err is not worth retrying.
+func (cfg *RetryConfig) retryDelay(err error, attempt int, now time.Time) (wait time.Duration, ok bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		// primary rate limit. X-RateLimit-Reset header is parsed by go-github.
+		wait = rateLimitErr.Rate.Reset.Time.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		// secondary rate limit. Retry-After header is parsed by go-github.
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return cfg.backoff(attempt), true
+	}
+
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) {
+		if errResp.Response == nil {
+			return 0, false
+		}
+		switch code := errResp.Response.StatusCode; {
+		case code == http.StatusTooManyRequests, code >= 500:
+			if wait, ok := parseRetryAfter(errResp.Response.Header, now); ok {
+				return wait, true
+			}
+			return cfg.backoff(attempt), true
+		default:
+			return 0, false
+		}
+	}
+
+	// network error or something like that.
+	return cfg.backoff(attempt), true
+}
+
+// backoff returns jittered exponential backoff interval for the attempt.
+func (cfg *RetryConfig) backoff(attempt int) time.Duration {
+	interval := cfg.InitialInterval
+	if interval <= 0 {
+		interval = DefaultRetryConfig.InitialInterval
+	}
+	for i := 1; i < attempt; i++ {
+		interval *= 2
+		if cfg.MaxInterval > 0 && interval >= cfg.MaxInterval {
+			interval = cfg.MaxInterval
+			break
+		}
+	}
+
+	// equal jitter. keep at least half of interval.
+	half := int64(interval / 2)
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(half + jitterRand.Int63n(half+1))
+}
+
+func parseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		wait := t.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
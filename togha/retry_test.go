@@ -0,0 +1,101 @@
+package togha
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+)
+
+func TestRetryConfig_retryDelay(t *testing.T) {
+	now := time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+	retryAfter := 7 * time.Second
+	cfg := &RetryConfig{
+		InitialInterval: 1 * time.Second,
+		MaxInterval:     4 * time.Second,
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		attempt int
+		wantMin time.Duration
+		wantMax time.Duration
+		wantOK  bool
+	}{
+		{
+			name: "primary rate limit",
+			err: &github.RateLimitError{
+				Rate: github.Rate{Reset: github.Timestamp{Time: now.Add(42 * time.Second)}},
+			},
+			attempt: 1,
+			wantMin: 42 * time.Second,
+			wantMax: 42 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "secondary rate limit with Retry-After",
+			err:     &github.AbuseRateLimitError{RetryAfter: &retryAfter},
+			attempt: 1,
+			wantMin: 7 * time.Second,
+			wantMax: 7 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name: "5xx with Retry-After",
+			err: &github.ErrorResponse{Response: &http.Response{
+				StatusCode: http.StatusBadGateway,
+				Header:     http.Header{"Retry-After": []string{"3"}},
+			}},
+			attempt: 1,
+			wantMin: 3 * time.Second,
+			wantMax: 3 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name: "5xx backoff",
+			err: &github.ErrorResponse{Response: &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+			}},
+			attempt: 2,
+			wantMin: 1 * time.Second,
+			wantMax: 2 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "backoff is capped",
+			err:     errors.New("connection reset"),
+			attempt: 10,
+			wantMin: 2 * time.Second,
+			wantMax: 4 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name: "4xx is not retryable",
+			err: &github.ErrorResponse{Response: &http.Response{
+				StatusCode: http.StatusNotFound,
+			}},
+			attempt: 1,
+			wantOK:  false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := cfg.retryDelay(tt.err, tt.attempt, now)
+			if ok != tt.wantOK {
+				t.Fatalf("retryDelay() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("retryDelay() got = %v, want [%v, %v]", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
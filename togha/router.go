@@ -0,0 +1,194 @@
+package togha
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+)
+
+// SourceDescriber is implemented by DispatchRequest which knows where the event came from.
+// routing rules match against these values.
+type SourceDescriber interface {
+	// Source returns event source name. e.g. slack, kintone
+	Source() string
+	// SourceAttributes returns source specific values. e.g. channel for slack, app for kintone
+	SourceAttributes() map[string]string
+}
+
+// RoutingRule selects receivers for matched events.
+// all conditions in a rule must match. empty condition matches everything.
+type RoutingRule struct {
+	Name string `json:"name"`
+	// Source matches SourceDescriber.Source.
+	Source string `json:"source,omitempty"`
+	// EventType is a glob pattern for event type. e.g. slack-event-reaction_added-*
+	EventType string `json:"event_type,omitempty"`
+	// EventTypeRegexp is a regular expression for event type.
+	EventTypeRegexp string `json:"event_type_regexp,omitempty"`
+	// Attributes are glob patterns for SourceDescriber.SourceAttributes.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Receivers are ReceiverRepo.String() to select one receiver. e.g. "owner/name:workflow@ref"
+	// "owner/name" selects every receiver of the repository. both are prefixed by the host for GitHub Enterprise Server.
+	Receivers []string `json:"receivers"`
+
+	eventTypeRegexp *regexp.Regexp
+	receivers       []*ReceiverRepo
+}
+
+// Router selects receivers for the event.
+// if there are no rules, all receivers get every event.
+type Router struct {
+	rules     []*RoutingRule
+	receivers []*ReceiverRepo
+}
+
+// NewRouter validates rules against receivers. rules are copied, the caller's rules are kept as is.
+func NewRouter(rules []*RoutingRule, receivers []*ReceiverRepo) (*Router, error) {
+	receiverMap := make(map[string]*ReceiverRepo, len(receivers))
+	repoMap := make(map[string][]*ReceiverRepo, len(receivers))
+	for _, receiver := range receivers {
+		receiverMap[receiver.String()] = receiver
+		repoMap[receiver.repoName()] = append(repoMap[receiver.repoName()], receiver)
+	}
+
+	copied := make([]*RoutingRule, 0, len(rules))
+	for idx, rule := range rules {
+		rule := *rule
+		copied = append(copied, &rule)
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", idx)
+		}
+
+		if rule.EventType != "" {
+			if _, err := path.Match(rule.EventType, ""); err != nil {
+				return nil, fmt.Errorf("routing rule %s: invalid event_type pattern %q: %w", name, rule.EventType, err)
+			}
+		}
+		if rule.EventTypeRegexp != "" {
+			re, err := regexp.Compile(rule.EventTypeRegexp)
+			if err != nil {
+				return nil, fmt.Errorf("routing rule %s: invalid event_type_regexp: %w", name, err)
+			}
+			rule.eventTypeRegexp = re
+		}
+		for key, pattern := range rule.Attributes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("routing rule %s: invalid attributes.%s pattern %q: %w", name, key, pattern, err)
+			}
+		}
+		if len(rule.Receivers) == 0 {
+			return nil, fmt.Errorf("routing rule %s: receivers are required", name)
+		}
+		rule.receivers = nil
+		for _, s := range rule.Receivers {
+			if repos, ok := repoMap[s]; ok {
+				rule.receivers = append(rule.receivers, repos...)
+				continue
+			}
+			receiver, ok := receiverMap[s]
+			if !ok {
+				return nil, fmt.Errorf("routing rule %s: unknown receiver %s", name, s)
+			}
+			rule.receivers = append(rule.receivers, receiver)
+		}
+	}
+
+	return &Router{
+		rules:     copied,
+		receivers: receivers,
+	}, nil
+}
+
+// ParseRoutingRules parses JSON array of RoutingRule.
+func ParseRoutingRules(b []byte) ([]*RoutingRule, error) {
+	var rules []*RoutingRule
+	err := json.Unmarshal(b, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// routingRulesFromEnv reads GHA_ROUTES or GHA_ROUTES_FILE. returns nil if both are empty.
+func routingRulesFromEnv() ([]*RoutingRule, error) {
+	ghaRoutes := os.Getenv("GHA_ROUTES")
+	ghaRoutesFile := os.Getenv("GHA_ROUTES_FILE")
+	switch {
+	case ghaRoutes != "" && ghaRoutesFile != "":
+		return nil, errors.New("GHA_ROUTES and GHA_ROUTES_FILE are exclusive")
+	case ghaRoutes != "":
+		return ParseRoutingRules([]byte(ghaRoutes))
+	case ghaRoutesFile != "":
+		b, err := os.ReadFile(ghaRoutesFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParseRoutingRules(b)
+	default:
+		return nil, nil
+	}
+}
+
+// Route returns receivers of all matched rules in ReceiverRepos order.
+func (router *Router) Route(eventType string, req DispatchRequest) []*ReceiverRepo {
+	if len(router.rules) == 0 {
+		return router.receivers
+	}
+
+	var source string
+	var attrs map[string]string
+	if sd, ok := req.(SourceDescriber); ok {
+		source = sd.Source()
+		attrs = sd.SourceAttributes()
+	}
+
+	matched := make(map[*ReceiverRepo]bool)
+	for _, rule := range router.rules {
+		if !rule.match(eventType, source, attrs) {
+			continue
+		}
+		for _, receiver := range rule.receivers {
+			matched[receiver] = true
+		}
+	}
+
+	var receivers []*ReceiverRepo
+	for _, receiver := range router.receivers {
+		if matched[receiver] {
+			receivers = append(receivers, receiver)
+		}
+	}
+
+	return receivers
+}
+
+func (rule *RoutingRule) match(eventType, source string, attrs map[string]string) bool {
+	if rule.Source != "" && rule.Source != source {
+		return false
+	}
+	if rule.EventType != "" {
+		if ok, _ := path.Match(rule.EventType, eventType); !ok {
+			return false
+		}
+	}
+	if rule.eventTypeRegexp != nil && !rule.eventTypeRegexp.MatchString(eventType) {
+		return false
+	}
+
+	for key, pattern := range rule.Attributes {
+		v, ok := attrs[key]
+		if !ok {
+			return false
+		}
+		if ok, _ := path.Match(pattern, v); !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,179 @@
+package togha
+
+import (
+	"testing"
+)
+
+type testSourceRequest struct {
+	testDispatchRequest
+	source string
+	attrs  map[string]string
+}
+
+func (req *testSourceRequest) Source() string {
+	return req.source
+}
+
+func (req *testSourceRequest) SourceAttributes() map[string]string {
+	return req.attrs
+}
+
+func TestRouter_Route(t *testing.T) {
+	receivers := []*ReceiverRepo{
+		{Owner: "vvakame", Name: "issues"},
+		{Owner: "vvakame", Name: "kintone"},
+		{Owner: "vvakame", Name: "all"},
+	}
+	rules, err := ParseRoutingRules([]byte(`[
+		{"name": "issue", "source": "slack", "event_type": "slack-event-reaction_added-create-issue", "receivers": ["vvakame/issues"]},
+		{"name": "kintone", "source": "kintone", "attributes": {"app": "12"}, "receivers": ["vvakame/kintone"]},
+		{"name": "all", "event_type_regexp": "^(slack|kintone)-event-", "receivers": ["vvakame/all"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := NewRouter(rules, receivers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		eventType string
+		req       DispatchRequest
+		want      []string
+	}{
+		{
+			name:      "slack issue",
+			eventType: "slack-event-reaction_added-create-issue",
+			req:       &testSourceRequest{source: "slack", attrs: map[string]string{"channel": "C01DAR4CQCX"}},
+			want:      []string{"vvakame/issues", "vvakame/all"},
+		},
+		{
+			name:      "kintone app matched",
+			eventType: "kintone-event-ADD_RECORD",
+			req:       &testSourceRequest{source: "kintone", attrs: map[string]string{"app": "12"}},
+			want:      []string{"vvakame/kintone", "vvakame/all"},
+		},
+		{
+			name:      "kintone app unmatched",
+			eventType: "kintone-event-ADD_RECORD",
+			req:       &testSourceRequest{source: "kintone", attrs: map[string]string{"app": "13"}},
+			want:      []string{"vvakame/all"},
+		},
+		{
+			name:      "no source",
+			eventType: "unknown",
+			req:       &testDispatchRequest{},
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := router.Route(tt.eventType, tt.req)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Route() got = %v, want %v", got, tt.want)
+			}
+			for idx, receiver := range got {
+				if v := receiver.String(); v != tt.want[idx] {
+					t.Errorf("Route() got[%d] = %v, want %v", idx, v, tt.want[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestNewRouter_invalid(t *testing.T) {
+	receivers := []*ReceiverRepo{
+		{Owner: "vvakame", Name: "se2gha"},
+	}
+	tests := []struct {
+		name string
+		rule *RoutingRule
+	}{
+		{
+			name: "unknown receiver",
+			rule: &RoutingRule{Receivers: []string{"vvakame/unknown"}},
+		},
+		{
+			name: "no receivers",
+			rule: &RoutingRule{EventType: "slack-*"},
+		},
+		{
+			name: "broken glob",
+			rule: &RoutingRule{EventType: "slack-[", Receivers: []string{"vvakame/se2gha"}},
+		},
+		{
+			name: "broken regexp",
+			rule: &RoutingRule{EventTypeRegexp: "(", Receivers: []string{"vvakame/se2gha"}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewRouter([]*RoutingRule{tt.rule}, receivers)
+			if err == nil {
+				t.Error("NewRouter() expected error")
+			}
+		})
+	}
+}
+
+func TestNewRouter_receivers(t *testing.T) {
+	receivers := []*ReceiverRepo{
+		{Owner: "vvakame", Name: "se2gha"},
+		{Owner: "vvakame", Name: "se2gha", Target: TargetWorkflowDispatch, Workflow: "issue.yml", Ref: "main"},
+		{Owner: "vvakame", Name: "ghes", BaseURL: "https://github.example.com/api/v3/"},
+	}
+	tests := []struct {
+		name      string
+		receivers []string
+		want      []string
+	}{
+		{
+			name:      "exact",
+			receivers: []string{"vvakame/se2gha:issue.yml@main"},
+			want:      []string{"vvakame/se2gha:issue.yml@main"},
+		},
+		{
+			name:      "workflow by owner/name",
+			receivers: []string{"vvakame/se2gha"},
+			want:      []string{"vvakame/se2gha", "vvakame/se2gha:issue.yml@main"},
+		},
+		{
+			name:      "with host",
+			receivers: []string{"github.example.com/vvakame/ghes"},
+			want:      []string{"github.example.com/vvakame/ghes"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rule := &RoutingRule{EventType: "slack-*", Receivers: tt.receivers}
+			router, err := NewRouter([]*RoutingRule{rule}, receivers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rule.eventTypeRegexp != nil || rule.receivers != nil {
+				t.Error("NewRouter() modified the given rule")
+			}
+
+			got := router.Route("slack-event", &testDispatchRequest{})
+			if len(got) != len(tt.want) {
+				t.Fatalf("Route() got = %v, want %v", got, tt.want)
+			}
+			for idx, receiver := range got {
+				if v := receiver.String(); v != tt.want[idx] {
+					t.Errorf("Route() got[%d] = %v, want %v", idx, v, tt.want[idx])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package togha
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileSpool stores JSON records as one file per record in a directory.
+// a record is written to a temporary file, synced and renamed, so a crash never leaves a partial record.
+type fileSpool struct {
+	dir string
+}
+
+const spoolExt = ".json"
+
+func newFileSpool(dir string) (*fileSpool, error) {
+	if dir == "" {
+		return nil, errors.New("spool directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &fileSpool{dir: dir}, nil
+}
+
+// newSpoolID returns a ID that sorts in creation order.
+func newSpoolID(now time.Time) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(b)), nil
+}
+
+func (s *fileSpool) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid spool id: %s", id)
+	}
+
+	return filepath.Join(s.dir, id+spoolExt), nil
+}
+
+func (s *fileSpool) Put(id string, v interface{}) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, p)
+}
+
+func (s *fileSpool) Get(id string, v interface{}) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// List returns stored IDs in creation order.
+func (s *fileSpool) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, spoolExt))
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (s *fileSpool) Remove(id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,195 @@
+package togha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v50/github"
+)
+
+// VerifyMode decides what to do when receivers can not receive events.
+type VerifyMode string
+
+const (
+	// VerifyOff skips verification.
+	VerifyOff VerifyMode = "off"
+	// VerifyWarn reports problems but keeps running.
+	VerifyWarn VerifyMode = "warn"
+	// VerifyStrict refuses to start when any receiver has a problem.
+	VerifyStrict VerifyMode = "strict"
+)
+
+// ParseVerifyMode parses off, warn or strict. empty is VerifyWarn.
+func ParseVerifyMode(s string) (VerifyMode, error) {
+	switch mode := VerifyMode(strings.TrimSpace(s)); mode {
+	case "":
+		return VerifyWarn, nil
+	case VerifyOff, VerifyWarn, VerifyStrict:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("verify mode must be %s, %s or %s: %s", VerifyOff, VerifyWarn, VerifyStrict, s)
+	}
+}
+
+// VerifyModeFromEnv reads GHA_VERIFY_RECEIVERS.
+func VerifyModeFromEnv() (VerifyMode, error) {
+	mode, err := ParseVerifyMode(os.Getenv("GHA_VERIFY_RECEIVERS"))
+	if err != nil {
+		return "", fmt.Errorf("GHA_VERIFY_RECEIVERS: %w", err)
+	}
+
+	return mode, nil
+}
+
+// ReceiverVerification is a result of verifying one receiver.
+type ReceiverVerification struct {
+	Receiver string `json:"receiver"`
+	OK       bool   `json:"ok"`
+	// Problems prevent the receiver from getting events.
+	Problems []string `json:"problems,omitempty"`
+	// Warnings are things which could not be checked. e.g. Actions settings need admin permission.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ReceiverVerifier is implemented by EventDispatcher which sends events to GitHub.
+type ReceiverVerifier interface {
+	VerifyReceivers(ctx context.Context) []*ReceiverVerification
+}
+
+var _ ReceiverVerifier = (*gitHubEventDispatcher)(nil)
+
+// VerifyReceivers checks each receiver exists, the credential can dispatch events to it and Actions is enabled.
+func (dsp *gitHubEventDispatcher) VerifyReceivers(ctx context.Context) []*ReceiverVerification {
+	receivers := dsp.planner.router.receivers
+	vs := make([]*ReceiverVerification, len(receivers))
+
+	var wg sync.WaitGroup
+	for idx, receiver := range receivers {
+		idx, receiver := idx, receiver
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vs[idx] = dsp.verifyReceiver(ctx, receiver)
+		}()
+	}
+	wg.Wait()
+
+	return vs
+}
+
+func (dsp *gitHubEventDispatcher) verifyReceiver(ctx context.Context, receiver *ReceiverRepo) *ReceiverVerification {
+	v := &ReceiverVerification{Receiver: receiver.String()}
+	defer func() {
+		v.OK = len(v.Problems) == 0
+	}()
+	problemf := func(format string, a ...interface{}) {
+		v.Problems = append(v.Problems, fmt.Sprintf(format, a...))
+	}
+	warnf := func(format string, a ...interface{}) {
+		v.Warnings = append(v.Warnings, fmt.Sprintf(format, a...))
+	}
+
+	target := receiver.Target
+	if target == "" {
+		target = TargetRepositoryDispatch
+	}
+
+	ghCli, err := dsp.clients.Client(ctx, receiver)
+	if err != nil {
+		problemf("credential is not available: %s", err.Error())
+		return v
+	}
+
+	var repo *github.Repository
+	err = dsp.call(ctx, func() (err error) {
+		repo, _, err = ghCli.Repositories.Get(ctx, receiver.Owner, receiver.Name)
+		return err
+	})
+	switch statusOf(err) {
+	case 0:
+	case http.StatusUnauthorized:
+		problemf("credential is rejected: %s", err.Error())
+		return v
+	case http.StatusNotFound:
+		problemf("repository is not found, or the credential can not see it")
+		return v
+	default:
+		problemf("failed to get repository: %s", err.Error())
+		return v
+	}
+
+	if repo.GetArchived() {
+		problemf("repository is archived")
+	}
+	if repo.Permissions == nil {
+		// installation tokens of GitHub App do not tell permissions.
+		warnf("permissions of the credential are unknown")
+	} else if !repo.Permissions["push"] {
+		problemf("credential does not have write access, which %s requires", target)
+	}
+
+	var actions *github.ActionsPermissionsRepository
+	err = dsp.call(ctx, func() (err error) {
+		actions, _, err = ghCli.Repositories.GetActionsPermissions(ctx, receiver.Owner, receiver.Name)
+		return err
+	})
+	switch statusOf(err) {
+	case 0:
+		if !actions.GetEnabled() {
+			problemf("GitHub Actions is disabled")
+		}
+	case http.StatusForbidden, http.StatusNotFound:
+		warnf("could not check whether GitHub Actions is enabled. it requires admin permission")
+	default:
+		warnf("could not check whether GitHub Actions is enabled: %s", err.Error())
+	}
+
+	if target == TargetWorkflowDispatch {
+		var workflow *github.Workflow
+		err = dsp.call(ctx, func() (err error) {
+			workflow, _, err = ghCli.Actions.GetWorkflowByFileName(ctx, receiver.Owner, receiver.Name, receiver.Workflow)
+			return err
+		})
+		switch statusOf(err) {
+		case 0:
+			if state := workflow.GetState(); state != "active" {
+				problemf("workflow %s is %s", receiver.Workflow, state)
+			}
+		case http.StatusNotFound:
+			problemf("workflow %s is not found on the default branch", receiver.Workflow)
+		default:
+			problemf("failed to get workflow %s: %s", receiver.Workflow, err.Error())
+		}
+	}
+
+	return v
+}
+
+// call calls f in the budget of the limiter.
+func (dsp *gitHubEventDispatcher) call(ctx context.Context, f func() error) error {
+	release, err := dsp.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return f()
+}
+
+// statusOf returns HTTP status code of GitHub API error. 0 means no error, -1 means not an API error.
+func statusOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return errResp.Response.StatusCode
+	}
+
+	return -1
+}
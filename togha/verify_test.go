@@ -0,0 +1,81 @@
+package togha
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEventDispatcher_VerifyReceivers(t *testing.T) {
+	ctx := context.Background()
+
+	responses := map[string]string{
+		"/repos/vvakame/se2gha":                                      `{"permissions":{"push":true}}`,
+		"/repos/vvakame/se2gha/actions/permissions":                  `{"enabled":true}`,
+		"/repos/vvakame/readonly":                                    `{"permissions":{"push":false}}`,
+		"/repos/vvakame/app":                                         `{}`,
+		"/repos/vvakame/app/actions/permissions":                     `{"enabled":false}`,
+		"/repos/vvakame/workflow":                                    `{"permissions":{"push":true}}`,
+		"/repos/vvakame/workflow/actions/permissions":                `{"enabled":true}`,
+		"/repos/vvakame/workflow/actions/workflows/issue.yml":        `{"state":"disabled_manually"}`,
+		"/repos/vvakame/workflow/actions/workflows/issue-active.yml": `{"state":"active"}`,
+	}
+	client := newTestGitHubClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	dsp, err := NewEventDispatcher(ctx, &EventDispatcherConfig{
+		GitHubClient: client,
+		ReceiverRepos: []*ReceiverRepo{
+			{Owner: "vvakame", Name: "se2gha"},
+			{Owner: "vvakame", Name: "missing"},
+			{Owner: "vvakame", Name: "readonly"},
+			{Owner: "vvakame", Name: "app"},
+			{Owner: "vvakame", Name: "workflow", Target: TargetWorkflowDispatch, Workflow: "issue.yml", Ref: "main"},
+			{Owner: "vvakame", Name: "workflow", Target: TargetWorkflowDispatch, Workflow: "issue-active.yml", Ref: "main"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		receiver string
+		problems string
+		warnings string
+	}{
+		{"vvakame/se2gha", "", ""},
+		{"vvakame/missing", "repository is not found, or the credential can not see it", ""},
+		{"vvakame/readonly", "credential does not have write access, which repository_dispatch requires", "could not check whether GitHub Actions is enabled. it requires admin permission"},
+		{"vvakame/app", "GitHub Actions is disabled", "permissions of the credential are unknown"},
+		{"vvakame/workflow:issue.yml@main", "workflow issue.yml is disabled_manually", ""},
+		{"vvakame/workflow:issue-active.yml@main", "", ""},
+	}
+	vs := dsp.(ReceiverVerifier).VerifyReceivers(ctx)
+	if len(vs) != len(tests) {
+		t.Fatalf("unexpected verifications: %d", len(vs))
+	}
+	for idx, tt := range tests {
+		t.Run(tt.receiver, func(t *testing.T) {
+			v := vs[idx]
+			if v.Receiver != tt.receiver {
+				t.Errorf("unexpected receiver: %s", v.Receiver)
+			}
+			if v.OK != (tt.problems == "") {
+				t.Errorf("unexpected ok: %v", v.OK)
+			}
+			if s := strings.Join(v.Problems, "; "); s != tt.problems {
+				t.Errorf("unexpected problems: %s", s)
+			}
+			if s := strings.Join(v.Warnings, "; "); s != tt.warnings {
+				t.Errorf("unexpected warnings: %s", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package togha
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// maxWorkflowInputs is the upper limit of inputs which workflow_dispatch API accepts.
+const maxWorkflowInputs = 10
+
+// workflowInputs maps top-level payload fields to workflow_dispatch inputs.
+// strings, numbers and booleans are passed as is, so workflows can declare typed inputs.
+// objects and arrays are passed as JSON string. use fromJSON() in the workflow.
+func workflowInputs(payload json.RawMessage, allowList []string) (map[string]interface{}, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(payload) != 0 {
+		err := json.Unmarshal(payload, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("payload must be JSON object for workflow_dispatch: %w", err)
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	if len(allowList) != 0 {
+		for _, key := range allowList {
+			if _, ok := fields[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+	} else {
+		for key := range fields {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if len(keys) > maxWorkflowInputs {
+		return nil, fmt.Errorf("workflow_dispatch accepts up to %d inputs, got %d: %v", maxWorkflowInputs, len(keys), keys)
+	}
+
+	inputs := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		raw := bytes.TrimSpace(fields[key])
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			continue
+		}
+
+		switch raw[0] {
+		case '{', '[':
+			inputs[key] = string(raw)
+		default:
+			var v interface{}
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return nil, err
+			}
+			inputs[key] = v
+		}
+	}
+
+	return inputs, nil
+}
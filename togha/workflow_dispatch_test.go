@@ -0,0 +1,48 @@
+package togha
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_workflowInputs(t *testing.T) {
+	payload := json.RawMessage(`{"title": "hi", "priority": 2, "draft": true, "labels": ["bug"], "empty": null}`)
+
+	tests := []struct {
+		name      string
+		allowList []string
+		want      map[string]interface{}
+	}{
+		{
+			name: "all fields",
+			want: map[string]interface{}{
+				"title":    "hi",
+				"priority": float64(2),
+				"draft":    true,
+				"labels":   `["bug"]`,
+			},
+		},
+		{
+			name:      "allow list",
+			allowList: []string{"title", "missing"},
+			want: map[string]interface{}{
+				"title": "hi",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := workflowInputs(payload, tt.allowList)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("workflowInputs() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
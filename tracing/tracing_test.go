@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestTraceParent(t *testing.T) {
+	ctx := context.Background()
+
+	if v := TraceParent(ctx); v != "" {
+		t.Errorf("unexpected traceparent: %s", v)
+	}
+
+	tp := sdktrace.NewTracerProvider()
+	defer func() { _ = tp.Shutdown(ctx) }()
+	ctx, span := tp.Tracer("test").Start(ctx, "inbound")
+	defer span.End()
+
+	traceParent := TraceParent(ctx)
+	if traceParent == "" {
+		t.Fatal("traceparent is empty")
+	}
+
+	// e.g. the outbox delivers the event in another goroutine.
+	restored := WithTraceParent(context.Background(), traceParent)
+	if v := TraceID(restored); v != span.SpanContext().TraceID().String() {
+		t.Errorf("unexpected trace id: %s", v)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/vvakame/se2gha/config"
+	"github.com/vvakame/se2gha/togha"
+)
+
+// verifyModeOf returns dispatch.verify_receivers, or GHA_VERIFY_RECEIVERS if cfg is nil.
+func verifyModeOf(cfg *config.Config) (togha.VerifyMode, error) {
+	if cfg != nil {
+		return cfg.VerifyMode(), nil
+	}
+
+	return togha.VerifyModeFromEnv()
+}
+
+// verifyReceivers reports receivers which can not get events.
+// it returns an error in strict mode. in warn mode, it runs in background and only logs problems.
+func verifyReceivers(ctx context.Context, dsp togha.EventDispatcher, mode togha.VerifyMode) error {
+	verifier, ok := dsp.(togha.ReceiverVerifier)
+	if !ok || mode == togha.VerifyOff {
+		return nil
+	}
+
+	run := func() error {
+		var failed []string
+		for _, v := range verifier.VerifyReceivers(ctx) {
+			for _, problem := range v.Problems {
+				log.Printf("receiver %s: %s", v.Receiver, problem)
+			}
+			for _, warning := range v.Warnings {
+				log.Printf("receiver %s: warning: %s", v.Receiver, warning)
+			}
+			if !v.OK {
+				failed = append(failed, v.Receiver)
+			}
+		}
+		if len(failed) != 0 {
+			return fmt.Errorf("receivers can not get events: %s", strings.Join(failed, ", "))
+		}
+
+		return nil
+	}
+
+	if mode == togha.VerifyStrict {
+		return run()
+	}
+	go func() {
+		if err := run(); err != nil {
+			log.Println(err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// verify checks receivers with GitHub API and prints problems. it exits non-zero if any receiver has a problem.
+func verify(w io.Writer, configPath string) int {
+	var cfg *config.Config
+	if configPath != "" {
+		var err error
+		cfg, err = config.Load(configPath)
+		if err != nil {
+			fmt.Fprintln(w, err.Error())
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+	dsp, err := newDispatcher(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(w, err.Error())
+		return 1
+	}
+	verifier, ok := dsp.(togha.ReceiverVerifier)
+	if !ok {
+		fmt.Fprintln(w, "receivers can not be verified")
+		return 1
+	}
+
+	code := 0
+	for _, v := range verifier.VerifyReceivers(ctx) {
+		status := "ok"
+		if !v.OK {
+			status = "fail"
+			code = 1
+		}
+		fmt.Fprintf(w, "%s\t%s\n", v.Receiver, status)
+		for _, problem := range v.Problems {
+			fmt.Fprintf(w, "\terror: %s\n", problem)
+		}
+		for _, warning := range v.Warnings {
+			fmt.Fprintf(w, "\twarning: %s\n", warning)
+		}
+	}
+
+	return code
+}